@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	kingpin "gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/uswitch/heimdall/pkg/config"
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+)
+
+// flagsSetByUser
+// - The names of the flags given on the command line, which take precedence over the configuration file
+func flagsSetByUser(args []string) map[string]bool {
+	set := map[string]bool{}
+	context, err := kingpin.CommandLine.ParseContext(args)
+	if err != nil {
+		return set
+	}
+
+	for _, element := range context.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			set[flag.Model().Name] = true
+		}
+	}
+
+	return set
+}
+
+// applyConfig
+// - Sets the options the configuration file sets, unless their flag was given
+func applyConfig(opts *options, cfg *config.Config, set map[string]bool) {
+	setString := func(flag, value string, option *string) {
+		if value != "" && !set[flag] {
+			*option = value
+		}
+	}
+	setMap := func(flag string, value map[string]string, option *map[string]string) {
+		if len(value) != 0 && !set[flag] {
+			*option = value
+		}
+	}
+
+	setString("namespace", cfg.Namespace, &opts.namespace)
+	setString("templates", cfg.Templates, &opts.templates)
+	if cfg.SyncInterval.Duration != 0 && !set["sync-interval"] {
+		opts.syncInterval = cfg.SyncInterval.Duration
+	}
+	setString("metrics-address", cfg.MetricsAddress, &opts.metricsAddress)
+	if cfg.ConsolidateRules != nil && !set["consolidate-rules"] {
+		opts.consolidateRules = *cfg.ConsolidateRules
+	}
+	setString("annotation-prefix", cfg.AnnotationPrefix, &opts.annotationPrefix)
+	setString("owner-registry", cfg.OwnerRegistry, &opts.ownerRegistry)
+
+	setMap("metadata-key", cfg.Metadata.Keys, &opts.metadataKeys)
+	setMap("default-metadata", cfg.Metadata.Defaults, &opts.defaultMetadata)
+
+	setString("dashboard-templates", cfg.Dashboards.Templates, &opts.dashboardTemplates)
+	setString("grafana-url", cfg.Dashboards.GrafanaURL, &opts.grafanaURL)
+	setMap("dashboard-label", cfg.Dashboards.Labels, &opts.dashboardLabels)
+
+	setString("monitor-templates", cfg.Monitors.Templates, &opts.monitorTemplates)
+
+	setString("probe-prober-url", cfg.Probes.ProberURL, &opts.proberURL)
+	setString("probe-module", cfg.Probes.Module, &opts.proberModule)
+	setMap("probe-label", cfg.Probes.Labels, &opts.probeLabels)
+
+	setString("rule-files-directory", cfg.RuleFiles.Directory, &opts.ruleFilesDirectory)
+	setString("prometheus-reload-url", cfg.RuleFiles.ReloadURL, &opts.prometheusReloadURL)
+}
+
+// restartRequired
+// - The settings which changed between current and reloaded but only take effect on a restart
+// - The informers and clients are set up once, and changing the annotation prefix would leave the objects generated with the old one behind
+func restartRequired(current, reloaded *options) []string {
+	changed := []string{}
+	if current.namespace != reloaded.namespace {
+		changed = append(changed, "namespace")
+	}
+	if current.syncInterval != reloaded.syncInterval {
+		changed = append(changed, "syncInterval")
+	}
+	if current.metricsAddress != reloaded.metricsAddress {
+		changed = append(changed, "metricsAddress")
+	}
+	if current.annotationPrefix != reloaded.annotationPrefix {
+		changed = append(changed, "annotationPrefix")
+	}
+	if current.ownerRegistry != reloaded.ownerRegistry {
+		changed = append(changed, "ownerRegistry")
+	}
+	// The rule sink is set up once, with the informers it needs
+	if current.ruleFilesDirectory != reloaded.ruleFilesDirectory {
+		changed = append(changed, "ruleFiles.directory")
+	}
+	if current.prometheusReloadURL != reloaded.prometheusReloadURL {
+		changed = append(changed, "ruleFiles.reloadURL")
+	}
+	// Services are only watched when monitors are generated, the directory itself can change
+	if (current.monitorTemplates == "") != (reloaded.monitorTemplates == "") {
+		changed = append(changed, "monitors.templates")
+	}
+
+	return changed
+}
+
+// reloadOptions
+// - The options for a reloaded configuration file, settings which need a restart keep their current value and are reported
+func reloadOptions(flagOpts, current *options, cfg *config.Config, set map[string]bool) *options {
+	reloaded := *flagOpts
+	applyConfig(&reloaded, cfg, set)
+
+	if changed := restartRequired(current, &reloaded); len(changed) != 0 {
+		warnMessage := fmt.Sprintf("[config][%s] %s changed, restart Heimdall to apply", current.config, strings.Join(changed, ", "))
+		log.Sugar.Warnf(warnMessage)
+		sentryclient.SentryMessage(warnMessage)
+	}
+	reloaded.namespace = current.namespace
+	reloaded.syncInterval = current.syncInterval
+	reloaded.metricsAddress = current.metricsAddress
+	reloaded.annotationPrefix = current.annotationPrefix
+	reloaded.ownerRegistry = current.ownerRegistry
+	reloaded.ruleFilesDirectory = current.ruleFilesDirectory
+	reloaded.prometheusReloadURL = current.prometheusReloadURL
+	if (current.monitorTemplates == "") != (reloaded.monitorTemplates == "") {
+		reloaded.monitorTemplates = current.monitorTemplates
+	}
+
+	return &reloaded
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// templateDocs
+// - Prints Markdown documentation for the templates in directory
+func templateDocs(directory string) error {
+	return templates.Docs(directory, os.Stdout)
+}
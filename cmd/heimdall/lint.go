@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// lintTemplates
+// - Prints a diagnostic for every problem in the templates directory and reports whether there were any
+func lintTemplates(directory string) (bool, error) {
+	diagnostics, err := templates.Lint(directory)
+	if err != nil {
+		return false, err
+	}
+
+	for _, diagnostic := range diagnostics {
+		fmt.Fprintln(os.Stdout, diagnostic)
+	}
+
+	return len(diagnostics) == 0, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"os"
 	"time"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -10,23 +12,55 @@ import (
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog"
 
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	prominformers "github.com/prometheus-operator/prometheus-operator/pkg/client/informers/externalversions"
 	promclientset "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned"
 
+	"github.com/uswitch/heimdall/pkg/alertmanager"
+	"github.com/uswitch/heimdall/pkg/config"
 	"github.com/uswitch/heimdall/pkg/controller"
+	"github.com/uswitch/heimdall/pkg/metrics"
+	"github.com/uswitch/heimdall/pkg/rulesink"
 	"github.com/uswitch/heimdall/pkg/templates"
 )
 
+// configReloadInterval is how often the configuration file is checked for changes
+const configReloadInterval = 30 * time.Second
+
 type options struct {
-	kubeconfig   string
-	namespace    string
-	debug        bool
-	templates    string
-	syncInterval time.Duration
+	config         string
+	kubeconfig     string
+	namespace      string
+	debug          bool
+	templates      string
+	syncInterval   time.Duration
+	metricsAddress string
+
+	consolidateRules bool
+	ownerRegistry    string
+	annotationPrefix string
+	metadataKeys     map[string]string
+	defaultMetadata  map[string]string
+
+	dashboardTemplates string
+	grafanaURL         string
+	dashboardLabels    map[string]string
+
+	monitorTemplates string
+
+	proberURL    string
+	proberModule string
+	probeLabels  map[string]string
+
+	ruleFilesDirectory  string
+	prometheusReloadURL string
 }
 
 func createClientConfig(opts *options) (*rest.Config, error) {
@@ -37,13 +71,52 @@ func createClientConfig(opts *options) (*rest.Config, error) {
 }
 
 func main() {
-	opts := &options{}
+	// kingpin adds map flags' values to the maps, so they can't be nil
+	opts := &options{
+		metadataKeys:    map[string]string{},
+		defaultMetadata: map[string]string{},
+		dashboardLabels: map[string]string{},
+		probeLabels:     map[string]string{},
+	}
+	kingpin.Flag("config", "Versioned YAML configuration file, flags take precedence over it, rendering settings are reloaded when it changes").StringVar(&opts.config)
 	kingpin.Flag("kubeconfig", "Path to kubeconfig.").StringVar(&opts.kubeconfig)
 	kingpin.Flag("namespace", "Namespace to monitor").Default(v1.NamespaceAll).StringVar(&opts.namespace)
 	kingpin.Flag("debug", "Debug mode").Default("false").BoolVar(&opts.debug)
 	kingpin.Flag("templates", "Directory for the templates").Default("templates").StringVar(&opts.templates)
 	kingpin.Flag("sync-interval", "Synchronize list of Ingress / Deployments resources this frequently").Default("1m").DurationVar(&opts.syncInterval)
-	kingpin.Parse()
+	kingpin.Flag("consolidate-rules", "Merge the PrometheusRules rendered for an object into one per workload instead of one per template").Default("false").BoolVar(&opts.consolidateRules)
+	kingpin.Flag("annotation-prefix", "Prefix of the annotations which select templates, installs with different prefixes leave each other's objects alone").Default(templates.DefaultAnnotationPrefix).StringVar(&opts.annotationPrefix)
+	kingpin.Flag("metadata-key", "Annotation or label key a metadata field is read from, as field=key, templates get every field in .Meta").StringMapVar(&opts.metadataKeys)
+	kingpin.Flag("default-metadata", "Metadata for objects which get none from their annotations, Deployment or Namespace, as owner=team, any field set with --metadata-key").StringMapVar(&opts.defaultMetadata)
+	kingpin.Flag("probe-prober-url", "Address of the blackbox exporter, as host:port, Probes aren't generated for Ingresses unless set").StringVar(&opts.proberURL)
+	kingpin.Flag("probe-module", "Blackbox exporter module Probes use unless the Ingress sets one").Default("http_2xx").StringVar(&opts.proberModule)
+	kingpin.Flag("probe-label", "Label for the generated Probes, as key=value, to match the Prometheus probeSelector").StringMapVar(&opts.probeLabels)
+
+	runCmd := kingpin.Command("run", "Run the Heimdall controller against a cluster").Default()
+	runCmd.Flag("metrics-address", "Address to serve Prometheus metrics on").Default(":8080").StringVar(&opts.metricsAddress)
+	runCmd.Flag("dashboard-templates", "Directory for the Grafana dashboard templates, dashboards aren't generated unless set").StringVar(&opts.dashboardTemplates)
+	runCmd.Flag("grafana-url", "URL of the Grafana the dashboards are loaded into, alerts link to their dashboard there").StringVar(&opts.grafanaURL)
+	runCmd.Flag("dashboard-label", "Label for the dashboard ConfigMaps, as key=value, grafana_dashboard=1 unless set").StringMapVar(&opts.dashboardLabels)
+	runCmd.Flag("monitor-templates", "Directory for the ServiceMonitor and PodMonitor templates, monitors aren't generated unless set").StringVar(&opts.monitorTemplates)
+	runCmd.Flag("rule-files-directory", "Directory to write a Prometheus rule file per workload to instead of creating PrometheusRules, for Prometheus servers the prometheus-operator doesn't manage").StringVar(&opts.ruleFilesDirectory)
+	runCmd.Flag("prometheus-reload-url", "URL to POST to when the rule files change, such as http://prometheus:9090/-/reload").StringVar(&opts.prometheusReloadURL)
+	runCmd.Flag("owner-registry", "Owner registry file, generates an AlertmanagerConfig routing each registered owner's alerts to its receivers").StringVar(&opts.ownerRegistry)
+
+	renderOpts := &renderOptions{namespacePrometheus: map[string]string{}}
+	renderCmd := kingpin.Command("render", "Render the PrometheusRules for manifests on disk, without a cluster connection")
+	renderCmd.Flag("default-namespace", "Namespace for objects which don't set one").Default(v1.NamespaceDefault).StringVar(&renderOpts.defaultNamespace)
+	renderCmd.Flag("namespace-prometheus", "Prometheus instance for Deployments in a namespace, as namespace=prometheus").StringMapVar(&renderOpts.namespacePrometheus)
+	renderCmd.Arg("files", "Manifest files to read, - or none for stdin").StringsVar(&renderOpts.files)
+
+	lintCmd := kingpin.Command("lint", "Render every template with sample parameters and validate the PrometheusRules and PromQL they produce")
+
+	docsCmd := kingpin.Command("docs", "Print Markdown documentation for the templates, generated from their metadata")
+
+	var testFiles []string
+	testCmd := kingpin.Command("test", "Run rule unit tests against the templates, in the style of promtool test rules")
+	testCmd.Arg("test-files", "Test files to run").Required().ExistingFilesVar(&testFiles)
+
+	command := kingpin.Parse()
 
 	if opts.debug {
 		log.Setup(log.DEBUG_LEVEL)
@@ -51,6 +124,99 @@ func main() {
 		log.Setup(log.INFO_LEVEL)
 	}
 
+	// The options from the flags alone, a reloaded configuration file is applied to them again
+	flagOpts := *opts
+	setFlags := flagsSetByUser(os.Args[1:])
+	if opts.config != "" {
+		cfg, err := config.Load(opts.config)
+		if err != nil {
+			log.Sugar.Fatalf("Error loading config: %s", err.Error())
+		}
+		applyConfig(opts, cfg, setFlags)
+	}
+
+	switch command {
+	case renderCmd.FullCommand():
+		renderOpts.templates = opts.templates
+		renderOpts.consolidateRules = opts.consolidateRules
+		renderOpts.proberURL = opts.proberURL
+		renderOpts.proberModule = opts.proberModule
+		renderOpts.annotationPrefix = opts.annotationPrefix
+		renderOpts.metadataKeys = opts.metadataKeys
+		renderOpts.defaultMetadata = opts.defaultMetadata
+		if err := renderManifests(renderOpts); err != nil {
+			log.Sugar.Fatalf("Error rendering manifests: %s", err.Error())
+		}
+	case lintCmd.FullCommand():
+		ok, err := lintTemplates(opts.templates)
+		if err != nil {
+			log.Sugar.Fatalf("Error linting templates: %s", err.Error())
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	case docsCmd.FullCommand():
+		if err := templateDocs(opts.templates); err != nil {
+			log.Sugar.Fatalf("Error generating template docs: %s", err.Error())
+		}
+	case testCmd.FullCommand():
+		if !testTemplates(opts.templates, testFiles) {
+			os.Exit(1)
+		}
+	case runCmd.FullCommand():
+		runController(opts, &flagOpts, setFlags)
+	}
+}
+
+// newTemplateManager
+// - Creates the template manager for opts, at startup and whenever the configuration file is reloaded
+func newTemplateManager(opts *options, kubeClient kubernetes.Interface, objectLister templates.ObjectLister, prometheusLister templates.PrometheusLister, thanosRulerLister templates.ThanosRulerLister) (*templates.PrometheusRuleTemplateManager, error) {
+	templateManager, err := templates.NewPrometheusRuleTemplateManager(opts.templates, kubeClient)
+	if err != nil {
+		return nil, fmt.Errorf("error creating template manager: %v", err)
+	}
+	templateManager.SetObjectLister(objectLister)
+	if prometheusLister != nil {
+		templateManager.SetPrometheusLister(prometheusLister)
+	}
+	if thanosRulerLister != nil {
+		templateManager.SetThanosRulerLister(thanosRulerLister)
+	}
+	templateManager.SetConsolidateRules(opts.consolidateRules)
+	if err := templateManager.SetAnnotationPrefix(opts.annotationPrefix); err != nil {
+		return nil, fmt.Errorf("error setting annotation prefix: %v", err)
+	}
+	if err := templateManager.SetMetadataKeys(opts.metadataKeys); err != nil {
+		return nil, fmt.Errorf("error setting metadata keys: %v", err)
+	}
+	if err := templateManager.SetDefaultMetadata(opts.defaultMetadata); err != nil {
+		return nil, fmt.Errorf("error setting default metadata: %v", err)
+	}
+
+	if opts.dashboardTemplates != "" {
+		if err := templateManager.LoadDashboardTemplates(opts.dashboardTemplates); err != nil {
+			return nil, fmt.Errorf("error loading dashboard templates: %v", err)
+		}
+		templateManager.SetGrafanaURL(opts.grafanaURL)
+		if len(opts.dashboardLabels) != 0 {
+			templateManager.SetDashboardLabels(opts.dashboardLabels)
+		}
+	}
+
+	if opts.monitorTemplates != "" {
+		if err := templateManager.LoadMonitorTemplates(opts.monitorTemplates); err != nil {
+			return nil, fmt.Errorf("error loading monitor templates: %v", err)
+		}
+	}
+
+	if opts.proberURL != "" {
+		templateManager.SetProber(opts.proberURL, opts.proberModule, opts.probeLabels)
+	}
+
+	return templateManager, nil
+}
+
+func runController(opts, flagOpts *options, setFlags map[string]bool) {
 	sentryclient.SetupSentry()
 	defer sentryclient.FlushSentry()
 
@@ -59,37 +225,107 @@ func main() {
 
 	stopCh := make(chan struct{}, 1)
 
-	config, err := createClientConfig(opts)
+	go metrics.Serve(opts.metricsAddress)
+
+	restConfig, err := createClientConfig(opts)
 	if err != nil {
 		log.Sugar.Fatalf("error creating client config: %s", err)
 		sentryclient.SentryErr(err)
 	}
 
-	kubeClient, err := kubernetes.NewForConfig(config)
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		log.Sugar.Fatalf("Error building kubernetes clientset: %s", err.Error())
 		sentryclient.SentryErr(err)
 	}
 
-	promClient, err := promclientset.NewForConfig(config)
+	promClient, err := promclientset.NewForConfig(restConfig)
 	if err != nil {
 		log.Sugar.Fatalf("ErError building prometheus operator clientset: %s", err.Error())
 		sentryclient.SentryErr(err)
 	}
 
-	templateManager, err := templates.NewPrometheusRuleTemplateManager(opts.templates, kubeClient)
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		log.Sugar.Fatalf("Error building metadata client: %s", err.Error())
+		sentryclient.SentryErr(err)
+	}
+
+	var ownerRegistry *alertmanager.Registry
+	if opts.ownerRegistry != "" {
+		ownerRegistry, err = alertmanager.LoadRegistry(opts.ownerRegistry)
+		if err != nil {
+			log.Sugar.Fatalf("Error loading owner registry: %s", err.Error())
+			sentryclient.SentryErr(err)
+		}
+	}
+
+	kubeInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, opts.syncInterval, opts.namespace, nil)
+	promInformerFactory := prominformers.NewFilteredSharedInformerFactory(promClient, opts.syncInterval, opts.namespace, nil)
+	metadataInformerFactory := metadatainformer.NewFilteredSharedInformerFactory(metadataClient, opts.syncInterval, opts.namespace, nil)
+	// Only the dashboard ConfigMaps Heimdall generated are watched, rather than every ConfigMap
+	dashboardInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, opts.syncInterval,
+		kubeinformers.WithNamespace(opts.namespace),
+		kubeinformers.WithTweakListOptions(func(options *v1.ListOptions) { options.LabelSelector = templates.DashboardSelector() }),
+	)
+
+	// Owners are resolved from the informer caches rather than with requests for every object
+	objectLister := templates.NewInformerObjectLister(kubeInformerFactory, metadataInformerFactory)
+	// Rules are matched against the ruleSelector and ruleNamespaceSelector of the Prometheus instances Heimdall can see
+	// Rule files are for Prometheus servers the prometheus-operator doesn't manage, which don't load PrometheusRules
+	var ruleSink rulesink.RuleSink
+	var prometheusLister templates.PrometheusLister
+	var thanosRulerLister templates.ThanosRulerLister
+	if opts.ruleFilesDirectory != "" {
+		fileSink, err := rulesink.NewFileSink(opts.ruleFilesDirectory, opts.prometheusReloadURL, opts.namespace)
+		if err != nil {
+			log.Sugar.Fatalf("Error setting up rule files: %s", err.Error())
+			sentryclient.SentryErr(err)
+		}
+		go fileSink.Run(stopCh)
+		ruleSink = fileSink
+	} else {
+		prometheusLister = promInformerFactory.Monitoring().V1().Prometheuses().Lister()
+		// ThanosRulers are only watched when their CRD is installed, an informer for a missing one would never sync
+		if controller.MonitoringResourceServed(kubeClient, monitoringv1.ThanosRulerName) {
+			thanosRulerLister = promInformerFactory.Monitoring().V1().ThanosRulers().Lister()
+		}
+	}
+	templateManager, err := newTemplateManager(opts, kubeClient, objectLister, prometheusLister, thanosRulerLister)
 	if err != nil {
-		log.Sugar.Fatalf("Error creating template manager: %s", err.Error())
+		log.Sugar.Fatalf("Error setting up templates: %s", err.Error())
 		sentryclient.SentryErr(err)
 	}
 
-	kubeInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, opts.syncInterval*time.Second, opts.namespace, nil)
-	promInformerFactory := prominformers.NewFilteredSharedInformerFactory(promClient, opts.syncInterval*time.Second, opts.namespace, nil)
 	controller := controller.NewController(
-		kubeClient, promClient, kubeInformerFactory, promInformerFactory, templateManager,
+		kubeClient, promClient, kubeInformerFactory, promInformerFactory, dashboardInformerFactory, templateManager, ownerRegistry, ruleSink,
 	)
 	go kubeInformerFactory.Start(stopCh)
+	go dashboardInformerFactory.Start(stopCh)
 	go promInformerFactory.Start(stopCh)
+	go metadataInformerFactory.Start(stopCh)
+
+	if ok := cache.WaitForCacheSync(stopCh, objectLister.HasSynced); !ok {
+		log.Sugar.Fatalf("Error waiting for the owner caches to sync")
+	}
+
+	if opts.config != "" {
+		go config.Watch(opts.config, configReloadInterval, stopCh, func(cfg *config.Config) {
+			reloaded := reloadOptions(flagOpts, opts, cfg, setFlags)
+			templateManager, err := newTemplateManager(reloaded, kubeClient, objectLister, prometheusLister, thanosRulerLister)
+			if err != nil {
+				warnMessage := fmt.Sprintf("[config][%s] %s, keeping the current config", opts.config, err)
+				log.Sugar.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				return
+			}
+
+			opts = reloaded
+			controller.SetTemplateManager(templateManager)
+			log.Sugar.Infow("config reloaded", "path", opts.config)
+		})
+	}
+
 	if err = controller.Run(stopCh); err != nil {
 		log.Sugar.Fatalf("Error running controller: %s", err.Error())
 		sentryclient.SentryErr(err)
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/uswitch/heimdall/pkg/render"
+)
+
+type renderOptions struct {
+	templates           string
+	defaultNamespace    string
+	namespacePrometheus map[string]string
+	files               []string
+	consolidateRules    bool
+	proberURL           string
+	proberModule        string
+	annotationPrefix    string
+	metadataKeys        map[string]string
+	defaultMetadata     map[string]string
+}
+
+// renderManifests
+// - Reads manifests from the given files or stdin and prints the PrometheusRules Heimdall would create for them
+func renderManifests(opts *renderOptions) error {
+	files := opts.files
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	objects := []runtime.Object{}
+	for _, file := range files {
+		decoded, err := decodeFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", file, err)
+		}
+		objects = append(objects, decoded...)
+	}
+
+	prometheusRules, renderErr := render.PrometheusRules(objects, render.Options{
+		Templates:           opts.templates,
+		DefaultNamespace:    opts.defaultNamespace,
+		NamespacePrometheus: opts.namespacePrometheus,
+		ConsolidateRules:    opts.consolidateRules,
+		ProberURL:           opts.proberURL,
+		ProberModule:        opts.proberModule,
+		AnnotationPrefix:    opts.annotationPrefix,
+		MetadataKeys:        opts.metadataKeys,
+		DefaultMetadata:     opts.defaultMetadata,
+	})
+	if prometheusRules == nil {
+		return renderErr
+	}
+
+	// Print the valid rules even if some were invalid, the error is still reported
+	if err := render.Write(os.Stdout, prometheusRules); err != nil {
+		return err
+	}
+
+	return renderErr
+}
+
+func decodeFile(file string) ([]runtime.Object, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return render.Decode(r)
+}
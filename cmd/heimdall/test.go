@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/uswitch/heimdall/pkg/ruletest"
+)
+
+// testTemplates
+// - Runs the rule unit tests in files against the templates in directory and reports whether they all passed
+func testTemplates(directory string, files []string) bool {
+	success := true
+	for _, file := range files {
+		fmt.Fprintf(os.Stdout, "Unit Testing: %s\n", file)
+
+		failures, err := ruletest.RunFile(directory, file)
+		if err != nil {
+			failures = []error{err}
+		}
+
+		if len(failures) == 0 {
+			fmt.Fprintln(os.Stdout, "  SUCCESS")
+			continue
+		}
+
+		success = false
+		fmt.Fprintln(os.Stdout, "  FAILED:")
+		for _, failure := range failures {
+			fmt.Fprintf(os.Stdout, "    %s\n", failure)
+		}
+	}
+
+	return success
+}
@@ -0,0 +1,237 @@
+package alertmanager
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	monitoringv1alpha1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"github.com/prometheus/common/model"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ManagedByLabel marks the AlertmanagerConfigs Heimdall generates, so it only updates and deletes its own
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	managedBy      = "heimdall"
+
+	// OwnerLabel is the alert label owners are routed on, set from the service.rvu.co.uk/owner annotation
+	OwnerLabel = "owner"
+
+	namePrefix = "heimdall-"
+)
+
+// Registry
+// - Maps the owners set in the service.rvu.co.uk/owner annotation to the receivers their alerts are routed to
+// - Labels are added to every generated AlertmanagerConfig, to match the Alertmanager's alertmanagerConfigSelector
+type Registry struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Owners map[string]Owner  `json:"owners"`
+}
+
+// Owner
+// - The receiver for an owner's alerts, any combination of Slack, PagerDuty and webhook
+// - Secrets are read by Alertmanager from the namespace the AlertmanagerConfig is created in
+type Owner struct {
+	GroupBy        []string   `json:"groupBy,omitempty"`
+	GroupWait      string     `json:"groupWait,omitempty"`
+	GroupInterval  string     `json:"groupInterval,omitempty"`
+	RepeatInterval string     `json:"repeatInterval,omitempty"`
+	Slack          *Slack     `json:"slack,omitempty"`
+	PagerDuty      *PagerDuty `json:"pagerduty,omitempty"`
+	Webhook        *Webhook   `json:"webhook,omitempty"`
+}
+
+// Slack
+// - Without APIURL the Alertmanager's global slack_api_url is used
+type Slack struct {
+	Channel string                    `json:"channel"`
+	APIURL  *corev1.SecretKeySelector `json:"apiURL,omitempty"`
+}
+
+type PagerDuty struct {
+	RoutingKey *corev1.SecretKeySelector `json:"routingKey"`
+	Severity   string                    `json:"severity,omitempty"`
+}
+
+type Webhook struct {
+	URL string `json:"url"`
+}
+
+// LoadRegistry
+// - Reads and validates the owner registry at path
+func LoadRegistry(path string) (*Registry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRegistry(content)
+}
+
+func parseRegistry(content []byte) (*Registry, error) {
+	registry := &Registry{}
+	if err := yaml.UnmarshalStrict(content, registry); err != nil {
+		return nil, fmt.Errorf("invalid owner registry: %v", err)
+	}
+
+	if err := registry.validate(); err != nil {
+		return nil, fmt.Errorf("invalid owner registry: %v", err)
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) validate() error {
+	for _, name := range r.ownerNames() {
+		// The owner is part of the AlertmanagerConfig's name
+		if errs := validation.IsDNS1123Label(name); len(errs) != 0 {
+			return fmt.Errorf("owner \"%s\": %s", name, strings.Join(errs, ", "))
+		}
+
+		if err := r.Owners[name].validate(); err != nil {
+			return fmt.Errorf("owner \"%s\": %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (o Owner) validate() error {
+	if o.Slack == nil && o.PagerDuty == nil && o.Webhook == nil {
+		return fmt.Errorf("no slack, pagerduty or webhook receiver")
+	}
+
+	if o.Slack != nil && o.Slack.Channel == "" {
+		return fmt.Errorf("slack needs a channel")
+	}
+
+	if o.PagerDuty != nil && (o.PagerDuty.RoutingKey == nil || o.PagerDuty.RoutingKey.Name == "" || o.PagerDuty.RoutingKey.Key == "") {
+		return fmt.Errorf("pagerduty needs a routingKey secret name and key")
+	}
+
+	if o.Webhook != nil && o.Webhook.URL == "" {
+		return fmt.Errorf("webhook needs a url")
+	}
+
+	for _, d := range []string{o.GroupWait, o.GroupInterval, o.RepeatInterval} {
+		if _, err := model.ParseDuration(d); d != "" && err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ownerNames
+// - The registered owners, sorted
+func (r *Registry) ownerNames() []string {
+	names := make([]string, 0, len(r.Owners))
+	for name := range r.Owners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// AlertmanagerConfigs
+// - Builds an AlertmanagerConfig in namespace for each registered owner in owners, sorted by name
+// - Owners which aren't registered are returned separately, their alerts only reach the Alertmanager's default route
+func (r *Registry) AlertmanagerConfigs(namespace string, owners []string) ([]*monitoringv1alpha1.AlertmanagerConfig, []string) {
+	sorted := append([]string{}, owners...)
+	sort.Strings(sorted)
+
+	configs := []*monitoringv1alpha1.AlertmanagerConfig{}
+	unregistered := []string{}
+	for i, name := range sorted {
+		if name == "" || (i > 0 && sorted[i-1] == name) {
+			continue
+		}
+
+		owner, ok := r.Owners[name]
+		if !ok {
+			unregistered = append(unregistered, name)
+			continue
+		}
+
+		configs = append(configs, r.alertmanagerConfig(namespace, name, owner))
+	}
+
+	return configs, unregistered
+}
+
+// alertmanagerConfig
+// - Routes the alerts labelled with the owner to its receiver
+// - Prometheus Operator adds a matcher on the namespace label, so it only routes alerts for objects in namespace
+func (r *Registry) alertmanagerConfig(namespace, name string, owner Owner) *monitoringv1alpha1.AlertmanagerConfig {
+	labels := map[string]string{}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	labels[ManagedByLabel] = managedBy
+
+	receiver := monitoringv1alpha1.Receiver{Name: name}
+	if owner.Slack != nil {
+		receiver.SlackConfigs = []monitoringv1alpha1.SlackConfig{{
+			Channel:      owner.Slack.Channel,
+			APIURL:       owner.Slack.APIURL,
+			SendResolved: boolPtr(true),
+		}}
+	}
+	if owner.PagerDuty != nil {
+		receiver.PagerDutyConfigs = []monitoringv1alpha1.PagerDutyConfig{{
+			RoutingKey:   owner.PagerDuty.RoutingKey,
+			Severity:     owner.PagerDuty.Severity,
+			SendResolved: boolPtr(true),
+		}}
+	}
+	if owner.Webhook != nil {
+		url := owner.Webhook.URL
+		receiver.WebhookConfigs = []monitoringv1alpha1.WebhookConfig{{
+			URL:          &url,
+			SendResolved: boolPtr(true),
+		}}
+	}
+
+	return &monitoringv1alpha1.AlertmanagerConfig{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1alpha1.SchemeGroupVersion.String(),
+			Kind:       monitoringv1alpha1.AlertmanagerConfigKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namePrefix + name,
+			Namespace: namespace,
+			Labels:    labels,
+		},
+		Spec: monitoringv1alpha1.AlertmanagerConfigSpec{
+			Route: &monitoringv1alpha1.Route{
+				Receiver:       name,
+				GroupBy:        owner.GroupBy,
+				GroupWait:      owner.GroupWait,
+				GroupInterval:  owner.GroupInterval,
+				RepeatInterval: owner.RepeatInterval,
+				Matchers: []monitoringv1alpha1.Matcher{{
+					Name:      OwnerLabel,
+					Value:     name,
+					MatchType: monitoringv1alpha1.MatchEqual,
+				}},
+			},
+			Receivers: []monitoringv1alpha1.Receiver{receiver},
+		},
+	}
+}
+
+// ManagedSelector
+// - Selects the AlertmanagerConfigs Heimdall generated
+func ManagedSelector() string {
+	return ManagedByLabel + "=" + managedBy
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
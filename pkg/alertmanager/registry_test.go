@@ -0,0 +1,87 @@
+package alertmanager
+
+import (
+	"testing"
+
+	monitoringv1alpha1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+const testRegistry = `
+labels:
+  alertmanagerConfig: heimdall
+owners:
+  team-shop:
+    groupBy: [alertname]
+    repeatInterval: 4h
+    slack:
+      channel: "#shop-alerts"
+    pagerduty:
+      routingKey:
+        name: shop-pagerduty
+        key: routing-key
+  team-search:
+    webhook:
+      url: https://hooks.example.com/search
+`
+
+func TestParseRegistry(t *testing.T) {
+	registry, err := parseRegistry([]byte(testRegistry))
+	assert.Assert(t, is.Nil(err))
+	assert.DeepEqual(t, registry.ownerNames(), []string{"team-search", "team-shop"})
+	assert.Equal(t, registry.Owners["team-shop"].PagerDuty.RoutingKey.Name, "shop-pagerduty")
+}
+
+func TestParseRegistryInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown field":     "owners:\n  team-shop:\n    email: {to: shop@example.com}\n",
+		"no receiver":       "owners:\n  team-shop:\n    groupBy: [alertname]\n",
+		"invalid name":      "owners:\n  Team_Shop:\n    webhook: {url: https://example.com}\n",
+		"no channel":        "owners:\n  team-shop:\n    slack: {}\n",
+		"no routing key":    "owners:\n  team-shop:\n    pagerduty: {severity: critical}\n",
+		"no url":            "owners:\n  team-shop:\n    webhook: {}\n",
+		"invalid duration":  "owners:\n  team-shop:\n    repeatInterval: often\n    webhook: {url: https://example.com}\n",
+		"owners not a list": "owners: [team-shop]\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseRegistry([]byte(content))
+			assert.ErrorContains(t, err, "invalid owner registry")
+		})
+	}
+}
+
+func TestAlertmanagerConfigs(t *testing.T) {
+	registry, err := parseRegistry([]byte(testRegistry))
+	assert.Assert(t, is.Nil(err))
+
+	configs, unregistered := registry.AlertmanagerConfigs("shop", []string{"team-shop", "", "team-unknown", "team-shop"})
+	assert.DeepEqual(t, unregistered, []string{"team-unknown"})
+	assert.Assert(t, is.Len(configs, 1))
+
+	config := configs[0]
+	assert.Equal(t, config.Name, "heimdall-team-shop")
+	assert.Equal(t, config.Namespace, "shop")
+	assert.DeepEqual(t, config.Labels, map[string]string{
+		"alertmanagerConfig":           "heimdall",
+		"app.kubernetes.io/managed-by": "heimdall",
+	})
+
+	route := config.Spec.Route
+	assert.Equal(t, route.Receiver, "team-shop")
+	assert.Equal(t, route.RepeatInterval, "4h")
+	assert.DeepEqual(t, route.GroupBy, []string{"alertname"})
+	assert.DeepEqual(t, route.Matchers, []monitoringv1alpha1.Matcher{{Name: "owner", Value: "team-shop", MatchType: monitoringv1alpha1.MatchEqual}})
+
+	assert.Assert(t, is.Len(config.Spec.Receivers, 1))
+	receiver := config.Spec.Receivers[0]
+	assert.Equal(t, receiver.Name, "team-shop")
+	assert.Equal(t, receiver.SlackConfigs[0].Channel, "#shop-alerts")
+	assert.Equal(t, receiver.PagerDutyConfigs[0].RoutingKey.Key, "routing-key")
+	assert.Assert(t, is.Len(receiver.WebhookConfigs, 0))
+
+	configs, _ = registry.AlertmanagerConfigs("search", []string{"team-search"})
+	assert.Equal(t, *configs[0].Spec.Receivers[0].WebhookConfigs[0].URL, "https://hooks.example.com/search")
+}
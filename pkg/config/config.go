@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/yaml"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+)
+
+// Version is the version of the configuration file format, files must set it
+const Version = "v1"
+
+// Config
+// - The Heimdall configuration file, every field can also be set with the flag of the same name, which takes precedence
+type Config struct {
+	Version string `json:"version"`
+
+	Namespace        string          `json:"namespace,omitempty"`
+	Templates        string          `json:"templates,omitempty"`
+	SyncInterval     metav1.Duration `json:"syncInterval,omitempty"`
+	MetricsAddress   string          `json:"metricsAddress,omitempty"`
+	ConsolidateRules *bool           `json:"consolidateRules,omitempty"`
+	AnnotationPrefix string          `json:"annotationPrefix,omitempty"`
+	OwnerRegistry    string          `json:"ownerRegistry,omitempty"`
+
+	Metadata   Metadata   `json:"metadata,omitempty"`
+	Dashboards Dashboards `json:"dashboards,omitempty"`
+	Monitors   Monitors   `json:"monitors,omitempty"`
+	Probes     Probes     `json:"probes,omitempty"`
+	RuleFiles  RuleFiles  `json:"ruleFiles,omitempty"`
+}
+
+// Metadata
+// - The keys metadata fields are read from, --metadata-key, and the cluster defaults, --default-metadata
+type Metadata struct {
+	Keys     map[string]string `json:"keys,omitempty"`
+	Defaults map[string]string `json:"defaults,omitempty"`
+}
+
+type Dashboards struct {
+	Templates  string            `json:"templates,omitempty"`
+	GrafanaURL string            `json:"grafanaURL,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+type Monitors struct {
+	Templates string `json:"templates,omitempty"`
+}
+
+type Probes struct {
+	ProberURL string            `json:"proberURL,omitempty"`
+	Module    string            `json:"module,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+// RuleFiles
+// - Writes Prometheus rule files to directory instead of creating PrometheusRules, --rule-files-directory, and POSTs to reloadURL when they change
+type RuleFiles struct {
+	Directory string `json:"directory,omitempty"`
+	ReloadURL string `json:"reloadURL,omitempty"`
+}
+
+// Load
+// - Reads and validates the configuration file at path
+func Load(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(content)
+}
+
+// Parse
+// - Unknown fields are errors, so misspelt settings aren't silently ignored
+func Parse(content []byte) (*Config, error) {
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	return config, nil
+}
+
+// validate
+// - The annotation prefix and metadata keys are validated by the template manager they're set on
+func (c *Config) validate() error {
+	if c.Version == "" {
+		return fmt.Errorf("version is required, the current version is %s", Version)
+	}
+	if c.Version != Version {
+		return fmt.Errorf("unsupported version \"%s\", the current version is %s", c.Version, Version)
+	}
+
+	if c.SyncInterval.Duration < 0 {
+		return fmt.Errorf("syncInterval %s is negative", c.SyncInterval.Duration)
+	}
+
+	if c.Dashboards.GrafanaURL != "" {
+		if u, err := url.Parse(c.Dashboards.GrafanaURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("dashboards.grafanaURL \"%s\" isn't an absolute URL", c.Dashboards.GrafanaURL)
+		}
+	}
+
+	if c.RuleFiles.ReloadURL != "" {
+		if u, err := url.Parse(c.RuleFiles.ReloadURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("ruleFiles.reloadURL \"%s\" isn't an absolute URL", c.RuleFiles.ReloadURL)
+		}
+	}
+
+	if c.Probes.ProberURL != "" && strings.Contains(c.Probes.ProberURL, "://") {
+		return fmt.Errorf("probes.proberURL \"%s\" should be host:port, without a scheme", c.Probes.ProberURL)
+	}
+
+	for field, labels := range map[string]map[string]string{"dashboards.labels": c.Dashboards.Labels, "probes.labels": c.Probes.Labels} {
+		if err := validateLabels(labels); err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+	}
+
+	return nil
+}
+
+func validateLabels(labels map[string]string) error {
+	for key, value := range labels {
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return fmt.Errorf("label \"%s\": %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+			return fmt.Errorf("label \"%s\" value \"%s\": %s", key, value, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Watch
+// - Calls onChange with the new configuration whenever the file at path changes, checking every interval until stopCh is closed
+// - The content is compared rather than the modification time, ConfigMap volumes replace files through symlinks
+// - Files which can't be read or are invalid are reported and skipped, the last valid configuration stays in use
+func Watch(path string, interval time.Duration, stopCh <-chan struct{}, onChange func(*Config)) {
+	last, _ := os.ReadFile(path)
+
+	wait.Until(func() {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			warnMessage := fmt.Sprintf("[config][%s] error reading config, keeping the current one: %s", path, err)
+			log.Sugar.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			return
+		}
+
+		if bytes.Equal(content, last) {
+			return
+		}
+		last = content
+
+		config, err := Parse(content)
+		if err != nil {
+			warnMessage := fmt.Sprintf("[config][%s] %s, keeping the current one", path, err)
+			log.Sugar.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			return
+		}
+
+		log.Sugar.Infow("config changed", "path", path)
+		onChange(config)
+	}, interval, stopCh)
+}
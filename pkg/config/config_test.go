@@ -0,0 +1,105 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+)
+
+const testConfig = `
+version: v1
+templates: /etc/heimdall/templates
+syncInterval: 2m
+consolidateRules: false
+annotationPrefix: team.example.com
+metadata:
+  keys:
+    owner: team.example.com/owner
+  defaults:
+    owner: team-platform
+dashboards:
+  templates: /etc/heimdall/dashboards
+  grafanaURL: https://grafana.example.com
+  labels:
+    grafana_dashboard: "1"
+probes:
+  proberURL: blackbox-exporter:9115
+ruleFiles:
+  directory: /etc/prometheus/rules
+  reloadURL: http://prometheus:9090/-/reload
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(testConfig))
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, config.Templates, "/etc/heimdall/templates")
+	assert.Equal(t, config.SyncInterval.Duration, 2*time.Minute)
+	assert.Assert(t, config.ConsolidateRules != nil && !*config.ConsolidateRules)
+	assert.DeepEqual(t, config.Metadata.Keys, map[string]string{"owner": "team.example.com/owner"})
+	assert.Equal(t, config.Dashboards.GrafanaURL, "https://grafana.example.com")
+	assert.Equal(t, config.Probes.ProberURL, "blackbox-exporter:9115")
+	assert.Equal(t, config.Monitors.Templates, "")
+	assert.Equal(t, config.RuleFiles.ReloadURL, "http://prometheus:9090/-/reload")
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := map[string]string{
+		"no version":           "templates: /templates\n",
+		"unsupported version":  "version: v2\n",
+		"unknown field":        "version: v1\ntemplate: /templates\n",
+		"unknown nested field": "version: v1\nprobes:\n  url: blackbox-exporter:9115\n",
+		"invalid duration":     "version: v1\nsyncInterval: often\n",
+		"negative duration":    "version: v1\nsyncInterval: -1m\n",
+		"relative grafana url": "version: v1\ndashboards:\n  grafanaURL: grafana\n",
+		"prober url scheme":    "version: v1\nprobes:\n  proberURL: http://blackbox-exporter:9115\n",
+		"relative reload url":  "version: v1\nruleFiles:\n  reloadURL: prometheus:9090/-/reload\n",
+		"invalid label":        "version: v1\nprobes:\n  labels:\n    \"team/\": shop\n",
+		"invalid label value":  "version: v1\ndashboards:\n  labels:\n    grafana_dashboard: \"not a value\"\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Parse([]byte(content))
+			assert.ErrorContains(t, err, "invalid config")
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.Assert(t, is.Nil(os.WriteFile(path, []byte("version: v1\ntemplates: /templates\n"), 0644)))
+
+	changes := make(chan *Config, 1)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go Watch(path, 10*time.Millisecond, stopCh, func(config *Config) {
+		changes <- config
+	})
+
+	// An invalid file is skipped, the next valid one is reported
+	time.Sleep(50 * time.Millisecond)
+	assert.Assert(t, is.Nil(os.WriteFile(path, []byte("version: v1\ntemplate: /other\n"), 0644)))
+	time.Sleep(50 * time.Millisecond)
+	assert.Assert(t, is.Nil(os.WriteFile(path, []byte("version: v1\ntemplates: /other\n"), 0644)))
+
+	select {
+	case config := <-changes:
+		assert.Equal(t, config.Templates, "/other")
+	case <-time.After(5 * time.Second):
+		t.Fatal("config change wasn't reported")
+	}
+
+	select {
+	case config := <-changes:
+		t.Fatalf("unexpected change %+v", config)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"fmt"
+
+	monitoringv1alpha1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"github.com/uswitch/heimdall/pkg/alertmanager"
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// enqueueNamespaceTo
+// - Queues the namespace of an object, the key for the owner workqueue
+func enqueueNamespaceTo(queue workqueue.RateLimitingInterface) func(interface{}) {
+	return func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			runtime.HandleError(err)
+			sentryclient.SentryErr(err)
+			return
+		}
+
+		namespace, _, err := cache.SplitMetaNamespaceKey(key)
+		if err != nil {
+			runtime.HandleError(err)
+			sentryclient.SentryErr(err)
+			return
+		}
+		queue.AddRateLimited(namespace)
+	}
+}
+
+// namespaceOwners
+// - The owners of the Ingresses and Deployments in namespace, Ingresses without one get theirs from a Deployment in the same namespace
+// - Objects without an owner annotation inherit the namespace's owner or the cluster default
+func (c *Controller) namespaceOwners(templateManager *templates.PrometheusRuleTemplateManager, namespace string) ([]string, error) {
+	owners := []string{}
+	objectOwner := func(obj metav1.Object) string {
+		if owner := templateManager.ObjectOwner(obj); owner != "" {
+			return owner
+		}
+		return templateManager.NamespaceOwner(namespace)
+	}
+
+	ingresses, err := c.ingressLister.Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, ingress := range ingresses {
+		owners = append(owners, objectOwner(ingress))
+	}
+
+	deployments, err := c.deploymentLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments {
+		owners = append(owners, objectOwner(deployment))
+	}
+
+	return owners, nil
+}
+
+// processOwners
+// - Syncs the AlertmanagerConfigs in a namespace so there's one for each registered owner with objects there
+// - The owner workqueue is keyed by namespace, which the runner passes as the name
+func (c *Controller) processOwners(_, namespace string) error {
+	templateManager := c.manager()
+	owners, err := c.namespaceOwners(templateManager, namespace)
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	newConfigs, unregistered := c.ownerRegistry.AlertmanagerConfigs(namespace, owners)
+	for _, owner := range unregistered {
+		log.Sugar.Debugw("owner isn't in the owner registry, its alerts aren't routed", "owner", owner, "namespace", namespace)
+	}
+	for _, newConfig := range newConfigs {
+		templateManager.MarkGenerated(newConfig)
+	}
+
+	managedConfigs, err := c.promclientset.MonitoringV1alpha1().AlertmanagerConfigs(namespace).List(c.ctx, metav1.ListOptions{LabelSelector: alertmanager.ManagedSelector()})
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	// Installs with other annotation prefixes manage their own AlertmanagerConfigs
+	oldConfigs := []*monitoringv1alpha1.AlertmanagerConfig{}
+	for _, managedConfig := range managedConfigs.Items {
+		if templateManager.Generated(managedConfig) {
+			oldConfigs = append(oldConfigs, managedConfig)
+		}
+	}
+
+	return c.syncAlertmanagerConfigs(namespace, oldConfigs, newConfigs)
+}
+
+// syncAlertmanagerConfigs
+// - Creates, updates and deletes the AlertmanagerConfigs Heimdall manages in a namespace so they match newConfigs
+// - oldConfigs are the ones this install generated, a config of the same name another install generated is left to it
+func (c *Controller) syncAlertmanagerConfigs(namespace string, oldConfigs, newConfigs []*monitoringv1alpha1.AlertmanagerConfig) error {
+	client := c.promclientset.MonitoringV1alpha1().AlertmanagerConfigs(namespace)
+
+	oldConfigsByName := map[string]*monitoringv1alpha1.AlertmanagerConfig{}
+	for _, oldConfig := range oldConfigs {
+		oldConfigsByName[oldConfig.GetName()] = oldConfig
+	}
+
+	newConfigsByName := map[string]bool{}
+	for _, newConfig := range newConfigs {
+		newConfigsByName[newConfig.GetName()] = true
+
+		if oldConfig, ok := oldConfigsByName[newConfig.GetName()]; ok {
+			newConfig.SetResourceVersion(oldConfig.GetResourceVersion())
+			if _, err := client.Update(c.ctx, newConfig, metav1.UpdateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return fmt.Errorf("error updating AlertmanagerConfig %s/%s: %v", namespace, newConfig.GetName(), err)
+			}
+			continue
+		}
+
+		if _, err := client.Create(c.ctx, newConfig, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+			// Another install already routes the owner's alerts in the namespace, a second config would notify twice
+			log.Sugar.Infow("AlertmanagerConfig belongs to an install with another annotation prefix, leaving it", "name", newConfig.GetName(), "namespace", namespace)
+			continue
+		} else if err != nil {
+			sentryclient.SentryErr(err)
+			return fmt.Errorf("error creating AlertmanagerConfig %s/%s: %v", namespace, newConfig.GetName(), err)
+		}
+	}
+
+	for _, oldConfig := range oldConfigs {
+		if newConfigsByName[oldConfig.GetName()] {
+			continue
+		}
+
+		if err := client.Delete(c.ctx, oldConfig.GetName(), metav1.DeleteOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return fmt.Errorf("error deleting AlertmanagerConfig %s/%s: %v", namespace, oldConfig.GetName(), err)
+		}
+	}
+
+	return nil
+}
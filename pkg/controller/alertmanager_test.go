@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	monitoringv1alpha1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1alpha1"
+	promfake "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/fake"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	apps "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/uswitch/heimdall/pkg/alertmanager"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+var testOwnerRegistry = &alertmanager.Registry{
+	Owners: map[string]alertmanager.Owner{
+		"team-shop":   {Webhook: &alertmanager.Webhook{URL: "https://hooks.example.com/shop"}},
+		"team-search": {Webhook: &alertmanager.Webhook{URL: "https://hooks.example.com/search"}},
+	},
+}
+
+// testOwnerObjects
+// - An Ingress owned by team-shop and a Deployment owned by team-search in the shop namespace
+func testOwnerObjects() []runtime.Object {
+	return []runtime.Object{
+		&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "shop",
+			Annotations: map[string]string{"service.rvu.co.uk/owner": "team-shop"},
+		}},
+		&apps.Deployment{ObjectMeta: metav1.ObjectMeta{
+			Name:        "search",
+			Namespace:   "shop",
+			Annotations: map[string]string{"service.rvu.co.uk/owner": "team-search"},
+		}},
+	}
+}
+
+func testAlertmanagerConfig(name, prefix, url string, managed bool) *monitoringv1alpha1.AlertmanagerConfig {
+	config := &monitoringv1alpha1.AlertmanagerConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", ResourceVersion: "1"},
+		Spec: monitoringv1alpha1.AlertmanagerConfigSpec{
+			Receivers: []monitoringv1alpha1.Receiver{{WebhookConfigs: []monitoringv1alpha1.WebhookConfig{{URL: &url}}}},
+		},
+	}
+	if managed {
+		config.Labels = map[string]string{alertmanager.ManagedByLabel: "heimdall"}
+	}
+	if prefix != "" {
+		config.Annotations = map[string]string{templates.GeneratedPrefixAnnotation: prefix}
+	}
+
+	return config
+}
+
+func alertmanagerConfigs(t *testing.T, client *promfake.Clientset) map[string]*monitoringv1alpha1.AlertmanagerConfig {
+	configs, err := client.MonitoringV1alpha1().AlertmanagerConfigs("shop").List(context.Background(), metav1.ListOptions{})
+	assert.Assert(t, is.Nil(err))
+
+	byName := map[string]*monitoringv1alpha1.AlertmanagerConfig{}
+	for _, config := range configs.Items {
+		byName[config.Name] = config
+	}
+
+	return byName
+}
+
+func configNames(configs map[string]*monitoringv1alpha1.AlertmanagerConfig) []string {
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func webhookURL(config *monitoringv1alpha1.AlertmanagerConfig) string {
+	return *config.Spec.Receivers[0].WebhookConfigs[0].URL
+}
+
+func TestProcessOwners(t *testing.T) {
+	c, _, promClient := testController(t, testManager(t, nil), testOwnerRegistry, testOwnerObjects(), []runtime.Object{
+		testAlertmanagerConfig("heimdall-team-shop", "", "https://hooks.example.com/old", true),
+		testAlertmanagerConfig("heimdall-team-gone", "", "https://hooks.example.com/gone", true),
+		testAlertmanagerConfig("heimdall-team-foreign", otherPrefix, "https://hooks.example.com/foreign", true),
+		testAlertmanagerConfig("manual", "", "https://hooks.example.com/manual", false),
+	})
+
+	assert.Assert(t, is.Nil(c.processOwners("", "shop")))
+
+	// The stale config is deleted, another install's and ones Heimdall doesn't manage are left
+	configs := alertmanagerConfigs(t, promClient)
+	assert.DeepEqual(t, configNames(configs), []string{"heimdall-team-foreign", "heimdall-team-search", "heimdall-team-shop", "manual"})
+	assert.Equal(t, webhookURL(configs["heimdall-team-shop"]), "https://hooks.example.com/shop")
+	assert.Equal(t, webhookURL(configs["heimdall-team-search"]), "https://hooks.example.com/search")
+	assert.Equal(t, webhookURL(configs["heimdall-team-foreign"]), "https://hooks.example.com/foreign")
+}
+
+func TestProcessOwnersOtherPrefix(t *testing.T) {
+	templateManager := testManager(t, func(templateManager *templates.PrometheusRuleTemplateManager) {
+		assert.Assert(t, is.Nil(templateManager.SetAnnotationPrefix(otherPrefix)))
+	})
+
+	c, _, promClient := testController(t, templateManager, testOwnerRegistry, testOwnerObjects(), []runtime.Object{
+		testAlertmanagerConfig("heimdall-team-shop", "", "https://hooks.example.com/default", true),
+		testAlertmanagerConfig("heimdall-team-gone", otherPrefix, "https://hooks.example.com/gone", true),
+	})
+
+	assert.Assert(t, is.Nil(c.processOwners("", "shop")))
+
+	// The default install already routes team-shop's alerts, so its config is left and only the install's own stale config is deleted
+	configs := alertmanagerConfigs(t, promClient)
+	assert.DeepEqual(t, configNames(configs), []string{"heimdall-team-search", "heimdall-team-shop"})
+	assert.Equal(t, webhookURL(configs["heimdall-team-shop"]), "https://hooks.example.com/default")
+	assert.Assert(t, templateManager.Generated(configs["heimdall-team-search"]))
+	assert.Assert(t, !templateManager.Generated(configs["heimdall-team-shop"]))
+}
@@ -2,23 +2,32 @@ package controller
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"reflect"
+	"sync/atomic"
 	"time"
 
 	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/metrics"
 	"github.com/uswitch/heimdall/pkg/sentryclient"
 	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
+	kuberuntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	lister "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	netlisters "k8s.io/client-go/listers/networking/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
@@ -26,6 +35,8 @@ import (
 	promlisters "github.com/prometheus-operator/prometheus-operator/pkg/client/listers/monitoring/v1"
 	promclientset "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned"
 
+	"github.com/uswitch/heimdall/pkg/alertmanager"
+	"github.com/uswitch/heimdall/pkg/rulesink"
 	"github.com/uswitch/heimdall/pkg/templates"
 )
 
@@ -34,7 +45,13 @@ type Controller struct {
 	kubeclientset kubernetes.Interface
 	promclientset promclientset.Interface
 
-	templateManager *templates.PrometheusRuleTemplateManager
+	// templateManager holds the *templates.PrometheusRuleTemplateManager, SetTemplateManager replaces it when the configuration is reloaded
+	templateManager atomic.Value
+
+	recorder record.EventRecorder
+
+	// ruleSink stores the rendered PrometheusRules, as PrometheusRule objects unless another sink is given
+	ruleSink rulesink.RuleSink
 
 	ingressLister netlisters.IngressLister
 
@@ -48,6 +65,37 @@ type Controller struct {
 	promruleLister    promlisters.PrometheusRuleLister
 	promruleSynced    cache.InformerSynced
 	promruleWorkqueue workqueue.RateLimitingInterface
+
+	// Prometheus instances are only listed by the template manager, every object is rendered again when their rule selectors change
+	prometheusSynced cache.InformerSynced
+	// ThanosRulers are only watched when the template manager discovers them
+	thanosRulerSynced cache.InformerSynced
+
+	// Services, ServiceMonitors and PodMonitors are only watched when there are monitor templates
+	serviceLister    corelisters.ServiceLister
+	serviceSynced    cache.InformerSynced
+	serviceWorkqueue workqueue.RateLimitingInterface
+
+	serviceMonitorLister promlisters.ServiceMonitorLister
+	serviceMonitorSynced cache.InformerSynced
+	podMonitorLister     promlisters.PodMonitorLister
+	podMonitorSynced     cache.InformerSynced
+
+	// namespaceLister resolves the Prometheus label of a namespace, the Namespace informer also renders objects again when their namespace changes
+	namespaceLister corelisters.NamespaceLister
+	namespaceSynced cache.InformerSynced
+
+	// Probes can be turned on by a reload, so they're watched whenever their CRD is installed
+	probeLister promlisters.ProbeLister
+	probeSynced cache.InformerSynced
+
+	// Dashboards can be turned on by a reload, so their ConfigMaps are always watched
+	dashboardLister corelisters.ConfigMapLister
+	dashboardSynced cache.InformerSynced
+
+	// ownerRegistry is nil unless AlertmanagerConfigs are generated, ownerWorkqueue holds the namespaces to sync them for
+	ownerRegistry  *alertmanager.Registry
+	ownerWorkqueue workqueue.RateLimitingInterface
 }
 
 func enqueueTo(queue workqueue.RateLimitingInterface) func(interface{}) {
@@ -68,19 +116,27 @@ func NewController(
 	promclientset promclientset.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
 	promInformerFactory prominformers.SharedInformerFactory,
+	// dashboardInformerFactory only lists the dashboard ConfigMaps, templates.DashboardSelector() selects them
+	dashboardInformerFactory kubeinformers.SharedInformerFactory,
 
-	templateManager *templates.PrometheusRuleTemplateManager) *Controller {
+	templateManager *templates.PrometheusRuleTemplateManager,
+	ownerRegistry *alertmanager.Registry,
+	ruleSink rulesink.RuleSink) *Controller {
 
 	ingressInformer := kubeInformerFactory.Networking().V1().Ingresses()
 
 	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
-	promruleInformer := promInformerFactory.Monitoring().V1().PrometheusRules()
+
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(log.Sugar.Debugf)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
 
 	controller := &Controller{
-		ctx:             context.Background(),
-		kubeclientset:   kubeclientset,
-		promclientset:   promclientset,
-		templateManager: templateManager,
+		ctx:           context.Background(),
+		kubeclientset: kubeclientset,
+		promclientset: promclientset,
+
+		recorder: eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "heimdall"}),
 
 		ingressLister: ingressInformer.Lister(),
 
@@ -91,9 +147,54 @@ func NewController(
 		deploymentSynced:    deploymentInformer.Informer().HasSynced,
 		deploymentWorkqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Deployments"),
 
-		promruleLister:    promruleInformer.Lister(),
-		promruleSynced:    promruleInformer.Informer().HasSynced,
 		promruleWorkqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "PrometheusRules"),
+
+		serviceWorkqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Services"),
+
+		ownerRegistry:  ownerRegistry,
+		ownerWorkqueue: workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Owners"),
+	}
+	controller.templateManager.Store(templateManager)
+
+	// Setup the dashboard ConfigMap Informer, workers compare the dashboards they render with its cache
+	dashboardInformer := dashboardInformerFactory.Core().V1().ConfigMaps()
+	controller.dashboardLister = dashboardInformer.Lister()
+	controller.dashboardSynced = dashboardInformer.Informer().HasSynced
+
+	// Setup Probe Informer, workers compare the Probe they render with its cache
+	if MonitoringResourceServed(kubeclientset, monitoringv1.ProbeName) {
+		probeInformer := promInformerFactory.Monitoring().V1().Probes()
+		controller.probeLister = probeInformer.Lister()
+		controller.probeSynced = probeInformer.Informer().HasSynced
+	}
+
+	// Setup Service Informer
+	if templateManager.MonitorTemplates() {
+		serviceInformer := kubeInformerFactory.Core().V1().Services()
+		controller.serviceLister = serviceInformer.Lister()
+		controller.serviceSynced = serviceInformer.Informer().HasSynced
+
+		// Workers compare the monitors they render with the caches of the existing ones
+		serviceMonitorInformer := promInformerFactory.Monitoring().V1().ServiceMonitors()
+		controller.serviceMonitorLister = serviceMonitorInformer.Lister()
+		controller.serviceMonitorSynced = serviceMonitorInformer.Informer().HasSynced
+		podMonitorInformer := promInformerFactory.Monitoring().V1().PodMonitors()
+		controller.podMonitorLister = podMonitorInformer.Lister()
+		controller.podMonitorSynced = podMonitorInformer.Informer().HasSynced
+
+		enqueueService := enqueueTo(controller.serviceWorkqueue)
+		serviceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: enqueueService,
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*corev1.Service)
+				newObj := new.(*corev1.Service)
+
+				if newObj.ResourceVersion != oldObj.ResourceVersion {
+					enqueueService(new)
+				}
+			},
+			DeleteFunc: enqueueService,
+		})
 	}
 
 	// Setup Ingress Informer
@@ -125,69 +226,126 @@ func NewController(
 		DeleteFunc: enqueueDeployment,
 	})
 
-	// Setup PrometheusRule Informer
-	enqueuePrometheusRule := enqueueTo(controller.promruleWorkqueue)
-	promruleInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: enqueuePrometheusRule,
-		UpdateFunc: func(old, new interface{}) {
-			oldObj := old.(*monitoringv1.PrometheusRule)
-			newObj := new.(*monitoringv1.PrometheusRule)
+	// Setup the PrometheusRule and Prometheus Informers, only the default sink stores rules as PrometheusRules and a cluster using another may not have the prometheus-operator's CRDs
+	controller.ruleSink = ruleSink
+	if ruleSink == nil {
+		controller.ruleSink = &prometheusRuleSink{c: controller}
 
-			if newObj.ResourceVersion != oldObj.ResourceVersion {
-				enqueuePrometheusRule(new)
-			}
-		},
-		DeleteFunc: enqueuePrometheusRule,
-	})
+		promruleInformer := promInformerFactory.Monitoring().V1().PrometheusRules()
+		controller.promruleLister = promruleInformer.Lister()
+		controller.promruleSynced = promruleInformer.Informer().HasSynced
 
-	return controller
-}
+		prometheusInformer := promInformerFactory.Monitoring().V1().Prometheuses()
+		controller.prometheusSynced = prometheusInformer.Informer().HasSynced
 
-// prometheusRulesByIngress
-// - Accepts an prometheusRulesByIngress and returns all it's PrometheusRules
-func (c *Controller) prometheusRulesByIngress(ingress *networkingv1.Ingress) ([]*monitoringv1.PrometheusRule, error) {
+		// Setup PrometheusRule Informer
+		enqueuePrometheusRule := enqueueTo(controller.promruleWorkqueue)
+		promruleInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: enqueuePrometheusRule,
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*monitoringv1.PrometheusRule)
+				newObj := new.(*monitoringv1.PrometheusRule)
 
-	filteredPrometheusRules := []*monitoringv1.PrometheusRule{}
+				if newObj.ResourceVersion != oldObj.ResourceVersion {
+					enqueuePrometheusRule(new)
+				}
+			},
+			DeleteFunc: enqueuePrometheusRule,
+		})
+
+		// Setup Prometheus Informer, the labels and namespace rules need to be loaded depend on the rule selectors
+		prometheusInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				controller.enqueueAll()
+			},
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*monitoringv1.Prometheus)
+				newObj := new.(*monitoringv1.Prometheus)
+
+				if !reflect.DeepEqual(oldObj.Spec.RuleSelector, newObj.Spec.RuleSelector) || !reflect.DeepEqual(oldObj.Spec.RuleNamespaceSelector, newObj.Spec.RuleNamespaceSelector) {
+					controller.enqueueAll()
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				controller.enqueueAll()
+			},
+		})
+	}
 
-	prometheusrules, err := c.promruleLister.List(labels.Everything())
+	// Setup ThanosRuler Informer, its lister was created for the template manager when the CRD is installed
+	if templateManager.ThanosRulers() {
+		thanosRulerInformer := promInformerFactory.Monitoring().V1().ThanosRulers()
+		controller.thanosRulerSynced = thanosRulerInformer.Informer().HasSynced
+
+		thanosRulerInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				controller.enqueueAll()
+			},
+			UpdateFunc: func(old, new interface{}) {
+				oldObj := old.(*monitoringv1.ThanosRuler)
+				newObj := new.(*monitoringv1.ThanosRuler)
+
+				if !reflect.DeepEqual(oldObj.Spec.RuleSelector, newObj.Spec.RuleSelector) || !reflect.DeepEqual(oldObj.Spec.RuleNamespaceSelector, newObj.Spec.RuleNamespaceSelector) {
+					controller.enqueueAll()
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				controller.enqueueAll()
+			},
+		})
+	}
 
-	for _, promrule := range prometheusrules {
-		ownerRefs := promrule.GetOwnerReferences()
+	// Objects inherit owner metadata from their Namespace, so they're rendered again when its annotations or labels change
+	namespaceInformer := kubeInformerFactory.Core().V1().Namespaces()
+	controller.namespaceLister = namespaceInformer.Lister()
+	controller.namespaceSynced = namespaceInformer.Informer().HasSynced
+	namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, new interface{}) {
+			oldObj := old.(*corev1.Namespace)
+			newObj := new.(*corev1.Namespace)
 
-		for _, ownerRef := range ownerRefs {
-			if ownerRef.UID == ingress.GetUID() {
-				filteredPrometheusRules = append(filteredPrometheusRules, promrule)
-				break
+			if !reflect.DeepEqual(oldObj.GetAnnotations(), newObj.GetAnnotations()) || !reflect.DeepEqual(oldObj.GetLabels(), newObj.GetLabels()) {
+				controller.enqueueNamespaceObjects(newObj.Name)
 			}
+		},
+	})
+
+	// Setup owner routing, the owners in a namespace change with its Ingresses and Deployments
+	if ownerRegistry != nil {
+		enqueueNamespace := enqueueNamespaceTo(controller.ownerWorkqueue)
+		for _, informer := range []cache.SharedIndexInformer{ingressInformer.Informer(), deploymentInformer.Informer()} {
+			informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+				AddFunc: enqueueNamespace,
+				UpdateFunc: func(old, new interface{}) {
+					if old.(metav1.Object).GetResourceVersion() != new.(metav1.Object).GetResourceVersion() {
+						enqueueNamespace(new)
+					}
+				},
+				DeleteFunc: enqueueNamespace,
+			})
 		}
 	}
 
-	return filteredPrometheusRules, err
+	return controller
 }
 
-// prometheusRulesByDeployment
-// - Accepts an prometheusRulesByDeployment and returns all it's PrometheusRules
-func (c *Controller) prometheusRulesByDeployment(deployment *apps.Deployment) ([]*monitoringv1.PrometheusRule, error) {
-	filteredPrometheusRules := []*monitoringv1.PrometheusRule{}
-
-	prometheusrules, err := c.promruleLister.List(labels.Everything())
-
-	for _, promrule := range prometheusrules {
-		ownerRefs := promrule.GetOwnerReferences()
-
-		for _, ownerRef := range ownerRefs {
-			if ownerRef.UID == deployment.GetUID() {
-				filteredPrometheusRules = append(filteredPrometheusRules, promrule)
-				break
-			}
-		}
-	}
+// manager
+// - The current template manager, workers take it once per object so a reload doesn't change it part way through
+func (c *Controller) manager() *templates.PrometheusRuleTemplateManager {
+	return c.templateManager.Load().(*templates.PrometheusRuleTemplateManager)
+}
 
-	return filteredPrometheusRules, err
+// SetTemplateManager
+// - Replaces the template manager with one built from a reloaded configuration and renders every object again
+func (c *Controller) SetTemplateManager(templateManager *templates.PrometheusRuleTemplateManager) {
+	c.templateManager.Store(templateManager)
+	c.enqueueAll()
 }
 
+// GetObjectMetaKey
+// - Identifies a PrometheusRule, each template instance renders its own so they are created and deleted independently
 func GetObjectMetaKey(meta metav1.Object) string {
-	return meta.GetNamespace() + meta.GetName()
+	return meta.GetNamespace() + "/" + meta.GetName()
 }
 
 func PrometheusRulesByKey(prometheusrules []*monitoringv1.PrometheusRule) map[string]*monitoringv1.PrometheusRule {
@@ -207,25 +365,38 @@ func (c *Controller) processIngress(namespace, name string) error {
 		if errors.IsNotFound(err) {
 			runtime.HandleError(fmt.Errorf("Ingress '%s.%s' in work queue no longer exists", namespace, name))
 			sentryclient.SentryErr(err)
-			return nil
+			return c.ruleSink.Delete(rulesink.Workload{Kind: "Ingress", Namespace: namespace, Name: name})
 		}
 
 		return err
 	}
 
-	oldPrometheusRules, err := c.prometheusRulesByIngress(ingress)
+	templateManager := c.manager()
+	newPrometheusRules, err := templateManager.CreateFromIngress(ingress)
+	invalidPrometheusRules, err := c.reportInvalidPrometheusRules(ingress, "Ingress", err)
 	if err != nil {
 		sentryclient.SentryErr(err)
 		return err
 	}
 
-	newPrometheusRules, err := c.templateManager.CreateFromIngress(ingress)
-	if err != nil {
-		sentryclient.SentryErr(err)
+	if err := c.ruleSink.Sync(rulesink.Workload{Kind: "Ingress", Namespace: namespace, Name: name, Object: ingress, Generated: templateManager.Generated}, newPrometheusRules, invalidPrometheusRules); err != nil {
 		return err
 	}
+	c.reportPrometheusRuleSelection(ingress, templateManager, newPrometheusRules)
+
+	if templateManager.Dashboards() {
+		newDashboards, invalidDashboards := templateManager.CreateDashboardsFromIngress(ingress)
+		if err := c.syncDashboards(templateManager, ingress, newDashboards, invalidDashboards); err != nil {
+			return err
+		}
+	}
+
+	// Probes aren't generated without their CRD, MonitoringResourceServed logged it on startup
+	if !templateManager.Probes() || c.probeLister == nil {
+		return nil
+	}
 
-	return c.syncPrometheusRules(oldPrometheusRules, newPrometheusRules)
+	return c.syncProbe(templateManager, ingress)
 }
 
 func (c *Controller) processDeployment(namespace, name string) error {
@@ -235,19 +406,14 @@ func (c *Controller) processDeployment(namespace, name string) error {
 		sentryclient.SentryErr(err)
 		if errors.IsNotFound(err) {
 			runtime.HandleError(fmt.Errorf("Deployment '%s.%s' in work queue no longer exists", namespace, name))
-			return nil
+			return c.ruleSink.Delete(rulesink.Workload{Kind: "Deployment", Namespace: namespace, Name: name})
 		}
 
 		return err
 	}
 
-	oldPrometheusRules, err := c.prometheusRulesByDeployment(deployment)
-	if err != nil {
-		sentryclient.SentryErr(err)
-		return err
-	}
 	// We have to look up the namespace to decide which Prometheus instance the Deployment should report to
-	deploymentNamespace, err := c.kubeclientset.CoreV1().Namespaces().Get(c.ctx, deployment.GetNamespace(), metav1.GetOptions{})
+	deploymentNamespace, err := c.namespaceLister.Get(deployment.GetNamespace())
 	if err != nil {
 		sentryclient.SentryErr(err)
 		if errors.IsNotFound(err) {
@@ -260,45 +426,77 @@ func (c *Controller) processDeployment(namespace, name string) error {
 	deploymentNamespacePrometheus := deploymentNamespace.GetLabels()["prometheus"]
 
 	log.Sugar.Debugw("Prometheus instance for alert", "deployment", name, "namespace", namespace, "prometheus", deploymentNamespacePrometheus)
-	newPrometheusRules, err := c.templateManager.CreateFromDeployment(deployment, deploymentNamespacePrometheus)
+	templateManager := c.manager()
+	newPrometheusRules, err := templateManager.CreateFromDeployment(deployment, deploymentNamespacePrometheus)
+	invalidPrometheusRules, err := c.reportInvalidPrometheusRules(deployment, "Deployment", err)
 	if err != nil {
 		sentryclient.SentryErr(err)
 		return err
 	}
 
-	return c.syncPrometheusRules(oldPrometheusRules, newPrometheusRules)
+	if err := c.ruleSink.Sync(rulesink.Workload{Kind: "Deployment", Namespace: namespace, Name: name, Object: deployment, Generated: templateManager.Generated}, newPrometheusRules, invalidPrometheusRules); err != nil {
+		return err
+	}
+	c.reportPrometheusRuleSelection(deployment, templateManager, newPrometheusRules)
+
+	if templateManager.MonitorTemplates() {
+		if err := c.syncMonitors(templateManager, deployment, templateManager.CreateMonitorsFromDeployment(deployment, deploymentNamespacePrometheus)); err != nil {
+			return err
+		}
+	}
+
+	if !templateManager.Dashboards() {
+		return nil
+	}
+
+	newDashboards, invalidDashboards := templateManager.CreateDashboardsFromDeployment(deployment, deploymentNamespacePrometheus)
+	return c.syncDashboards(templateManager, deployment, newDashboards, invalidDashboards)
 }
 
-func (c *Controller) syncPrometheusRules(oldPrometheusRules, newPrometheusRules []*monitoringv1.PrometheusRule) error {
-	oldPrometheusRulesByKey := PrometheusRulesByKey(oldPrometheusRules)
+// reportInvalidPrometheusRules
+// - Raises an Event and counts every rendered rule the template manager dropped because it failed validation
+// - Returns the keys of the dropped rules, whose existing PrometheusRules should be kept as they are, rulesink.KeepAll when a template rendered nothing
+func (c *Controller) reportInvalidPrometheusRules(obj kuberuntime.Object, kind string, err error) (map[string]bool, error) {
+	var invalidRulesErr *templates.InvalidRulesError
+	if !goerrors.As(err, &invalidRulesErr) {
+		return nil, err
+	}
 
-	for _, newPrometheusRule := range newPrometheusRules {
-		if oldPrometheusRule, ok := oldPrometheusRulesByKey[GetObjectMetaKey(newPrometheusRule)]; ok {
-			newPrometheusRule.SetResourceVersion(oldPrometheusRule.GetResourceVersion())
-			if _, err := c.promclientset.MonitoringV1().PrometheusRules(newPrometheusRule.GetNamespace()).Update(c.ctx, newPrometheusRule, metav1.UpdateOptions{}); err != nil {
-				sentryclient.SentryErr(err)
-				return err
-			}
-		} else {
-			if _, err := c.promclientset.MonitoringV1().PrometheusRules(newPrometheusRule.GetNamespace()).Create(c.ctx, newPrometheusRule, metav1.CreateOptions{}); err != nil {
-				sentryclient.SentryErr(err)
-				return err
-			}
+	invalidPrometheusRules := map[string]bool{}
+	for _, invalidRule := range invalidRulesErr.Rules {
+		promrule := invalidRule.PrometheusRule
+		if promrule == nil {
+			c.recorder.Eventf(obj, corev1.EventTypeWarning, "InvalidPrometheusRule",
+				"Keeping existing PrometheusRules, template \"%s\" failed before rendering its rules: %s", invalidRule.Template, invalidRule.Err)
+			metrics.InvalidPrometheusRules.WithLabelValues(kind, obj.(metav1.Object).GetNamespace(), invalidRule.Template).Inc()
+
+			invalidPrometheusRules[rulesink.KeepAll] = true
+			continue
 		}
+
+		c.recorder.Eventf(obj, corev1.EventTypeWarning, "InvalidPrometheusRule",
+			"Keeping existing PrometheusRule %s/%s, template \"%s\" rendered an invalid rule: %s", promrule.Namespace, promrule.Name, invalidRule.Template, invalidRule.Err)
+		metrics.InvalidPrometheusRules.WithLabelValues(kind, promrule.Namespace, invalidRule.Template).Inc()
+
+		invalidPrometheusRules[GetObjectMetaKey(promrule)] = true
 	}
 
-	newPrometheusRulesByKey := PrometheusRulesByKey(newPrometheusRules)
+	return invalidPrometheusRules, nil
+}
 
-	for _, oldPrometheusRule := range oldPrometheusRules {
-		if _, ok := newPrometheusRulesByKey[GetObjectMetaKey(oldPrometheusRule)]; !ok {
-			if err := c.promclientset.MonitoringV1().PrometheusRules(oldPrometheusRule.GetNamespace()).Delete(c.ctx, oldPrometheusRule.GetName(), metav1.DeleteOptions{}); err != nil {
-				sentryclient.SentryErr(err)
-				return err
-			}
-		}
+// reportPrometheusRuleSelection
+// - Raises an Event for every PrometheusRule no Prometheus or ThanosRuler loads, they're still created so they're picked up once one does
+// - And for every PrometheusRule targeted at a ThanosRuler which a Prometheus loads too, whose alerts fire twice
+func (c *Controller) reportPrometheusRuleSelection(obj kuberuntime.Object, templateManager *templates.PrometheusRuleTemplateManager, prometheusRules []*monitoringv1.PrometheusRule) {
+	for _, promrule := range templateManager.UnselectedPrometheusRules(prometheusRules) {
+		c.recorder.Eventf(obj, corev1.EventTypeWarning, "UnselectedPrometheusRule",
+			"PrometheusRule %s/%s isn't selected by the ruleSelector and ruleNamespaceSelector of any Prometheus or ThanosRuler", promrule.Namespace, promrule.Name)
 	}
 
-	return nil
+	for _, duplicate := range templateManager.DuplicatePrometheusRules(prometheusRules) {
+		c.recorder.Eventf(obj, corev1.EventTypeWarning, "DuplicatePrometheusRule",
+			"PrometheusRule %s/%s is selected by ThanosRuler %s and Prometheus %s, its alerts will fire twice", duplicate.PrometheusRule.Namespace, duplicate.PrometheusRule.Name, duplicate.ThanosRuler, duplicate.Prometheus)
+	}
 }
 
 func runner(workqueue workqueue.RateLimitingInterface, processFn func(string, string) error) func() {
@@ -364,24 +562,53 @@ func (c *Controller) Run(stopCh <-chan struct{}) error {
 	defer c.ingressWorkqueue.ShutDown()
 	defer c.deploymentWorkqueue.ShutDown()
 	defer c.promruleWorkqueue.ShutDown()
+	defer c.ownerWorkqueue.ShutDown()
+	defer c.serviceWorkqueue.ShutDown()
 
 	// Start the informer factories to begin populating the informer caches
 	log.Sugar.Info("Starting Heimdall")
 
 	// Wait for the caches to be synced before starting workers
 	log.Sugar.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.ingressSynced, c.promruleSynced); !ok {
+	synced := []cache.InformerSynced{c.ingressSynced, c.deploymentSynced, c.namespaceSynced, c.dashboardSynced}
+	if c.promruleSynced != nil {
+		synced = append(synced, c.promruleSynced, c.prometheusSynced)
+	}
+	if c.serviceSynced != nil {
+		synced = append(synced, c.serviceSynced, c.serviceMonitorSynced, c.podMonitorSynced)
+	}
+	if c.thanosRulerSynced != nil {
+		synced = append(synced, c.thanosRulerSynced)
+	}
+	if c.probeSynced != nil {
+		synced = append(synced, c.probeSynced)
+	}
+	if ok := cache.WaitForCacheSync(stopCh, synced...); !ok {
 		errorMessage := "failed to wait for caches to sync"
 		sentryclient.SentryMessage(errorMessage)
 		return fmt.Errorf(errorMessage)
 	}
 
+	// Workloads deleted while Heimdall wasn't running aren't queued, so their rules are pruned once
+	if pruner, ok := c.ruleSink.(rulesink.Pruner); ok {
+		if err := c.pruneRules(pruner); err != nil {
+			sentryclient.SentryErr(err)
+			runtime.HandleError(err)
+		}
+	}
+
 	ingressRunner := runner(c.ingressWorkqueue, c.processIngress)
 	deploymentRunner := runner(c.deploymentWorkqueue, c.processDeployment)
 
 	log.Sugar.Info("Starting workers")
 	go wait.Until(ingressRunner, time.Second, stopCh)
 	go wait.Until(deploymentRunner, time.Second, stopCh)
+	if c.ownerRegistry != nil {
+		go wait.Until(runner(c.ownerWorkqueue, c.processOwners), time.Second, stopCh)
+	}
+	if c.serviceLister != nil {
+		go wait.Until(runner(c.serviceWorkqueue, c.processService), time.Second, stopCh)
+	}
 
 	log.Sugar.Info("Started workers")
 	<-stopCh
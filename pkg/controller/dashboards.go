@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// dashboardsByOwner
+// - The dashboard ConfigMaps Heimdall generated for owner, from the dashboard informer's cache
+func (c *Controller) dashboardsByOwner(templateManager *templates.PrometheusRuleTemplateManager, owner metav1.Object) ([]*corev1.ConfigMap, error) {
+	configMaps, err := c.dashboardLister.ConfigMaps(owner.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	owned := []*corev1.ConfigMap{}
+	for _, configMap := range configMaps {
+		if ownedBy(templateManager.Generated, configMap, owner) {
+			owned = append(owned, configMap)
+		}
+	}
+
+	return owned, nil
+}
+
+// syncDashboards
+// - Creates, updates and deletes dashboard ConfigMaps so the ones owned by an object match newDashboards
+// - Existing dashboards whose name is in keep are left untouched
+func (c *Controller) syncDashboards(templateManager *templates.PrometheusRuleTemplateManager, owner metav1.Object, newDashboards []*corev1.ConfigMap, keep map[string]bool) error {
+	oldDashboards, err := c.dashboardsByOwner(templateManager, owner)
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	client := c.kubeclientset.CoreV1().ConfigMaps(owner.GetNamespace())
+
+	oldDashboardsByName := map[string]*corev1.ConfigMap{}
+	for _, oldDashboard := range oldDashboards {
+		oldDashboardsByName[oldDashboard.GetName()] = oldDashboard
+	}
+
+	newDashboardsByName := map[string]bool{}
+	for _, newDashboard := range newDashboards {
+		newDashboardsByName[newDashboard.GetName()] = true
+
+		if oldDashboard, ok := oldDashboardsByName[newDashboard.GetName()]; ok {
+			newDashboard.SetResourceVersion(oldDashboard.GetResourceVersion())
+			if _, err := client.Update(c.ctx, newDashboard, metav1.UpdateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return fmt.Errorf("error updating dashboard ConfigMap %s/%s: %v", newDashboard.GetNamespace(), newDashboard.GetName(), err)
+			}
+			continue
+		}
+
+		if _, err := client.Create(c.ctx, newDashboard, metav1.CreateOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return fmt.Errorf("error creating dashboard ConfigMap %s/%s: %v", newDashboard.GetNamespace(), newDashboard.GetName(), err)
+		}
+	}
+
+	for _, oldDashboard := range oldDashboards {
+		if newDashboardsByName[oldDashboard.GetName()] || keep[oldDashboard.GetName()] {
+			continue
+		}
+
+		if err := client.Delete(c.ctx, oldDashboard.GetName(), metav1.DeleteOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return fmt.Errorf("error deleting dashboard ConfigMap %s/%s: %v", oldDashboard.GetNamespace(), oldDashboard.GetName(), err)
+		}
+	}
+
+	return nil
+}
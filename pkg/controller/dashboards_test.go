@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	prominformers "github.com/prometheus-operator/prometheus-operator/pkg/client/informers/externalversions"
+	promfake "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/fake"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/uswitch/heimdall/pkg/alertmanager"
+	"github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+const otherPrefix = "com.example.heimdall"
+
+// testManager
+// - A template manager for the bundled templates, configure sets up the features a test syncs
+func testManager(t *testing.T, configure func(*templates.PrometheusRuleTemplateManager)) *templates.PrometheusRuleTemplateManager {
+	log.Setup(log.DEBUG_LEVEL)
+
+	templateManager, err := templates.NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+	if configure != nil {
+		configure(templateManager)
+	}
+
+	return templateManager
+}
+
+// testController
+// - A Controller on fake clientsets holding kubeObjects and promObjects, with its informers started and synced
+// - The fake API server serves Probes, so their informer is created
+func testController(t *testing.T, templateManager *templates.PrometheusRuleTemplateManager, ownerRegistry *alertmanager.Registry, kubeObjects, promObjects []runtime.Object) (*Controller, *fake.Clientset, *promfake.Clientset) {
+	kubeClient := fake.NewSimpleClientset(kubeObjects...)
+	kubeClient.Resources = []*metav1.APIResourceList{{
+		GroupVersion: monitoringv1.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: monitoringv1.ProbeName}},
+	}}
+	promClient := promfake.NewSimpleClientset(promObjects...)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, 0)
+	promInformerFactory := prominformers.NewSharedInformerFactory(promClient, 0)
+	dashboardInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, 0, kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+		options.LabelSelector = templates.DashboardSelector()
+	}))
+
+	c := NewController(kubeClient, promClient, kubeInformerFactory, promInformerFactory, dashboardInformerFactory, templateManager, ownerRegistry, nil)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	kubeInformerFactory.Start(stopCh)
+	promInformerFactory.Start(stopCh)
+	dashboardInformerFactory.Start(stopCh)
+	kubeInformerFactory.WaitForCacheSync(stopCh)
+	promInformerFactory.WaitForCacheSync(stopCh)
+	dashboardInformerFactory.WaitForCacheSync(stopCh)
+
+	return c, kubeClient, promClient
+}
+
+// testOwnerMeta
+// - Metadata for an object in the shop namespace owned by owner, generated with prefix unless it's empty
+func testOwnerMeta(name string, owner metav1.Object, prefix string) metav1.ObjectMeta {
+	meta := metav1.ObjectMeta{
+		Name:            name,
+		Namespace:       "shop",
+		ResourceVersion: "1",
+		OwnerReferences: []metav1.OwnerReference{{Name: owner.GetName(), UID: owner.GetUID()}},
+	}
+	if prefix != "" {
+		meta.Annotations = map[string]string{templates.GeneratedPrefixAnnotation: prefix}
+	}
+
+	return meta
+}
+
+var testOwnerIngress = &networkingv1.Ingress{
+	ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop", UID: "web-uid"},
+}
+
+func testDashboard(name string, owner metav1.Object, prefix, data string) *corev1.ConfigMap {
+	meta := testOwnerMeta(name, owner, prefix)
+	meta.Labels = map[string]string{templates.DashboardManagedByLabel: "heimdall"}
+
+	return &corev1.ConfigMap{ObjectMeta: meta, Data: map[string]string{name + ".json": data}}
+}
+
+func configMapNames(t *testing.T, client *fake.Clientset) []string {
+	configMaps, err := client.CoreV1().ConfigMaps("shop").List(context.Background(), metav1.ListOptions{})
+	assert.Assert(t, is.Nil(err))
+
+	names := []string{}
+	for _, configMap := range configMaps.Items {
+		names = append(names, configMap.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestSyncDashboards(t *testing.T) {
+	other := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop", UID: "api-uid"}}
+
+	c, kubeClient, _ := testController(t, testManager(t, nil), nil, []runtime.Object{
+		testDashboard("web-updated", testOwnerIngress, "", "old"),
+		testDashboard("web-stale", testOwnerIngress, "", "old"),
+		testDashboard("web-kept", testOwnerIngress, "", "old"),
+		testDashboard("web-foreign", testOwnerIngress, otherPrefix, "old"),
+		testDashboard("api-dashboard", other, "", "old"),
+	}, nil)
+
+	err := c.syncDashboards(c.manager(), testOwnerIngress, []*corev1.ConfigMap{
+		testDashboard("web-updated", testOwnerIngress, "", "new"),
+		testDashboard("web-created", testOwnerIngress, "", "new"),
+	}, map[string]bool{"web-kept": true})
+	assert.Assert(t, is.Nil(err))
+
+	// The stale dashboard is deleted, the kept one, another install's and another owner's are left
+	assert.DeepEqual(t, configMapNames(t, kubeClient), []string{"api-dashboard", "web-created", "web-foreign", "web-kept", "web-updated"})
+
+	updated, err := kubeClient.CoreV1().ConfigMaps("shop").Get(context.Background(), "web-updated", metav1.GetOptions{})
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, updated.Data["web-updated.json"], "new")
+}
+
+func TestSyncDashboardsOtherPrefix(t *testing.T) {
+	templateManager := testManager(t, func(templateManager *templates.PrometheusRuleTemplateManager) {
+		assert.Assert(t, is.Nil(templateManager.SetAnnotationPrefix(otherPrefix)))
+	})
+
+	c, kubeClient, _ := testController(t, templateManager, nil, []runtime.Object{
+		testDashboard("web-default", testOwnerIngress, "", "old"),
+		testDashboard("web-stale", testOwnerIngress, otherPrefix, "old"),
+	}, nil)
+
+	err := c.syncDashboards(c.manager(), testOwnerIngress, []*corev1.ConfigMap{}, map[string]bool{})
+	assert.Assert(t, is.Nil(err))
+
+	// Only the dashboards generated with the install's prefix are its own to delete
+	assert.DeepEqual(t, configMapNames(t, kubeClient), []string{"web-default"})
+}
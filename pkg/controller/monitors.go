@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"fmt"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// ownedBy
+// - Whether owner is one of the object's owner references and the object was generated with this install's annotation prefix
+// - generated is the Generated of the template manager the worker took for the object
+func ownedBy(generated func(metav1.Object) bool, obj metav1.Object, owner metav1.Object) bool {
+	if !generated(obj) {
+		return false
+	}
+
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		if ownerRef.UID == owner.GetUID() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Controller) processService(namespace, name string) error {
+	service, err := c.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			runtime.HandleError(fmt.Errorf("Service '%s.%s' in work queue no longer exists", namespace, name))
+			return nil
+		}
+
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	serviceNamespace, err := c.namespaceLister.Get(namespace)
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	templateManager := c.manager()
+	monitors := templateManager.CreateMonitorsFromService(service, serviceNamespace.GetLabels()["prometheus"])
+	return c.syncMonitors(templateManager, service, monitors)
+}
+
+// syncMonitors
+// - Creates, updates and deletes ServiceMonitors and PodMonitors so the ones owned by an object match monitors
+// - Existing monitors whose key is in monitors.Invalid are left untouched
+func (c *Controller) syncMonitors(templateManager *templates.PrometheusRuleTemplateManager, owner metav1.Object, monitors *templates.Monitors) error {
+	if err := c.syncServiceMonitors(templateManager, owner, monitors.ServiceMonitors, monitors.Invalid); err != nil {
+		return err
+	}
+
+	return c.syncPodMonitors(templateManager, owner, monitors.PodMonitors, monitors.Invalid)
+}
+
+func (c *Controller) syncServiceMonitors(templateManager *templates.PrometheusRuleTemplateManager, owner metav1.Object, newServiceMonitors []*monitoringv1.ServiceMonitor, keep map[string]bool) error {
+	client := c.promclientset.MonitoringV1().ServiceMonitors(owner.GetNamespace())
+
+	existing, err := c.serviceMonitorLister.ServiceMonitors(owner.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	oldServiceMonitors := map[string]*monitoringv1.ServiceMonitor{}
+	for _, oldServiceMonitor := range existing {
+		if ownedBy(templateManager.Generated, oldServiceMonitor, owner) {
+			oldServiceMonitors[oldServiceMonitor.GetName()] = oldServiceMonitor
+		}
+	}
+
+	newServiceMonitorsByName := map[string]bool{}
+	for _, newServiceMonitor := range newServiceMonitors {
+		newServiceMonitorsByName[newServiceMonitor.GetName()] = true
+
+		if oldServiceMonitor, ok := oldServiceMonitors[newServiceMonitor.GetName()]; ok {
+			newServiceMonitor.SetResourceVersion(oldServiceMonitor.GetResourceVersion())
+			if _, err := client.Update(c.ctx, newServiceMonitor, metav1.UpdateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+			continue
+		}
+
+		log.Sugar.Debugw("creating ServiceMonitor", "name", newServiceMonitor.GetName(), "namespace", newServiceMonitor.GetNamespace())
+		if _, err := client.Create(c.ctx, newServiceMonitor, metav1.CreateOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+	}
+
+	for name, oldServiceMonitor := range oldServiceMonitors {
+		if newServiceMonitorsByName[name] || keep[templates.MonitorKey(monitoringv1.ServiceMonitorsKind, oldServiceMonitor)] {
+			continue
+		}
+
+		if err := client.Delete(c.ctx, name, metav1.DeleteOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Controller) syncPodMonitors(templateManager *templates.PrometheusRuleTemplateManager, owner metav1.Object, newPodMonitors []*monitoringv1.PodMonitor, keep map[string]bool) error {
+	client := c.promclientset.MonitoringV1().PodMonitors(owner.GetNamespace())
+
+	existing, err := c.podMonitorLister.PodMonitors(owner.GetNamespace()).List(labels.Everything())
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	oldPodMonitors := map[string]*monitoringv1.PodMonitor{}
+	for _, oldPodMonitor := range existing {
+		if ownedBy(templateManager.Generated, oldPodMonitor, owner) {
+			oldPodMonitors[oldPodMonitor.GetName()] = oldPodMonitor
+		}
+	}
+
+	newPodMonitorsByName := map[string]bool{}
+	for _, newPodMonitor := range newPodMonitors {
+		newPodMonitorsByName[newPodMonitor.GetName()] = true
+
+		if oldPodMonitor, ok := oldPodMonitors[newPodMonitor.GetName()]; ok {
+			newPodMonitor.SetResourceVersion(oldPodMonitor.GetResourceVersion())
+			if _, err := client.Update(c.ctx, newPodMonitor, metav1.UpdateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+			continue
+		}
+
+		log.Sugar.Debugw("creating PodMonitor", "name", newPodMonitor.GetName(), "namespace", newPodMonitor.GetNamespace())
+		if _, err := client.Create(c.ctx, newPodMonitor, metav1.CreateOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+	}
+
+	for name, oldPodMonitor := range oldPodMonitors {
+		if newPodMonitorsByName[name] || keep[templates.MonitorKey(monitoringv1.PodMonitorsKind, oldPodMonitor)] {
+			continue
+		}
+
+		if err := client.Delete(c.ctx, name, metav1.DeleteOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+	}
+
+	return nil
+}
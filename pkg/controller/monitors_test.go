@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	promfake "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/fake"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+var testOwnerService = &corev1.Service{
+	ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "shop", UID: "web-service-uid"},
+}
+
+func testMonitorManager(t *testing.T) *templates.PrometheusRuleTemplateManager {
+	return testManager(t, func(templateManager *templates.PrometheusRuleTemplateManager) {
+		assert.Assert(t, is.Nil(templateManager.LoadMonitorTemplates("../../kube/config/monitors")))
+	})
+}
+
+func testServiceMonitor(name string, owner metav1.Object, prefix, port string) *monitoringv1.ServiceMonitor {
+	return &monitoringv1.ServiceMonitor{
+		ObjectMeta: testOwnerMeta(name, owner, prefix),
+		Spec:       monitoringv1.ServiceMonitorSpec{Endpoints: []monitoringv1.Endpoint{{Port: port}}},
+	}
+}
+
+func testPodMonitor(name string, owner metav1.Object, prefix, port string) *monitoringv1.PodMonitor {
+	return &monitoringv1.PodMonitor{
+		ObjectMeta: testOwnerMeta(name, owner, prefix),
+		Spec:       monitoringv1.PodMonitorSpec{PodMetricsEndpoints: []monitoringv1.PodMetricsEndpoint{{Port: port}}},
+	}
+}
+
+func monitorNames(t *testing.T, client *promfake.Clientset) ([]string, []string) {
+	serviceMonitors, err := client.MonitoringV1().ServiceMonitors("shop").List(context.Background(), metav1.ListOptions{})
+	assert.Assert(t, is.Nil(err))
+	podMonitors, err := client.MonitoringV1().PodMonitors("shop").List(context.Background(), metav1.ListOptions{})
+	assert.Assert(t, is.Nil(err))
+
+	serviceMonitorNames := []string{}
+	for _, serviceMonitor := range serviceMonitors.Items {
+		serviceMonitorNames = append(serviceMonitorNames, serviceMonitor.Name)
+	}
+	sort.Strings(serviceMonitorNames)
+
+	podMonitorNames := []string{}
+	for _, podMonitor := range podMonitors.Items {
+		podMonitorNames = append(podMonitorNames, podMonitor.Name)
+	}
+	sort.Strings(podMonitorNames)
+
+	return serviceMonitorNames, podMonitorNames
+}
+
+func TestSyncMonitors(t *testing.T) {
+	other := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop", UID: "api-service-uid"}}
+
+	invalid := testServiceMonitor("web-invalid", testOwnerService, "", "old")
+	c, _, promClient := testController(t, testMonitorManager(t), nil, nil, []runtime.Object{
+		testServiceMonitor("web-updated", testOwnerService, "", "old"),
+		testServiceMonitor("web-stale", testOwnerService, "", "old"),
+		invalid,
+		testServiceMonitor("web-foreign", testOwnerService, otherPrefix, "old"),
+		testServiceMonitor("api-metrics", other, "", "old"),
+		testPodMonitor("web-stale", testOwnerService, "", "old"),
+		testPodMonitor("web-foreign", testOwnerService, otherPrefix, "old"),
+	})
+
+	err := c.syncMonitors(c.manager(), testOwnerService, &templates.Monitors{
+		ServiceMonitors: []*monitoringv1.ServiceMonitor{
+			testServiceMonitor("web-updated", testOwnerService, "", "new"),
+			testServiceMonitor("web-created", testOwnerService, "", "new"),
+		},
+		PodMonitors: []*monitoringv1.PodMonitor{
+			testPodMonitor("web-created", testOwnerService, "", "new"),
+		},
+		Invalid: map[string]bool{templates.MonitorKey(monitoringv1.ServiceMonitorsKind, invalid): true},
+	})
+	assert.Assert(t, is.Nil(err))
+
+	// Stale monitors are deleted, ones which failed to render, another install's and another owner's are left
+	serviceMonitorNames, podMonitorNames := monitorNames(t, promClient)
+	assert.DeepEqual(t, serviceMonitorNames, []string{"api-metrics", "web-created", "web-foreign", "web-invalid", "web-updated"})
+	assert.DeepEqual(t, podMonitorNames, []string{"web-created", "web-foreign"})
+
+	updated, err := promClient.MonitoringV1().ServiceMonitors("shop").Get(context.Background(), "web-updated", metav1.GetOptions{})
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, updated.Spec.Endpoints[0].Port, "new")
+}
+
+func TestSyncMonitorsOtherPrefix(t *testing.T) {
+	templateManager := testMonitorManager(t)
+	assert.Assert(t, is.Nil(templateManager.SetAnnotationPrefix(otherPrefix)))
+
+	c, _, promClient := testController(t, templateManager, nil, nil, []runtime.Object{
+		testServiceMonitor("web-default", testOwnerService, "", "old"),
+		testServiceMonitor("web-stale", testOwnerService, otherPrefix, "old"),
+		testPodMonitor("web-default", testOwnerService, "", "old"),
+		testPodMonitor("web-stale", testOwnerService, otherPrefix, "old"),
+	})
+
+	err := c.syncMonitors(c.manager(), testOwnerService, &templates.Monitors{Invalid: map[string]bool{}})
+	assert.Assert(t, is.Nil(err))
+
+	// Only the monitors generated with the install's prefix are its own to delete
+	serviceMonitorNames, podMonitorNames := monitorNames(t, promClient)
+	assert.DeepEqual(t, serviceMonitorNames, []string{"web-default"})
+	assert.DeepEqual(t, podMonitorNames, []string{"web-default"})
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+)
+
+// enqueueNamespaceObjects
+// - Queues every Ingress and Deployment in a namespace, and the namespace's owners when AlertmanagerConfigs are generated
+func (c *Controller) enqueueNamespaceObjects(namespace string) {
+	ingresses, err := c.ingressLister.Ingresses(namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		sentryclient.SentryErr(err)
+		return
+	}
+	enqueueIngress := enqueueTo(c.ingressWorkqueue)
+	for _, ingress := range ingresses {
+		enqueueIngress(ingress)
+	}
+
+	deployments, err := c.deploymentLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		sentryclient.SentryErr(err)
+		return
+	}
+	enqueueDeployment := enqueueTo(c.deploymentWorkqueue)
+	for _, deployment := range deployments {
+		enqueueDeployment(deployment)
+	}
+
+	if c.ownerRegistry != nil {
+		c.ownerWorkqueue.AddRateLimited(namespace)
+	}
+}
+
+// enqueueAll
+// - Queues every Ingress, Deployment and Service, and the owners of every namespace they're in
+func (c *Controller) enqueueAll() {
+	namespaces := map[string]bool{}
+
+	ingresses, err := c.ingressLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		sentryclient.SentryErr(err)
+		return
+	}
+	for _, ingress := range ingresses {
+		namespaces[ingress.Namespace] = true
+	}
+
+	deployments, err := c.deploymentLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		sentryclient.SentryErr(err)
+		return
+	}
+	for _, deployment := range deployments {
+		namespaces[deployment.Namespace] = true
+	}
+
+	for namespace := range namespaces {
+		c.enqueueNamespaceObjects(namespace)
+	}
+
+	if c.serviceLister == nil {
+		return
+	}
+
+	services, err := c.serviceLister.List(labels.Everything())
+	if err != nil {
+		runtime.HandleError(err)
+		sentryclient.SentryErr(err)
+		return
+	}
+	enqueueService := enqueueTo(c.serviceWorkqueue)
+	for _, service := range services {
+		enqueueService(service)
+	}
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// MonitoringResourceServed
+// - Whether the API server serves a monitoring.coreos.com resource, the prometheus-operator can be installed without the CRDs of ThanosRulers and Probes
+// - Informers are only created for served resources, one for a missing resource would never sync
+func MonitoringResourceServed(kubeclientset kubernetes.Interface, resource string) bool {
+	resources, err := kubeclientset.Discovery().ServerResourcesForGroupVersion(monitoringv1.SchemeGroupVersion.String())
+	if err != nil {
+		log.Sugar.Warnw("error discovering monitoring.coreos.com resources, they won't be watched", "resource", resource, "error", err)
+		return false
+	}
+
+	for _, apiResource := range resources.APIResources {
+		if apiResource.Name == resource {
+			return true
+		}
+	}
+
+	log.Sugar.Infow("CRD isn't installed, it won't be watched", "resource", resource)
+	return false
+}
+
+// syncProbe
+// - Creates, updates and deletes Probes so the one owned by an Ingress matches its com.uswitch.heimdall-probe annotations
+// - An existing Probe is left untouched when the annotations are invalid
+func (c *Controller) syncProbe(templateManager *templates.PrometheusRuleTemplateManager, ingress *networkingv1.Ingress) error {
+	newProbe, err := templateManager.CreateProbeFromIngress(ingress)
+	if err != nil {
+		// Rendering the Ingress's PrometheusRules already reported the invalid annotations
+		return nil
+	}
+
+	client := c.promclientset.MonitoringV1().Probes(ingress.Namespace)
+
+	existing, err := c.probeLister.Probes(ingress.Namespace).List(labels.Everything())
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	oldProbes := map[string]*monitoringv1.Probe{}
+	for _, oldProbe := range existing {
+		if ownedBy(templateManager.Generated, oldProbe, ingress) {
+			oldProbes[oldProbe.GetName()] = oldProbe
+		}
+	}
+
+	if newProbe != nil {
+		if oldProbe, ok := oldProbes[newProbe.GetName()]; ok {
+			newProbe.SetResourceVersion(oldProbe.GetResourceVersion())
+			if _, err := client.Update(c.ctx, newProbe, metav1.UpdateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+		} else {
+			log.Sugar.Debugw("creating Probe", "name", newProbe.GetName(), "namespace", newProbe.GetNamespace())
+			if _, err := client.Create(c.ctx, newProbe, metav1.CreateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+		}
+	}
+
+	for name := range oldProbes {
+		if newProbe != nil && name == newProbe.GetName() {
+			continue
+		}
+
+		if err := client.Delete(c.ctx, name, metav1.DeleteOptions{}); err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	promfake "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/fake"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+func testProbeManager(t *testing.T, prefix string) *templates.PrometheusRuleTemplateManager {
+	return testManager(t, func(templateManager *templates.PrometheusRuleTemplateManager) {
+		assert.Assert(t, is.Nil(templateManager.SetAnnotationPrefix(prefix)))
+		templateManager.SetProber("blackbox-exporter:9115", "http_2xx", nil)
+	})
+}
+
+func testProbedIngress(annotations map[string]string) *networkingv1.Ingress {
+	ingress := testOwnerIngress.DeepCopy()
+	ingress.Annotations = annotations
+	ingress.Spec.Rules = []networkingv1.IngressRule{{Host: "shop.example.com"}}
+
+	return ingress
+}
+
+func testProbe(name string, owner metav1.Object, prefix, module string) *monitoringv1.Probe {
+	return &monitoringv1.Probe{
+		ObjectMeta: testOwnerMeta(name, owner, prefix),
+		Spec:       monitoringv1.ProbeSpec{Module: module},
+	}
+}
+
+func probeNames(t *testing.T, client *promfake.Clientset) []string {
+	probes, err := client.MonitoringV1().Probes("shop").List(context.Background(), metav1.ListOptions{})
+	assert.Assert(t, is.Nil(err))
+
+	names := []string{}
+	for _, probe := range probes.Items {
+		names = append(names, probe.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestSyncProbe(t *testing.T) {
+	other := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "shop", UID: "api-uid"}}
+
+	c, _, promClient := testController(t, testProbeManager(t, templates.DefaultAnnotationPrefix), nil, nil, []runtime.Object{
+		testProbe("web-probe", testOwnerIngress, "", "old"),
+		testProbe("web-renamed-probe", testOwnerIngress, "", "old"),
+		testProbe("web-foreign-probe", testOwnerIngress, otherPrefix, "old"),
+		testProbe("api-probe", other, "", "old"),
+	})
+
+	err := c.syncProbe(c.manager(), testProbedIngress(map[string]string{
+		"com.uswitch.heimdall-probe/enabled": "true",
+		"com.uswitch.heimdall-probe/module":  "http_3xx",
+	}))
+	assert.Assert(t, is.Nil(err))
+
+	// The stale Probe is deleted, another install's and another owner's are left
+	assert.DeepEqual(t, probeNames(t, promClient), []string{"api-probe", "web-foreign-probe", "web-probe"})
+
+	updated, err := promClient.MonitoringV1().Probes("shop").Get(context.Background(), "web-probe", metav1.GetOptions{})
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, updated.Spec.Module, "http_3xx")
+	assert.DeepEqual(t, updated.Spec.Targets.StaticConfig.Targets, []string{"http://shop.example.com/"})
+}
+
+func TestSyncProbeCreated(t *testing.T) {
+	c, _, promClient := testController(t, testProbeManager(t, templates.DefaultAnnotationPrefix), nil, nil, nil)
+
+	err := c.syncProbe(c.manager(), testProbedIngress(map[string]string{"com.uswitch.heimdall-probe/enabled": "true"}))
+	assert.Assert(t, is.Nil(err))
+
+	created, err := promClient.MonitoringV1().Probes("shop").Get(context.Background(), "web-probe", metav1.GetOptions{})
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, created.Spec.Module, "http_2xx")
+	assert.Equal(t, created.OwnerReferences[0].UID, testOwnerIngress.UID)
+}
+
+func TestSyncProbeInvalid(t *testing.T) {
+	c, _, promClient := testController(t, testProbeManager(t, templates.DefaultAnnotationPrefix), nil, nil, []runtime.Object{
+		testProbe("web-probe", testOwnerIngress, "", "old"),
+	})
+
+	err := c.syncProbe(c.manager(), testProbedIngress(map[string]string{
+		"com.uswitch.heimdall-probe/enabled": "true",
+		"com.uswitch.heimdall-probe/tls":     "maybe",
+	}))
+	assert.Assert(t, is.Nil(err))
+
+	// The existing Probe is kept until the annotations are fixed
+	assert.DeepEqual(t, probeNames(t, promClient), []string{"web-probe"})
+}
+
+func TestSyncProbeOtherPrefix(t *testing.T) {
+	c, _, promClient := testController(t, testProbeManager(t, otherPrefix), nil, nil, []runtime.Object{
+		testProbe("web-probe", testOwnerIngress, "", "old"),
+		testProbe("web-stale-probe", testOwnerIngress, otherPrefix, "old"),
+	})
+
+	// The Ingress doesn't enable a Probe with the install's prefix, only the Probes generated with it are its own to delete
+	err := c.syncProbe(c.manager(), testProbedIngress(map[string]string{"com.uswitch.heimdall-probe/enabled": "true"}))
+	assert.Assert(t, is.Nil(err))
+
+	assert.DeepEqual(t, probeNames(t, promClient), []string{"web-probe"})
+}
@@ -0,0 +1,109 @@
+package controller
+
+import (
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/uswitch/heimdall/pkg/rulesink"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// prometheusRuleSink
+// - Stores rules as PrometheusRule objects owned by their workload, the default sink
+type prometheusRuleSink struct {
+	c *Controller
+}
+
+// prometheusRulesBy
+// - The PrometheusRules owned by an object
+func (s *prometheusRuleSink) prometheusRulesBy(generated func(metav1.Object) bool, owner metav1.Object) ([]*monitoringv1.PrometheusRule, error) {
+	filteredPrometheusRules := []*monitoringv1.PrometheusRule{}
+
+	prometheusrules, err := s.c.promruleLister.List(labels.Everything())
+
+	for _, promrule := range prometheusrules {
+		if ownedBy(generated, promrule, owner) {
+			filteredPrometheusRules = append(filteredPrometheusRules, promrule)
+		}
+	}
+
+	return filteredPrometheusRules, err
+}
+
+// Sync
+// - Creates, updates and deletes PrometheusRules so the ones owned by the workload match newPrometheusRules
+// - Existing rules whose key is in keep are left untouched, none are deleted when rulesink.KeepAll is
+// - The rules of kept templates are copied from an existing consolidated rule into its update
+func (s *prometheusRuleSink) Sync(workload rulesink.Workload, newPrometheusRules []*monitoringv1.PrometheusRule, keep map[string]bool) error {
+	oldPrometheusRules, err := s.prometheusRulesBy(workload.Generated, workload.Object)
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	oldPrometheusRulesByKey := PrometheusRulesByKey(oldPrometheusRules)
+
+	for _, newPrometheusRule := range newPrometheusRules {
+		if oldPrometheusRule, ok := oldPrometheusRulesByKey[GetObjectMetaKey(newPrometheusRule)]; ok {
+			// A consolidated rule keeps the rules its invalid templates merged into it before
+			newPrometheusRule = rulesink.KeepSourceRules(oldPrometheusRule, newPrometheusRule, keep)
+			newPrometheusRule.SetResourceVersion(oldPrometheusRule.GetResourceVersion())
+			if _, err := s.c.promclientset.MonitoringV1().PrometheusRules(newPrometheusRule.GetNamespace()).Update(s.c.ctx, newPrometheusRule, metav1.UpdateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+		} else {
+			if _, err := s.c.promclientset.MonitoringV1().PrometheusRules(newPrometheusRule.GetNamespace()).Create(s.c.ctx, newPrometheusRule, metav1.CreateOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+		}
+	}
+
+	if keep[rulesink.KeepAll] {
+		return nil
+	}
+
+	newPrometheusRulesByKey := PrometheusRulesByKey(newPrometheusRules)
+
+	for _, oldPrometheusRule := range oldPrometheusRules {
+		if _, ok := newPrometheusRulesByKey[GetObjectMetaKey(oldPrometheusRule)]; !ok && !keep[GetObjectMetaKey(oldPrometheusRule)] {
+			if err := s.c.promclientset.MonitoringV1().PrometheusRules(oldPrometheusRule.GetNamespace()).Delete(s.c.ctx, oldPrometheusRule.GetName(), metav1.DeleteOptions{}); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete
+// - PrometheusRules are garbage collected with the workload which owns them
+func (s *prometheusRuleSink) Delete(workload rulesink.Workload) error {
+	return nil
+}
+
+// pruneRules
+// - Removes the rules a sink stores for workloads which no longer exist
+func (c *Controller) pruneRules(pruner rulesink.Pruner) error {
+	workloads := []rulesink.Workload{}
+
+	ingresses, err := c.ingressLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, ingress := range ingresses {
+		workloads = append(workloads, rulesink.Workload{Kind: "Ingress", Namespace: ingress.Namespace, Name: ingress.Name, Object: ingress})
+	}
+
+	deployments, err := c.deploymentLister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, deployment := range deployments {
+		workloads = append(workloads, rulesink.Workload{Kind: "Deployment", Namespace: deployment.Namespace, Name: deployment.Name, Object: deployment})
+	}
+
+	return pruner.Prune(workloads)
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+)
+
+var (
+	// InvalidPrometheusRules counts rendered PrometheusRules which were dropped because they failed validation
+	InvalidPrometheusRules = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "heimdall",
+		Name:      "invalid_prometheus_rules_total",
+		Help:      "Number of rendered PrometheusRules dropped because they failed validation.",
+	}, []string{"kind", "namespace", "template"})
+)
+
+func init() {
+	prometheus.MustRegister(InvalidPrometheusRules)
+}
+
+// Serve
+// - Exposes the metrics on address, it blocks until the server fails
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Sugar.Infof("Serving metrics on %s", address)
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Sugar.Errorf("Error serving metrics: %s", err)
+	}
+}
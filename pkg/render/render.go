@@ -0,0 +1,330 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/templates"
+)
+
+// decodeScheme
+// - The Kubernetes kinds, and the prometheus-operator's so Prometheus instances can be read from the input too
+var decodeScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(scheme.AddToScheme(decodeScheme))
+	utilruntime.Must(monitoringv1.AddToScheme(decodeScheme))
+}
+
+// Options
+// - Controls how manifests read from disk are turned into PrometheusRules
+type Options struct {
+	// Templates is the directory holding the PrometheusRule templates
+	Templates string
+	// DefaultNamespace is used for objects which don't set a namespace
+	DefaultNamespace string
+	// NamespacePrometheus maps a namespace to the Prometheus instance its Deployments report to.
+	// It takes precedence over the prometheus label of Namespace objects in the input.
+	NamespacePrometheus map[string]string
+	// ConsolidateRules merges the PrometheusRules rendered for an object into one per workload
+	ConsolidateRules bool
+	// ProberURL is the blackbox exporter Probes use, templates only get .Probe for Ingresses when it's set
+	ProberURL    string
+	ProberModule string
+	// AnnotationPrefix is the prefix of the annotations which select templates, templates.DefaultAnnotationPrefix unless set
+	AnnotationPrefix string
+	// MetadataKeys maps metadata fields to the annotation or label keys they're read from, on top of templates.DefaultMetadataKeys
+	MetadataKeys map[string]string
+	// DefaultMetadata is the cluster default metadata, keyed by field
+	DefaultMetadata map[string]string
+}
+
+// Decode
+// - Reads a stream of YAML or JSON documents and returns the Kubernetes objects it contains.
+// - Lists are flattened and documents of kinds Heimdall doesn't know about are skipped.
+func Decode(r io.Reader) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	decoder := serializer.NewCodecFactory(decodeScheme).UniversalDeserializer()
+
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading document: %v", err)
+		}
+
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, gvk, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			if runtime.IsNotRegisteredError(err) {
+				log.Sugar.Debugf("skipping document: %s", err)
+				continue
+			}
+			return nil, fmt.Errorf("error decoding document: %v", err)
+		}
+
+		if list, ok := obj.(*corev1.List); ok {
+			for _, item := range list.Items {
+				items, err := Decode(bytes.NewReader(item.Raw))
+				if err != nil {
+					return nil, fmt.Errorf("error decoding %s item: %v", gvk.Kind, err)
+				}
+				objects = append(objects, items...)
+			}
+			continue
+		}
+
+		objects = append(objects, obj)
+	}
+}
+
+// PrometheusRules
+// - Renders the PrometheusRules for every Ingress and Deployment in objects without connecting to a cluster.
+// - Owners are resolved against the other objects in the input set, and rules matched against the Prometheus and ThanosRuler instances in it.
+// - Rules which fail validation are left out, as the controller would, and returned in an *templates.InvalidRulesError.
+func PrometheusRules(objects []runtime.Object, opts Options) ([]*monitoringv1.PrometheusRule, error) {
+	objects = withNamespace(objects, opts.DefaultNamespace)
+
+	namespacePrometheus := map[string]string{}
+	for _, obj := range objects {
+		if namespace, ok := obj.(*corev1.Namespace); ok {
+			if prometheus, ok := namespace.GetLabels()["prometheus"]; ok {
+				namespacePrometheus[namespace.Name] = prometheus
+			}
+		}
+	}
+	for namespace, prometheus := range opts.NamespacePrometheus {
+		namespacePrometheus[namespace] = prometheus
+	}
+
+	// The fake clientset only tracks the Kubernetes kinds, Prometheus and ThanosRuler instances are listed from the input directly
+	kubeObjects := []runtime.Object{}
+	prometheuses, thanosRulers := prometheusList{}, thanosRulerList{}
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *monitoringv1.Prometheus:
+			prometheuses = append(prometheuses, o)
+		case *monitoringv1.ThanosRuler:
+			thanosRulers = append(thanosRulers, o)
+		}
+		if _, _, err := scheme.Scheme.ObjectKinds(obj); err == nil {
+			kubeObjects = append(kubeObjects, obj)
+		}
+	}
+
+	client := fake.NewSimpleClientset(append(kubeObjects, synthesizeReplicaSets(objects)...)...)
+	templateManager, err := templates.NewPrometheusRuleTemplateManager(opts.Templates, client)
+	if err != nil {
+		return nil, fmt.Errorf("error creating template manager: %v", err)
+	}
+	if len(prometheuses) != 0 {
+		templateManager.SetPrometheusLister(prometheuses)
+	}
+	if len(thanosRulers) != 0 {
+		templateManager.SetThanosRulerLister(thanosRulers)
+	}
+	templateManager.SetConsolidateRules(opts.ConsolidateRules)
+	if opts.AnnotationPrefix != "" {
+		if err := templateManager.SetAnnotationPrefix(opts.AnnotationPrefix); err != nil {
+			return nil, err
+		}
+	}
+	if err := templateManager.SetMetadataKeys(opts.MetadataKeys); err != nil {
+		return nil, err
+	}
+	if err := templateManager.SetDefaultMetadata(opts.DefaultMetadata); err != nil {
+		return nil, err
+	}
+	if opts.ProberURL != "" {
+		templateManager.SetProber(opts.ProberURL, opts.ProberModule, nil)
+	}
+
+	prometheusRules := []*monitoringv1.PrometheusRule{}
+	invalidRules := []templates.InvalidRule{}
+	for _, obj := range objects {
+		var rendered []*monitoringv1.PrometheusRule
+
+		switch o := obj.(type) {
+		case *networkingv1.Ingress:
+			rendered, err = templateManager.CreateFromIngress(o)
+		case *appsv1.Deployment:
+			rendered, err = templateManager.CreateFromDeployment(o, namespacePrometheus[o.Namespace])
+		default:
+			continue
+		}
+
+		var invalidRulesErr *templates.InvalidRulesError
+		if errors.As(err, &invalidRulesErr) {
+			invalidRules = append(invalidRules, invalidRulesErr.Rules...)
+		} else if err != nil {
+			return nil, err
+		}
+		prometheusRules = append(prometheusRules, rendered...)
+	}
+
+	for _, promrule := range templateManager.UnselectedPrometheusRules(prometheusRules) {
+		log.Sugar.Warnf("PrometheusRule %s/%s isn't selected by the ruleSelector and ruleNamespaceSelector of any Prometheus or ThanosRuler", promrule.Namespace, promrule.Name)
+	}
+	for _, duplicate := range templateManager.DuplicatePrometheusRules(prometheusRules) {
+		log.Sugar.Warnf("PrometheusRule %s/%s is selected by ThanosRuler %s and Prometheus %s, its alerts will fire twice", duplicate.PrometheusRule.Namespace, duplicate.PrometheusRule.Name, duplicate.ThanosRuler, duplicate.Prometheus)
+	}
+
+	sort.Slice(prometheusRules, func(i, j int) bool {
+		if prometheusRules[i].Namespace != prometheusRules[j].Namespace {
+			return prometheusRules[i].Namespace < prometheusRules[j].Namespace
+		}
+		return prometheusRules[i].Name < prometheusRules[j].Name
+	})
+
+	if len(invalidRules) != 0 {
+		return prometheusRules, &templates.InvalidRulesError{Rules: invalidRules}
+	}
+
+	return prometheusRules, nil
+}
+
+// Write
+// - Writes PrometheusRules to w as a stream of YAML documents
+func Write(w io.Writer, prometheusRules []*monitoringv1.PrometheusRule) error {
+	for _, promrule := range prometheusRules {
+		out, err := sigsyaml.Marshal(promrule)
+		if err != nil {
+			return fmt.Errorf("error encoding PrometheusRule %s/%s: %v", promrule.Namespace, promrule.Name, err)
+		}
+
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prometheusList
+// - Lists the Prometheus instances read from the input
+type prometheusList []*monitoringv1.Prometheus
+
+func (l prometheusList) List(selector labels.Selector) ([]*monitoringv1.Prometheus, error) {
+	selected := []*monitoringv1.Prometheus{}
+	for _, prometheus := range l {
+		if selector.Matches(labels.Set(prometheus.Labels)) {
+			selected = append(selected, prometheus)
+		}
+	}
+
+	return selected, nil
+}
+
+// thanosRulerList
+// - Lists the ThanosRuler instances read from the input
+type thanosRulerList []*monitoringv1.ThanosRuler
+
+func (l thanosRulerList) List(selector labels.Selector) ([]*monitoringv1.ThanosRuler, error) {
+	selected := []*monitoringv1.ThanosRuler{}
+	for _, thanosRuler := range l {
+		if selector.Matches(labels.Set(thanosRuler.Labels)) {
+			selected = append(selected, thanosRuler)
+		}
+	}
+
+	return selected, nil
+}
+
+// withNamespace
+// - Sets namespace on all namespaced objects which were read without one
+func withNamespace(objects []runtime.Object, namespace string) []runtime.Object {
+	for _, obj := range objects {
+		if _, ok := obj.(*corev1.Namespace); ok {
+			continue
+		}
+
+		meta, ok := obj.(metav1.Object)
+		if ok && meta.GetNamespace() == "" {
+			meta.SetNamespace(namespace)
+		}
+	}
+
+	return objects
+}
+
+// synthesizeReplicaSets
+// - Owner resolution walks from a Service's Pods up to their Deployment, but manifests on disk don't contain Pods
+// - Creates a ReplicaSet and Pod for every Deployment whose ReplicaSet isn't in the input, as the Deployment controller would
+func synthesizeReplicaSets(objects []runtime.Object) []runtime.Object {
+	owned := map[string]bool{}
+	for _, obj := range objects {
+		if replicaset, ok := obj.(*appsv1.ReplicaSet); ok {
+			for _, owner := range replicaset.OwnerReferences {
+				owned[replicaset.Namespace+"/"+owner.Name] = true
+			}
+		}
+	}
+
+	synthesized := []runtime.Object{}
+	for _, obj := range objects {
+		deployment, ok := obj.(*appsv1.Deployment)
+		if !ok || owned[deployment.Namespace+"/"+deployment.Name] {
+			continue
+		}
+
+		replicaset := &appsv1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      deployment.Name + "-rendered",
+				Namespace: deployment.Namespace,
+				Labels:    deployment.Spec.Template.Labels,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(deployment, schema.GroupVersionKind{
+						Group:   appsv1.SchemeGroupVersion.Group,
+						Version: appsv1.SchemeGroupVersion.Version,
+						Kind:    "Deployment",
+					}),
+				},
+			},
+		}
+
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      replicaset.Name + "-0",
+				Namespace: deployment.Namespace,
+				Labels:    deployment.Spec.Template.Labels,
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(replicaset, schema.GroupVersionKind{
+						Group:   appsv1.SchemeGroupVersion.Group,
+						Version: appsv1.SchemeGroupVersion.Version,
+						Kind:    "ReplicaSet",
+					}),
+				},
+			},
+		}
+
+		synthesized = append(synthesized, replicaset, pod)
+	}
+
+	return synthesized
+}
@@ -0,0 +1,93 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+const testManifests = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: testNamespace
+  labels:
+    prometheus: testPrometheus
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: testApp
+  namespace: testNamespace
+  annotations:
+    service.rvu.co.uk/owner: testDeploymentOwner
+spec:
+  selector:
+    matchLabels:
+      app: testApp
+  template:
+    metadata:
+      labels:
+        app: testApp
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: testService
+    namespace: testNamespace
+  spec:
+    selector:
+      app: testApp
+- apiVersion: networking.k8s.io/v1
+  kind: Ingress
+  metadata:
+    name: testIngress
+    annotations:
+      com.uswitch.heimdall/5xx-rate: "0.001"
+  spec:
+    defaultBackend:
+      service:
+        name: testService
+        port:
+          number: 80
+---
+apiVersion: example.com/v1
+kind: Unknown
+metadata:
+  name: skipped
+`
+
+func TestDecode(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	objects, err := Decode(strings.NewReader(testManifests))
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(objects, 4))
+}
+
+func TestPrometheusRules(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	objects, err := Decode(strings.NewReader(testManifests))
+	assert.Assert(t, is.Nil(err))
+
+	promrules, err := PrometheusRules(objects, Options{
+		Templates:        "../../kube/config/templates",
+		DefaultNamespace: "testNamespace",
+	})
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(promrules, 1))
+	assert.Equal(t, promrules[0].Name, "testNamespace-testIngress-5xx-rate")
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[1].Labels["owner"], "testDeploymentOwner")
+
+	var out bytes.Buffer
+	assert.Assert(t, is.Nil(Write(&out, promrules)))
+	assert.Assert(t, is.Contains(out.String(), "name: testNamespace-testIngress-5xx-rate"))
+}
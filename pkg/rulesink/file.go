@@ -0,0 +1,373 @@
+package rulesink
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+)
+
+// fileSinkKinds are the kinds of workload rule files are written for, files of other names in the directory are left alone
+var fileSinkKinds = []string{"Ingress", "Deployment"}
+
+// ruleFile
+// - The Prometheus rule_files format
+type ruleFile struct {
+	Groups []monitoringv1.RuleGroup `json:"groups"`
+}
+
+// reloadInterval is how often pending changes are reloaded, so syncing many workloads at once reloads Prometheus once
+const reloadInterval = 5 * time.Second
+
+// FileSink
+// - Writes the rules of each workload to a Prometheus rule file of its own in a directory, for Prometheus servers the prometheus-operator doesn't manage
+// - Prometheus is told to reload its rules with a POST to reloadURL when it's set, otherwise it has to reload them itself
+type FileSink struct {
+	directory string
+	reloadURL string
+	namespace string
+	client    *http.Client
+
+	// mu guards the directory and reloadPending, Ingresses and Deployments are synced concurrently
+	mu sync.Mutex
+	// reloadPending is set when the files changed and Prometheus hasn't reloaded them yet
+	reloadPending bool
+}
+
+// NewFileSink
+// - Writes rule files to directory, which has to exist, and POSTs to reloadURL, such as http://prometheus:9090/-/reload, when they change
+// - namespace is the one Heimdall watches, files of workloads in other namespaces are never pruned, all of them are watched when it's empty
+func NewFileSink(directory, reloadURL, namespace string) (*FileSink, error) {
+	info, err := os.Stat(directory)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s isn't a directory", directory)
+	}
+
+	return &FileSink{
+		directory: directory,
+		reloadURL: reloadURL,
+		namespace: namespace,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// path
+// - The rule file of a workload, <kind>_<namespace>_<name>.yaml, names and namespaces can't contain underscores so no two workloads share one
+func (s *FileSink) path(workload Workload) string {
+	return filepath.Join(s.directory, fmt.Sprintf("%s_%s_%s.yaml", strings.ToLower(workload.Kind), workload.Namespace, workload.Name))
+}
+
+// groupName
+// - <namespace>/<name>/<group>, group names only have to be unique within a PrometheusRule but Prometheus rejects a file repeating one
+func groupName(promrule *monitoringv1.PrometheusRule, group string) string {
+	return fmt.Sprintf("%s/%s/%s", promrule.Namespace, promrule.Name, group)
+}
+
+// splitGroupName
+// - The namespace, name and group a group in a rule file was written for, ok is false when it isn't one Heimdall named
+func splitGroupName(group string) (namespace, name, ruleGroup string, ok bool) {
+	parts := strings.SplitN(group, "/", 3)
+	if len(parts) != 3 {
+		return "", "", group, false
+	}
+
+	return parts[0], parts[1], parts[2], true
+}
+
+// sourcesComment starts the comment lines rule files record the sources of consolidated PrometheusRules in, rule files have no annotations
+const sourcesComment = "# " + SourcesAnnotation + " "
+
+// Sync
+// - Writes the groups of every PrometheusRule to the workload's file, or removes it when there are none
+// - PrometheusRules of the existing file whose key is in keep are written again alongside the new ones, all of them when KeepAll is
+// - As are the rules an invalid template merged into a consolidated PrometheusRule before, see KeepSourceRules
+func (s *FileSink) Sync(workload Workload, prometheusRules []*monitoringv1.PrometheusRule, keep map[string]bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := s.path(workload)
+	existing, err := s.readRules(path)
+	if err != nil {
+		return err
+	}
+	existingByKey := map[string]*monitoringv1.PrometheusRule{}
+	for _, promrule := range existing {
+		existingByKey[promrule.Namespace+"/"+promrule.Name] = promrule
+	}
+
+	written := []*monitoringv1.PrometheusRule{}
+	rendered := map[string]bool{}
+	for _, promrule := range prometheusRules {
+		key := promrule.Namespace + "/" + promrule.Name
+		if existingRule, ok := existingByKey[key]; ok {
+			promrule = KeepSourceRules(existingRule, promrule, keep)
+		}
+		rendered[key] = true
+		written = append(written, promrule)
+	}
+
+	kept := 0
+	for _, promrule := range existing {
+		key := promrule.Namespace + "/" + promrule.Name
+		if rendered[key] {
+			continue
+		}
+		// Groups Heimdall didn't name can't be matched to a PrometheusRule, they're only kept with everything else
+		if keep[KeepAll] || (key != "/" && keep[key]) {
+			written = append(written, promrule)
+			kept++
+		}
+	}
+	if kept != 0 {
+		log.Sugar.Infow("keeping rules, some of the workload's rules are invalid", "path", path, "prometheusRules", kept)
+	}
+
+	var header bytes.Buffer
+	content := ruleFile{Groups: []monitoringv1.RuleGroup{}}
+	for _, promrule := range written {
+		if sources, ok := promrule.GetAnnotations()[SourcesAnnotation]; ok {
+			fmt.Fprintf(&header, "%s%s/%s %s\n", sourcesComment, promrule.Namespace, promrule.Name, sources)
+		}
+		for _, group := range promrule.Spec.Groups {
+			if promrule.Namespace != "" || promrule.Name != "" {
+				group.Name = groupName(promrule, group.Name)
+			}
+			content.Groups = append(content.Groups, group)
+		}
+	}
+
+	if len(content.Groups) == 0 {
+		return s.remove(path)
+	}
+
+	out, err := yaml.Marshal(content)
+	if err != nil {
+		return fmt.Errorf("error encoding rule file %s: %v", path, err)
+	}
+	out = append(header.Bytes(), out...)
+
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, out) {
+		return nil
+	}
+
+	if err := writeFile(path, out); err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+	s.reloadPending = true
+
+	return nil
+}
+
+// readRules
+// - The PrometheusRules the existing file was written from, in the order of their groups, none when there's no file
+// - Groups Heimdall didn't name are returned in a PrometheusRule without a name
+func (s *FileSink) readRules(path string) ([]*monitoringv1.PrometheusRule, error) {
+	existing, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return nil, err
+	}
+
+	previous := ruleFile{}
+	if err := yaml.Unmarshal(existing, &previous); err != nil {
+		return nil, fmt.Errorf("error decoding rule file %s: %v", path, err)
+	}
+
+	sources := map[string]string{}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if !strings.HasPrefix(line, sourcesComment) {
+			continue
+		}
+		if parts := strings.SplitN(strings.TrimPrefix(line, sourcesComment), " ", 2); len(parts) == 2 {
+			sources[parts[0]] = parts[1]
+		}
+	}
+
+	prometheusRules := []*monitoringv1.PrometheusRule{}
+	byKey := map[string]*monitoringv1.PrometheusRule{}
+	for _, group := range previous.Groups {
+		namespace, name, ruleGroup, _ := splitGroupName(group.Name)
+		key := namespace + "/" + name
+
+		promrule, ok := byKey[key]
+		if !ok {
+			promrule = &monitoringv1.PrometheusRule{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+			if value, ok := sources[key]; ok {
+				promrule.Annotations = map[string]string{SourcesAnnotation: value}
+			}
+			byKey[key] = promrule
+			prometheusRules = append(prometheusRules, promrule)
+		}
+
+		group.Name = ruleGroup
+		promrule.Spec.Groups = append(promrule.Spec.Groups, group)
+	}
+
+	return prometheusRules, nil
+}
+
+// Delete
+// - Removes the workload's rule file
+func (s *FileSink) Delete(workload Workload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.remove(s.path(workload))
+}
+
+// Prune
+// - Removes the rule files of workloads in the watched namespace which aren't in workloads
+func (s *FileSink) Prune(workloads []Workload) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := map[string]bool{}
+	for _, workload := range workloads {
+		existing[s.path(workload)] = true
+	}
+
+	namespace := "*"
+	if s.namespace != "" {
+		namespace = s.namespace
+	}
+
+	for _, kind := range fileSinkKinds {
+		paths, err := filepath.Glob(filepath.Join(s.directory, fmt.Sprintf("%s_%s_*.yaml", strings.ToLower(kind), namespace)))
+		if err != nil {
+			return err
+		}
+
+		for _, path := range paths {
+			if existing[path] {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				sentryclient.SentryErr(err)
+				return err
+			}
+			log.Sugar.Infow("removed stale rule file", "path", path)
+			s.reloadPending = true
+		}
+	}
+
+	return nil
+}
+
+func (s *FileSink) remove(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+	s.reloadPending = true
+
+	return nil
+}
+
+// Run
+// - Reloads Prometheus every reloadInterval while the files have changed since it last did, until stopCh is closed
+func (s *FileSink) Run(stopCh <-chan struct{}) {
+	if s.reloadURL == "" {
+		return
+	}
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			s.reload()
+		}
+	}
+}
+
+// reload
+// - Asks Prometheus to reload its rules when the files changed since it last did, a failed reload is retried on the next tick
+// - The POST is sent without holding mu, so syncs aren't held up by a slow Prometheus
+func (s *FileSink) reload() error {
+	s.mu.Lock()
+	pending := s.reloadPending
+	s.reloadPending = false
+	s.mu.Unlock()
+
+	if !pending || s.reloadURL == "" {
+		return nil
+	}
+
+	err := s.post()
+	if err != nil {
+		warnMessage := fmt.Sprintf("[rulesink][%s] %s", s.reloadURL, err)
+		log.Sugar.Warnf(warnMessage)
+		sentryclient.SentryMessage(warnMessage)
+
+		s.mu.Lock()
+		s.reloadPending = true
+		s.mu.Unlock()
+		return err
+	}
+	log.Sugar.Debugw("reloaded Prometheus", "url", s.reloadURL)
+
+	return nil
+}
+
+func (s *FileSink) post() error {
+	resp, err := s.client.Post(s.reloadURL, "", nil)
+	if err != nil {
+		return fmt.Errorf("error reloading Prometheus: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error reloading Prometheus: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// writeFile
+// - Writes through a temporary file and renames it, so Prometheus never reads a partly written rule file
+func writeFile(path string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
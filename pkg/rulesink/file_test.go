@@ -0,0 +1,249 @@
+package rulesink
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+)
+
+var testWorkload = Workload{Kind: "Deployment", Namespace: "shop", Name: "basket"}
+
+func testPrometheusRule(name, alert string) *monitoringv1.PrometheusRule {
+	return &monitoringv1.PrometheusRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		Spec: monitoringv1.PrometheusRuleSpec{
+			Groups: []monitoringv1.RuleGroup{{
+				Name: name + ".rules",
+				Rules: []monitoringv1.Rule{{
+					Alert:  alert,
+					Expr:   intstr.FromString(`up{namespace="shop"} == 0`),
+					For:    "5m",
+					Labels: map[string]string{"owner": "team-basket"},
+				}},
+			}},
+		},
+	}
+}
+
+// testReloader
+// - A Prometheus /-/reload endpoint counting its reloads, answering with status
+func testReloader(t *testing.T, status *int32) (*httptest.Server, *int32) {
+	reloads := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, r.Method, http.MethodPost)
+		assert.Equal(t, r.URL.Path, "/-/reload")
+		w.WriteHeader(int(atomic.LoadInt32(status)))
+		if atomic.LoadInt32(status) == http.StatusOK {
+			atomic.AddInt32(reloads, 1)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, reloads
+}
+
+func TestFileSinkSync(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	status := int32(http.StatusOK)
+	server, reloads := testReloader(t, &status)
+
+	directory := t.TempDir()
+	sink, err := NewFileSink(directory, server.URL+"/-/reload", "")
+	assert.Assert(t, is.Nil(err))
+
+	prometheusRules := []*monitoringv1.PrometheusRule{testPrometheusRule("shop-basket-replicas", "basket-replicas"), testPrometheusRule("shop-basket-restarts", "basket-restarts")}
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, prometheusRules, nil)))
+
+	// One file for the workload, which Prometheus can load
+	path := filepath.Join(directory, "deployment_shop_basket.yaml")
+	groups, errs := rulefmt.ParseFile(path)
+	assert.Assert(t, is.Len(errs, 0))
+	assert.Assert(t, is.Len(groups.Groups, 2))
+	assert.Equal(t, groups.Groups[0].Name, "shop/shop-basket-replicas/shop-basket-replicas.rules")
+	assert.Equal(t, groups.Groups[1].Rules[0].Alert.Value, "basket-restarts")
+
+	// Changes are reloaded once, however many syncs wrote them
+	assert.Equal(t, atomic.LoadInt32(reloads), int32(0))
+	assert.Assert(t, is.Nil(sink.reload()))
+	assert.Assert(t, is.Nil(sink.reload()))
+	assert.Equal(t, atomic.LoadInt32(reloads), int32(1))
+
+	// Unchanged rules don't reload Prometheus
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, prometheusRules, nil)))
+	assert.Assert(t, is.Nil(sink.reload()))
+	assert.Equal(t, atomic.LoadInt32(reloads), int32(1))
+
+	// Kept rules stay in the file alongside the newly rendered ones
+	changed := []*monitoringv1.PrometheusRule{testPrometheusRule("shop-basket-replicas", "basket-replicas-changed")}
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, changed, map[string]bool{"shop/shop-basket-restarts": true})))
+	groups, errs = rulefmt.ParseFile(path)
+	assert.Assert(t, is.Len(errs, 0))
+	assert.Assert(t, is.Len(groups.Groups, 2))
+	assert.Equal(t, groups.Groups[0].Rules[0].Alert.Value, "basket-replicas-changed")
+	assert.Equal(t, groups.Groups[1].Rules[0].Alert.Value, "basket-restarts")
+
+	// Rules which aren't kept go once they're no longer rendered
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, changed, nil)))
+	groups, _ = rulefmt.ParseFile(path)
+	assert.Assert(t, is.Len(groups.Groups, 1))
+
+	// KeepAll keeps every group which isn't rendered again
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, nil, map[string]bool{KeepAll: true})))
+	groups, _ = rulefmt.ParseFile(path)
+	assert.Assert(t, is.Len(groups.Groups, 1))
+
+	// No rules remove the file
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, nil, nil)))
+	_, err = os.Stat(path)
+	assert.Assert(t, os.IsNotExist(err))
+	assert.Assert(t, is.Nil(sink.reload()))
+	assert.Equal(t, atomic.LoadInt32(reloads), int32(2))
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(directory)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(entries, 0))
+}
+
+func TestFileSinkDuplicateGroupNames(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	directory := t.TempDir()
+	sink, err := NewFileSink(directory, "", "")
+	assert.Assert(t, is.Nil(err))
+
+	// Both PrometheusRules have a group named shop-basket.rules, in different namespaces
+	shop := testPrometheusRule("shop-basket", "basket-replicas")
+	monitoring := testPrometheusRule("shop-basket", "basket-restarts")
+	monitoring.Namespace = "monitoring"
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, []*monitoringv1.PrometheusRule{shop, monitoring}, nil)))
+
+	groups, errs := rulefmt.ParseFile(filepath.Join(directory, "deployment_shop_basket.yaml"))
+	assert.Assert(t, is.Len(errs, 0))
+	assert.Assert(t, is.Len(groups.Groups, 2))
+}
+
+func TestFileSinkKeepsConsolidatedSourceRules(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	directory := t.TempDir()
+	sink, err := NewFileSink(directory, "", "")
+	assert.Assert(t, is.Nil(err))
+
+	// A consolidated rule both shop/basket-replicas and shop/basket-restarts were merged into
+	consolidated := testPrometheusRule("shop-basket-deployment", "basket-replicas")
+	consolidated.Spec.Groups[0].Rules = append(consolidated.Spec.Groups[0].Rules, monitoringv1.Rule{Alert: "basket-restarts", Expr: intstr.FromString("1")})
+	SetRuleSources(consolidated, RuleSources{
+		"shop/basket-replicas": {"shop-basket-deployment.rules": {0}},
+		"shop/basket-restarts": {"shop-basket-deployment.rules": {1}},
+	})
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, []*monitoringv1.PrometheusRule{consolidated}, nil)))
+
+	// basket-restarts is now invalid, so only basket-replicas is merged
+	merged := testPrometheusRule("shop-basket-deployment", "basket-replicas")
+	SetRuleSources(merged, RuleSources{"shop/basket-replicas": {"shop-basket-deployment.rules": {0}}})
+	keep := map[string]bool{"shop/basket-restarts": true}
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, []*monitoringv1.PrometheusRule{merged}, keep)))
+
+	// Its rule is copied from the existing file, and still is on the next sync
+	path := filepath.Join(directory, "deployment_shop_basket.yaml")
+	for i := 0; i < 2; i++ {
+		groups, errs := rulefmt.ParseFile(path)
+		assert.Assert(t, is.Len(errs, 0))
+		assert.Assert(t, is.Len(groups.Groups, 1))
+		assert.Assert(t, is.Len(groups.Groups[0].Rules, 2))
+		assert.Equal(t, groups.Groups[0].Rules[1].Alert.Value, "basket-restarts")
+		assert.Assert(t, is.Nil(sink.Sync(testWorkload, []*monitoringv1.PrometheusRule{merged}, keep)))
+	}
+
+	// Once it's fixed or removed its rule goes
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, []*monitoringv1.PrometheusRule{merged}, nil)))
+	groups, _ := rulefmt.ParseFile(path)
+	assert.Assert(t, is.Len(groups.Groups[0].Rules, 1))
+}
+
+func TestFileSinkReloadRetried(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	status := int32(http.StatusServiceUnavailable)
+	server, reloads := testReloader(t, &status)
+
+	sink, err := NewFileSink(t.TempDir(), server.URL+"/-/reload", "")
+	assert.Assert(t, is.Nil(err))
+
+	// The file is written even though Prometheus can't reload it yet
+	prometheusRules := []*monitoringv1.PrometheusRule{testPrometheusRule("shop-basket-replicas", "basket-replicas")}
+	assert.Assert(t, is.Nil(sink.Sync(testWorkload, prometheusRules, nil)))
+	assert.ErrorContains(t, sink.reload(), "503")
+
+	// The reload is retried on the next tick
+	atomic.StoreInt32(&status, http.StatusOK)
+	assert.Assert(t, is.Nil(sink.reload()))
+	assert.Equal(t, atomic.LoadInt32(reloads), int32(1))
+}
+
+func TestFileSinkDeleteAndPrune(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	directory := t.TempDir()
+	sink, err := NewFileSink(directory, "", "")
+	assert.Assert(t, is.Nil(err))
+
+	prometheusRules := []*monitoringv1.PrometheusRule{testPrometheusRule("shop-basket-replicas", "basket-replicas")}
+	ingress := Workload{Kind: "Ingress", Namespace: "shop", Name: "basket"}
+	deleted := Workload{Kind: "Deployment", Namespace: "shop", Name: "search"}
+	for _, workload := range []Workload{testWorkload, ingress, deleted} {
+		assert.Assert(t, is.Nil(sink.Sync(workload, prometheusRules, nil)))
+	}
+	assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, "recording.yaml"), []byte("groups: []\n"), 0644)))
+
+	assert.Assert(t, is.Nil(sink.Delete(ingress)))
+	assert.Assert(t, is.Nil(sink.Delete(ingress)))
+
+	// Files of workloads which no longer exist go, files Heimdall didn't write stay
+	assert.Assert(t, is.Nil(sink.Prune([]Workload{testWorkload})))
+	files, err := filepath.Glob(filepath.Join(directory, "*"))
+	assert.Assert(t, is.Nil(err))
+	assert.DeepEqual(t, files, []string{
+		filepath.Join(directory, "deployment_shop_basket.yaml"),
+		filepath.Join(directory, "recording.yaml"),
+	})
+}
+
+func TestFileSinkPruneNamespace(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	directory := t.TempDir()
+	sink, err := NewFileSink(directory, "", "shop")
+	assert.Assert(t, is.Nil(err))
+
+	prometheusRules := []*monitoringv1.PrometheusRule{testPrometheusRule("shop-basket-replicas", "basket-replicas")}
+	other := Workload{Kind: "Deployment", Namespace: "search", Name: "api"}
+	for _, workload := range []Workload{testWorkload, other} {
+		assert.Assert(t, is.Nil(sink.Sync(workload, prometheusRules, nil)))
+	}
+
+	// Workloads in other namespaces aren't listed, another Heimdall may be writing their files
+	assert.Assert(t, is.Nil(sink.Prune(nil)))
+	files, err := filepath.Glob(filepath.Join(directory, "*"))
+	assert.Assert(t, is.Nil(err))
+	assert.DeepEqual(t, files, []string{filepath.Join(directory, "deployment_search_api.yaml")})
+}
+
+func TestNewFileSink(t *testing.T) {
+	_, err := NewFileSink(filepath.Join(t.TempDir(), "missing"), "", "")
+	assert.ErrorContains(t, err, "no such file or directory")
+}
@@ -0,0 +1,36 @@
+package rulesink
+
+import (
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Workload
+// - An Ingress or Deployment the PrometheusRules are generated for
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// Object is the workload itself, nil once it's been deleted
+	Object metav1.Object
+	// Generated reports whether an existing object was generated by this install, with the template manager the workload is synced with
+	Generated func(obj metav1.Object) bool
+}
+
+// KeepAll is a key of keep which leaves all of the workload's existing rules as they are, for when a template fails before rendering the rules it would keep
+const KeepAll = "*"
+
+// RuleSink
+// - Stores the PrometheusRules generated for each workload, as PrometheusRule objects or as Prometheus rule files
+type RuleSink interface {
+	// Sync makes the rules stored for the workload match prometheusRules, existing rules whose key is in keep are left as they are, all of them when KeepAll is
+	Sync(workload Workload, prometheusRules []*monitoringv1.PrometheusRule, keep map[string]bool) error
+	// Delete removes the rules stored for a workload which no longer exists
+	Delete(workload Workload) error
+}
+
+// Pruner
+// - Implemented by sinks which have to remove the rules of workloads deleted while Heimdall wasn't running
+type Pruner interface {
+	Prune(workloads []Workload) error
+}
@@ -0,0 +1,125 @@
+package rulesink
+
+import (
+	"encoding/json"
+	"sort"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+)
+
+// SourcesAnnotation records which rules of a consolidated PrometheusRule each per-template PrometheusRule merged into it contributed
+const SourcesAnnotation = "com.uswitch.heimdall-generated/sources"
+
+// RuleSources
+// - Maps the key of each PrometheusRule merged into a consolidated one to the positions of its rules in each group of it
+type RuleSources map[string]map[string][]int
+
+// SetRuleSources
+// - Records sources on promrule in the SourcesAnnotation
+func SetRuleSources(promrule *monitoringv1.PrometheusRule, sources RuleSources) {
+	out, err := json.Marshal(sources)
+	if err != nil {
+		return
+	}
+
+	annotations := promrule.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[SourcesAnnotation] = string(out)
+	promrule.SetAnnotations(annotations)
+}
+
+// ruleSources
+// - The sources recorded on promrule, nil when it isn't a consolidated PrometheusRule
+func ruleSources(promrule *monitoringv1.PrometheusRule) RuleSources {
+	value, ok := promrule.GetAnnotations()[SourcesAnnotation]
+	if !ok {
+		return nil
+	}
+
+	sources := RuleSources{}
+	if err := json.Unmarshal([]byte(value), &sources); err != nil {
+		return nil
+	}
+
+	return sources
+}
+
+// KeepSourceRules
+// - Adds the rules existing's sources in keep contributed to promrule, when promrule doesn't have rules from them already, all of them when KeepAll is
+// - A consolidated rule still merges the valid templates when one is invalid, this keeps the invalid one's working rules in it until it's fixed
+// - Returns promrule as it is when neither is consolidated or there's nothing to keep
+func KeepSourceRules(existing, promrule *monitoringv1.PrometheusRule, keep map[string]bool) *monitoringv1.PrometheusRule {
+	existingSources := ruleSources(existing)
+	sources := ruleSources(promrule)
+	if existingSources == nil || sources == nil || len(keep) == 0 {
+		return promrule
+	}
+
+	keys := []string{}
+	for key := range existingSources {
+		if _, ok := sources[key]; ok {
+			continue
+		}
+		if keep[KeepAll] || keep[key] {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return promrule
+	}
+	sort.Strings(keys)
+
+	existingGroups := map[string]monitoringv1.RuleGroup{}
+	for _, group := range existing.Spec.Groups {
+		existingGroups[group.Name] = group
+	}
+
+	kept := promrule.DeepCopy()
+	for _, key := range keys {
+		groupNames := []string{}
+		for groupName := range existingSources[key] {
+			groupNames = append(groupNames, groupName)
+		}
+		sort.Strings(groupNames)
+
+		sources[key] = map[string][]int{}
+		for _, groupName := range groupNames {
+			existingGroup, ok := existingGroups[groupName]
+			if !ok {
+				continue
+			}
+			rules := []monitoringv1.Rule{}
+			for _, position := range existingSources[key][groupName] {
+				if position >= 0 && position < len(existingGroup.Rules) {
+					rules = append(rules, *existingGroup.Rules[position].DeepCopy())
+				}
+			}
+			if len(rules) == 0 {
+				continue
+			}
+
+			index := -1
+			for i := range kept.Spec.Groups {
+				if kept.Spec.Groups[i].Name == groupName {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				kept.Spec.Groups = append(kept.Spec.Groups, monitoringv1.RuleGroup{Name: groupName, Interval: existingGroup.Interval})
+				index = len(kept.Spec.Groups) - 1
+			}
+
+			start := len(kept.Spec.Groups[index].Rules)
+			for i := range rules {
+				sources[key][groupName] = append(sources[key][groupName], start+i)
+			}
+			kept.Spec.Groups[index].Rules = append(kept.Spec.Groups[index].Rules, rules...)
+		}
+	}
+	SetRuleSources(kept, sources)
+
+	return kept
+}
@@ -0,0 +1,363 @@
+package ruletest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/rules"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/uswitch/heimdall/pkg/render"
+)
+
+// testProberURL and testProberModule are the blackbox exporter tests render with, so objects enabling a Probe give templates .Probe
+const (
+	testProberURL    = "blackbox-exporter:9115"
+	testProberModule = "http_2xx"
+)
+
+// File
+// - A test file in the style of promtool's rule unit tests, the rules under test are rendered from templates
+type File struct {
+	EvaluationInterval model.Duration `yaml:"evaluation_interval,omitempty"`
+	Tests              []TestGroup    `yaml:"tests"`
+}
+
+// TestGroup
+// - A set of input series, the object whose annotations select the templates and the alerts expected from them
+type TestGroup struct {
+	Name string `yaml:"name,omitempty"`
+	// Interval between the samples of the input series, defaults to the evaluation interval
+	Interval model.Duration `yaml:"interval,omitempty"`
+	// Object is the Ingress or Deployment whose heimdall annotations are rendered
+	Object map[string]interface{} `yaml:"object"`
+	// Objects are other objects owner resolution may need, such as the Ingress's Service and Deployment
+	Objects             []map[string]interface{} `yaml:"objects,omitempty"`
+	NamespacePrometheus map[string]string        `yaml:"namespace_prometheus,omitempty"`
+	InputSeries         []Series                 `yaml:"input_series"`
+	AlertRuleTests      []AlertTestCase          `yaml:"alert_rule_test,omitempty"`
+}
+
+// Series
+// - An input series in promtool's expanding notation, such as '0+10x10'
+type Series struct {
+	Series string `yaml:"series"`
+	Values string `yaml:"values"`
+}
+
+// AlertTestCase
+// - The alerts expected to be firing for alertname at eval_time
+type AlertTestCase struct {
+	EvalTime  model.Duration `yaml:"eval_time"`
+	Alertname string         `yaml:"alertname"`
+	ExpAlerts []Alert        `yaml:"exp_alerts"`
+}
+
+// Alert
+// - A firing alert, alertname is added to the labels automatically
+type Alert struct {
+	ExpLabels      map[string]string `yaml:"exp_labels"`
+	ExpAnnotations map[string]string `yaml:"exp_annotations"`
+}
+
+// RunFile
+// - Runs every test group in file against the templates in directory and returns the failures
+// - The error is only set when the file itself can't be loaded
+func RunFile(directory, file string) ([]error, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	testFile := File{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&testFile); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", file, err)
+	}
+
+	if testFile.EvaluationInterval == 0 {
+		testFile.EvaluationInterval = model.Duration(time.Minute)
+	}
+
+	failures := []error{}
+	for i, group := range testFile.Tests {
+		name := group.Name
+		if name == "" {
+			name = fmt.Sprintf("tests[%d]", i)
+		}
+
+		for _, err := range group.test(directory, time.Duration(testFile.EvaluationInterval)) {
+			failures = append(failures, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+
+	return failures, nil
+}
+
+// prometheusRules
+// - Renders the PrometheusRules for the group's object the same way `heimdall render` does
+func (tg *TestGroup) prometheusRules(directory string) ([]*monitoringv1.PrometheusRule, error) {
+	if tg.Object == nil {
+		return nil, fmt.Errorf("object is required")
+	}
+
+	var manifests bytes.Buffer
+	for _, obj := range append([]map[string]interface{}{tg.Object}, tg.Objects...) {
+		out, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&manifests, "---\n%s", out)
+	}
+
+	objects, err := render.Decode(&manifests)
+	if err != nil {
+		return nil, err
+	}
+
+	return render.PrometheusRules(objects, render.Options{
+		Templates:           directory,
+		DefaultNamespace:    "default",
+		NamespacePrometheus: tg.NamespacePrometheus,
+		ProberURL:           testProberURL,
+		ProberModule:        testProberModule,
+	})
+}
+
+// ruleGroups
+// - Turns the rendered PrometheusRules into rule groups the Prometheus rules engine can evaluate
+func ruleGroups(prometheusRules []*monitoringv1.PrometheusRule, interval time.Duration, opts *rules.ManagerOptions) ([]*rules.Group, error) {
+	groups := []*rules.Group{}
+
+	for _, promrule := range prometheusRules {
+		for _, group := range promrule.Spec.Groups {
+			groupRules := []rules.Rule{}
+
+			for _, rule := range group.Rules {
+				expr, err := parser.ParseExpr(rule.Expr.String())
+				if err != nil {
+					return nil, fmt.Errorf("rule group %s: %v", group.Name, err)
+				}
+
+				if rule.Record != "" {
+					groupRules = append(groupRules, rules.NewRecordingRule(rule.Record, expr, labels.FromMap(rule.Labels)))
+					continue
+				}
+
+				var hold model.Duration
+				if rule.For != "" {
+					if hold, err = model.ParseDuration(rule.For); err != nil {
+						return nil, fmt.Errorf("rule group %s: %v", group.Name, err)
+					}
+				}
+
+				alertingRule := rules.NewAlertingRule(
+					rule.Alert, expr, time.Duration(hold), labels.FromMap(rule.Labels), labels.FromMap(rule.Annotations),
+					nil, "", true, log.NewNopLogger(),
+				)
+				groupRules = append(groupRules, alertingRule)
+			}
+
+			groupInterval := interval
+			if group.Interval != "" {
+				parsed, err := model.ParseDuration(group.Interval)
+				if err != nil {
+					return nil, fmt.Errorf("rule group %s: %v", group.Name, err)
+				}
+				groupInterval = time.Duration(parsed)
+			}
+
+			groups = append(groups, rules.NewGroup(rules.GroupOptions{
+				Name:     group.Name,
+				File:     promrule.Namespace + "/" + promrule.Name,
+				Interval: groupInterval,
+				Rules:    groupRules,
+				Opts:     opts,
+			}))
+		}
+	}
+
+	return groups, nil
+}
+
+// test
+// - Evaluates the rendered rules against the input series and compares the firing alerts, following promtool
+func (tg *TestGroup) test(directory string, evalInterval time.Duration) []error {
+	prometheusRules, err := tg.prometheusRules(directory)
+	if err != nil {
+		return []error{err}
+	}
+
+	interval := evalInterval
+	if tg.Interval != 0 {
+		interval = time.Duration(tg.Interval)
+	}
+
+	suite, err := promql.NewLazyLoader(nil, tg.seriesLoadingString(interval), promql.LazyLoaderOpts{
+		EnableAtModifier:     true,
+		EnableNegativeOffset: true,
+	})
+	if err != nil {
+		return []error{err}
+	}
+	defer suite.Close()
+	suite.SubqueryInterval = evalInterval
+
+	opts := &rules.ManagerOptions{
+		QueryFunc:  rules.EngineQueryFunc(suite.QueryEngine(), suite.Storage()),
+		Appendable: suite.Storage(),
+		Context:    context.Background(),
+		NotifyFunc: func(ctx context.Context, expr string, alerts ...*rules.Alert) {},
+		Logger:     log.NewNopLogger(),
+	}
+
+	groups, err := ruleGroups(prometheusRules, evalInterval, opts)
+	if err != nil {
+		return []error{err}
+	}
+
+	alertTests := map[model.Duration][]AlertTestCase{}
+	alertEvalTimes := []model.Duration{}
+	for _, alertTest := range tg.AlertRuleTests {
+		if alertTest.Alertname == "" {
+			return []error{fmt.Errorf("an item under alert_rule_test misses required attribute alertname at eval_time %v", alertTest.EvalTime)}
+		}
+
+		if _, ok := alertTests[alertTest.EvalTime]; !ok {
+			alertEvalTimes = append(alertEvalTimes, alertTest.EvalTime)
+		}
+		alertTests[alertTest.EvalTime] = append(alertTests[alertTest.EvalTime], alertTest)
+	}
+	sort.Slice(alertEvalTimes, func(i, j int) bool { return alertEvalTimes[i] < alertEvalTimes[j] })
+
+	mint := time.Unix(0, 0).UTC()
+	maxt := mint
+	if len(alertEvalTimes) != 0 {
+		maxt = mint.Add(time.Duration(alertEvalTimes[len(alertEvalTimes)-1]))
+	}
+
+	errs := []error{}
+	curr := 0
+	for ts := mint; !ts.After(maxt); ts = ts.Add(evalInterval) {
+		evalErrs := []error{}
+		suite.WithSamplesTill(ts, func(err error) {
+			if err != nil {
+				evalErrs = append(evalErrs, err)
+				return
+			}
+
+			for _, group := range groups {
+				group.Eval(suite.Context(), ts)
+				for _, rule := range group.Rules() {
+					if rule.LastError() != nil {
+						evalErrs = append(evalErrs, fmt.Errorf("rule: %s, time: %s, err: %v", rule.Name(), ts.Sub(mint), rule.LastError()))
+					}
+				}
+			}
+		})
+		if len(evalErrs) != 0 {
+			return append(errs, evalErrs...)
+		}
+
+		// Alerts expected at eval_time are compared with the evaluation at ts when ts <= eval_time < ts+evalInterval
+		for curr < len(alertEvalTimes) && time.Duration(alertEvalTimes[curr]) < ts.Add(evalInterval).Sub(mint) {
+			evalTime := alertEvalTimes[curr]
+			for _, alertTest := range alertTests[evalTime] {
+				got := firingAlerts(groups, alertTest.Alertname)
+				exp := alertTest.expectedAlerts()
+
+				if !reflect.DeepEqual(exp, got) {
+					errs = append(errs, fmt.Errorf("alertname: %s, time: %s,\n    exp: %v,\n    got: %v", alertTest.Alertname, evalTime, exp, got))
+				}
+			}
+			curr++
+		}
+	}
+
+	return errs
+}
+
+func (tg *TestGroup) seriesLoadingString(interval time.Duration) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "load %v\n", model.Duration(interval))
+	for _, series := range tg.InputSeries {
+		fmt.Fprintf(&builder, "  %v %v\n", series.Series, series.Values)
+	}
+
+	return builder.String()
+}
+
+// alert
+// - The labels and annotations of a firing alert in a form that can be sorted and compared
+type alert struct {
+	Labels      labels.Labels
+	Annotations labels.Labels
+}
+
+func (a alert) String() string {
+	return fmt.Sprintf("{labels: %s, annotations: %s}", a.Labels, a.Annotations)
+}
+
+func sortAlerts(alerts []alert) []alert {
+	sort.Slice(alerts, func(i, j int) bool {
+		if c := labels.Compare(alerts[i].Labels, alerts[j].Labels); c != 0 {
+			return c < 0
+		}
+		return labels.Compare(alerts[i].Annotations, alerts[j].Annotations) < 0
+	})
+
+	return alerts
+}
+
+func firingAlerts(groups []*rules.Group, alertname string) []alert {
+	alerts := []alert{}
+	for _, group := range groups {
+		for _, rule := range group.Rules() {
+			alertingRule, ok := rule.(*rules.AlertingRule)
+			if !ok || alertingRule.Name() != alertname {
+				continue
+			}
+
+			for _, active := range alertingRule.ActiveAlerts() {
+				if active.State == rules.StateFiring {
+					alerts = append(alerts, alert{
+						Labels:      append(labels.Labels{}, active.Labels...),
+						Annotations: append(labels.Labels{}, active.Annotations...),
+					})
+				}
+			}
+		}
+	}
+
+	return sortAlerts(alerts)
+}
+
+func (tc AlertTestCase) expectedAlerts() []alert {
+	alerts := []alert{}
+	for _, exp := range tc.ExpAlerts {
+		expLabels := map[string]string{labels.AlertName: tc.Alertname}
+		for name, value := range exp.ExpLabels {
+			expLabels[name] = value
+		}
+
+		alerts = append(alerts, alert{
+			Labels:      labels.FromMap(expLabels),
+			Annotations: labels.FromMap(exp.ExpAnnotations),
+		})
+	}
+
+	return sortAlerts(alerts)
+}
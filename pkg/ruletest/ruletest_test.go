@@ -0,0 +1,38 @@
+package ruletest
+
+import (
+	"path/filepath"
+	"testing"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestShippedTemplateTests(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	files, err := filepath.Glob("../../kube/config/tests/*.yaml")
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, len(files) > 0)
+
+	for _, file := range files {
+		failures, err := RunFile("../../kube/config/templates", file)
+		assert.Assert(t, is.Nil(err))
+		assert.Assert(t, is.Len(failures, 0), "%s: %v", file, failures)
+	}
+}
+
+func TestWrongExpectedAlert(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	failures, err := RunFile("../../kube/config/templates", "testdata/wrong-owner.yaml")
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(failures, 1))
+	assert.Assert(t, is.Contains(failures[0].Error(), `owner="team-shop"`))
+}
+
+func TestUnknownField(t *testing.T) {
+	_, err := RunFile("../../kube/config/templates", "testdata/unknown-field.yaml")
+	assert.Assert(t, err != nil)
+}
@@ -0,0 +1,181 @@
+package templates
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/rulesink"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// SetConsolidateRules
+// - When set, the PrometheusRules rendered for an object are merged into one per workload instead of one per template
+func (a *PrometheusRuleTemplateManager) SetConsolidateRules(consolidate bool) {
+	a.consolidate = consolidate
+}
+
+// consolidationTarget
+// - The namespace and labels a rendered PrometheusRule is applied with, rules can only be merged when these match
+// - The labels decide which Prometheus picks the rule up, so they can't be combined
+type consolidationTarget struct {
+	namespace string
+	labels    string
+}
+
+func targetOf(promrule *monitoringv1.PrometheusRule) consolidationTarget {
+	return consolidationTarget{namespace: promrule.Namespace, labels: labels.Set(promrule.Labels).String()}
+}
+
+// consolidatedName
+// - <namespace>-<name>-<kind> of the owning object, with a hash of the labels when the owner has rules with different labels in one namespace
+func consolidatedName(owner metav1.Object, kind string, target consolidationTarget, labelSets int) string {
+	name := fmt.Sprintf("%s-%s-%s", owner.GetNamespace(), owner.GetName(), strings.ToLower(kind))
+	if labelSets > 1 {
+		hash := fnv.New32a()
+		hash.Write([]byte(target.labels))
+		name = fmt.Sprintf("%s-%08x", name, hash.Sum32())
+	}
+
+	return name
+}
+
+// consolidatePrometheusRules
+// - Merges the PrometheusRules rendered for owner into one per namespace and set of labels, a single one for most workloads
+// - Groups with the same name are merged, so templates can add rules to a shared group
+// - Invalid rules are left out of the merged rules and returned under their own names, so the per-template rules they'd replace are kept
+// - The rules each PrometheusRule contributed are recorded in the rulesink.SourcesAnnotation, sinks copy an invalid one's rules from the existing merged rule with it
+// - And under the merged rule's name when nothing valid is merged into it, so it is kept too
+func consolidatePrometheusRules(owner metav1.Object, kind string, prometheusRules []*monitoringv1.PrometheusRule, invalidRules []InvalidRule) ([]*monitoringv1.PrometheusRule, []InvalidRule) {
+	sorted := append([]*monitoringv1.PrometheusRule{}, prometheusRules...)
+	sort.Slice(sorted, func(i, j int) bool { return prometheusRuleKey(sorted[i]) < prometheusRuleKey(sorted[j]) })
+
+	labelSets := map[string]map[string]bool{}
+	countTarget := func(target consolidationTarget) {
+		if labelSets[target.namespace] == nil {
+			labelSets[target.namespace] = map[string]bool{}
+		}
+		labelSets[target.namespace][target.labels] = true
+	}
+	for _, promrule := range sorted {
+		countTarget(targetOf(promrule))
+	}
+	for _, invalidRule := range invalidRules {
+		if invalidRule.PrometheusRule != nil {
+			countTarget(targetOf(invalidRule.PrometheusRule))
+		}
+	}
+
+	nameOf := func(target consolidationTarget) string {
+		return consolidatedName(owner, kind, target, len(labelSets[target.namespace]))
+	}
+
+	consolidatedRules := map[consolidationTarget]*monitoringv1.PrometheusRule{}
+	sources := map[consolidationTarget]rulesink.RuleSources{}
+	targets := []consolidationTarget{}
+	for _, promrule := range sorted {
+		target := targetOf(promrule)
+
+		consolidated, ok := consolidatedRules[target]
+		if !ok {
+			consolidated = &monitoringv1.PrometheusRule{
+				TypeMeta: promrule.TypeMeta,
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            nameOf(target),
+					Namespace:       promrule.Namespace,
+					Labels:          promrule.Labels,
+					OwnerReferences: promrule.OwnerReferences,
+				},
+			}
+			consolidatedRules[target] = consolidated
+			sources[target] = rulesink.RuleSources{}
+			targets = append(targets, target)
+		}
+
+		sources[target][prometheusRuleKey(promrule)] = rulePositions(consolidated.Spec.Groups, promrule.Spec.Groups)
+		consolidated.Spec.Groups = mergeRuleGroups(consolidated.Name, consolidated.Spec.Groups, promrule.Spec.Groups)
+	}
+
+	consolidatedInvalidRules := []InvalidRule{}
+	for _, invalidRule := range invalidRules {
+		consolidatedInvalidRules = append(consolidatedInvalidRules, invalidRule)
+
+		// All the existing rules are kept for a template which rendered nothing
+		if invalidRule.PrometheusRule == nil {
+			continue
+		}
+
+		target := targetOf(invalidRule.PrometheusRule)
+		if _, ok := consolidatedRules[target]; ok {
+			continue
+		}
+
+		consolidated := invalidRule.PrometheusRule.DeepCopy()
+		consolidated.Name = nameOf(target)
+		consolidatedInvalidRules = append(consolidatedInvalidRules, InvalidRule{
+			Template:       invalidRule.Template,
+			PrometheusRule: consolidated,
+			Err:            invalidRule.Err,
+		})
+	}
+
+	merged := make([]*monitoringv1.PrometheusRule, 0, len(targets))
+	for _, target := range targets {
+		rulesink.SetRuleSources(consolidatedRules[target], sources[target])
+		merged = append(merged, consolidatedRules[target])
+	}
+
+	return merged, consolidatedInvalidRules
+}
+
+// rulePositions
+// - The positions groups' rules will have once mergeRuleGroups appends them to merged
+func rulePositions(merged, groups []monitoringv1.RuleGroup) map[string][]int {
+	positions := map[string][]int{}
+	for _, group := range groups {
+		start := 0
+		for i := range merged {
+			if merged[i].Name == group.Name {
+				start = len(merged[i].Rules)
+				break
+			}
+		}
+
+		for i := range group.Rules {
+			positions[group.Name] = append(positions[group.Name], start+i)
+		}
+	}
+
+	return positions
+}
+
+// mergeRuleGroups
+// - Appends groups to merged, the rules of a group whose name is already there are added to the existing group
+func mergeRuleGroups(name string, merged, groups []monitoringv1.RuleGroup) []monitoringv1.RuleGroup {
+	for _, group := range groups {
+		existing := -1
+		for i := range merged {
+			if merged[i].Name == group.Name {
+				existing = i
+				break
+			}
+		}
+
+		if existing < 0 {
+			merged = append(merged, *group.DeepCopy())
+			continue
+		}
+
+		if merged[existing].Interval != group.Interval {
+			log.Sugar.Warnf("[%s] group \"%s\" is rendered with intervals \"%s\" and \"%s\", using \"%s\"",
+				name, group.Name, merged[existing].Interval, group.Interval, merged[existing].Interval)
+		}
+		merged[existing].Rules = append(merged[existing].Rules, group.DeepCopy().Rules...)
+	}
+
+	return merged
+}
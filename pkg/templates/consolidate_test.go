@@ -0,0 +1,136 @@
+package templates
+
+import (
+	"fmt"
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/uswitch/heimdall/pkg/rulesink"
+)
+
+func testRule(namespace, name string, labels map[string]string, groups ...string) *monitoringv1.PrometheusRule {
+	promrule := &monitoringv1.PrometheusRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+	}
+	for _, group := range groups {
+		promrule.Spec.Groups = append(promrule.Spec.Groups, monitoringv1.RuleGroup{
+			Name:  group,
+			Rules: []monitoringv1.Rule{{Alert: name, Expr: intstr.FromString("up == 0")}},
+		})
+	}
+
+	return promrule
+}
+
+func TestConsolidatePrometheusRules(t *testing.T) {
+	owner := &metav1.ObjectMeta{Name: "web", Namespace: "shop"}
+	alerts := map[string]string{"role": "alert-rules"}
+	recording := map[string]string{"role": "recording-rules"}
+
+	merged, invalid := consolidatePrometheusRules(owner, "Deployment", []*monitoringv1.PrometheusRule{
+		testRule("shop", "web-b", alerts, "b.rules", "shared.rules"),
+		testRule("shop", "web-a", alerts, "a.rules", "shared.rules"),
+		testRule("monitoring", "web-c", alerts, "c.rules"),
+	}, nil)
+	assert.Assert(t, is.Len(invalid, 0))
+	assert.Assert(t, is.Len(merged, 2))
+
+	assert.Equal(t, merged[0].Namespace, "monitoring")
+	assert.Equal(t, merged[0].Name, "shop-web-deployment")
+
+	assert.Equal(t, merged[1].Namespace, "shop")
+	assert.Equal(t, merged[1].Name, "shop-web-deployment")
+	assert.DeepEqual(t, merged[1].Labels, alerts)
+
+	groups := []string{}
+	for _, group := range merged[1].Spec.Groups {
+		groups = append(groups, fmt.Sprintf("%s:%d", group.Name, len(group.Rules)))
+	}
+	assert.DeepEqual(t, groups, []string{"a.rules:1", "shared.rules:2", "b.rules:1"})
+
+	// Rules with different labels in one namespace can't be merged, their names get a hash of the labels
+	merged, _ = consolidatePrometheusRules(owner, "Deployment", []*monitoringv1.PrometheusRule{
+		testRule("shop", "web-a", alerts, "a.rules"),
+		testRule("shop", "web-b", recording, "b.rules"),
+	}, nil)
+	assert.Assert(t, is.Len(merged, 2))
+	assert.Assert(t, merged[0].Name != merged[1].Name)
+	assert.Assert(t, is.Regexp(`^shop-web-deployment-[0-9a-f]{8}$`, merged[0].Name))
+}
+
+func TestConsolidatePrometheusRulesInvalid(t *testing.T) {
+	owner := &metav1.ObjectMeta{Name: "web", Namespace: "shop"}
+
+	merged, invalid := consolidatePrometheusRules(owner, "Ingress", []*monitoringv1.PrometheusRule{
+		testRule("shop", "web-a", nil, "a.rules"),
+		testRule("ingress", "web-b", nil, "b.rules"),
+	}, []InvalidRule{
+		{Template: "c", PrometheusRule: testRule("shop", "web-c", nil, "c.rules"), Err: fmt.Errorf("invalid")},
+		{Template: "d", PrometheusRule: testRule("monitoring", "web-d", nil, "d.rules"), Err: fmt.Errorf("invalid")},
+	})
+
+	// Only the invalid template's groups are left out of the merged rule in shop
+	assert.Assert(t, is.Len(merged, 2))
+	assert.Equal(t, merged[0].Namespace, "ingress")
+	assert.Equal(t, prometheusRuleKey(merged[1]), "shop/shop-web-ingress")
+	assert.Assert(t, is.Len(merged[1].Spec.Groups, 1))
+	assert.Equal(t, merged[1].Spec.Groups[0].Name, "a.rules")
+
+	// The invalid rules keep their per-template rules, and the merged rule in monitoring which has nothing valid in it
+	keys := []string{}
+	for _, invalidRule := range invalid {
+		keys = append(keys, invalidRule.Template+":"+prometheusRuleKey(invalidRule.PrometheusRule))
+	}
+	assert.DeepEqual(t, keys, []string{"c:shop/web-c", "d:monitoring/web-d", "d:monitoring/shop-web-ingress"})
+}
+
+func TestConsolidatePrometheusRulesKeepsInvalidTemplateRules(t *testing.T) {
+	owner := &metav1.ObjectMeta{Name: "web", Namespace: "shop"}
+
+	// Both templates were valid when the existing consolidated rule was applied
+	existing, invalid := consolidatePrometheusRules(owner, "Deployment", []*monitoringv1.PrometheusRule{
+		testRule("shop", "web-a", nil, "a.rules", "shared.rules"),
+		testRule("shop", "web-b", nil, "b.rules", "shared.rules"),
+	}, nil)
+	assert.Assert(t, is.Len(invalid, 0))
+	assert.Assert(t, is.Len(existing, 1))
+
+	// web-b is now invalid, so the new merge only has web-a's groups
+	merged, invalid := consolidatePrometheusRules(owner, "Deployment", []*monitoringv1.PrometheusRule{
+		testRule("shop", "web-a", nil, "a.rules", "shared.rules"),
+	}, []InvalidRule{
+		{Template: "b", PrometheusRule: testRule("shop", "web-b", nil, "b.rules", "shared.rules"), Err: fmt.Errorf("invalid")},
+	})
+	assert.Assert(t, is.Len(merged, 1))
+	assert.Assert(t, is.Len(merged[0].Spec.Groups, 2))
+
+	keep := map[string]bool{}
+	for _, invalidRule := range invalid {
+		keep[prometheusRuleKey(invalidRule.PrometheusRule)] = true
+	}
+
+	// The sink copies web-b's rules from the existing rule into the update
+	kept := rulesink.KeepSourceRules(existing[0], merged[0], keep)
+	groups := []string{}
+	for _, group := range kept.Spec.Groups {
+		alerts := []string{}
+		for _, rule := range group.Rules {
+			alerts = append(alerts, rule.Alert)
+		}
+		groups = append(groups, fmt.Sprintf("%s:%v", group.Name, alerts))
+	}
+	assert.DeepEqual(t, groups, []string{"a.rules:[web-a]", "shared.rules:[web-a web-b]", "b.rules:[web-b]"})
+
+	// And the rules are still kept the next time web-b is invalid
+	kept = rulesink.KeepSourceRules(kept, merged[0], keep)
+	assert.Assert(t, is.Len(kept.Spec.Groups, 3))
+	assert.Assert(t, is.Len(kept.Spec.Groups[1].Rules, 2))
+
+	// Once web-b isn't kept its rules go
+	assert.Assert(t, is.Len(rulesink.KeepSourceRules(existing[0], merged[0], nil).Spec.Groups, 2))
+}
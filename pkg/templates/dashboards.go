@@ -0,0 +1,252 @@
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// DashboardManagedByLabel marks the dashboard ConfigMaps Heimdall generates, so it only updates and deletes its own
+	DashboardManagedByLabel = "app.kubernetes.io/managed-by"
+	dashboardManagedBy      = "heimdall"
+)
+
+// DefaultDashboardLabels are the labels the Grafana sidecar discovers dashboard ConfigMaps by
+var DefaultDashboardLabels = map[string]string{"grafana_dashboard": "1"}
+
+// LoadDashboardTemplates
+// - Loads the dashboard templates in directory, each named after the PrometheusRule template whose alerts it shows
+// - A dashboard is rendered for every annotation using a template with a dashboard template
+func (a *PrometheusRuleTemplateManager) LoadDashboardTemplates(directory string) error {
+	files, err := filepath.Glob(directory + "/*.tmpl")
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	dashboards := map[string]*template.Template{}
+	for _, file := range files {
+		name := templateName(file)
+		if _, ok := a.templates[name]; !ok {
+			return fmt.Errorf("%s: no PrometheusRule template \"%s\" for the dashboard", file, name)
+		}
+
+		tmpl, err := parseTemplate(file)
+		if err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+		dashboards[name] = tmpl
+	}
+
+	if len(dashboards) == 0 {
+		return fmt.Errorf("no dashboard templates defined")
+	}
+
+	a.dashboards = dashboards
+	return nil
+}
+
+// SetGrafanaURL
+// - The Grafana the dashboards are loaded into, alerts link to their dashboard there in the dashboard annotation
+func (a *PrometheusRuleTemplateManager) SetGrafanaURL(url string) {
+	a.grafanaURL = strings.TrimSuffix(url, "/")
+}
+
+// SetDashboardLabels
+// - Labels added to the dashboard ConfigMaps, DefaultDashboardLabels unless set
+func (a *PrometheusRuleTemplateManager) SetDashboardLabels(labels map[string]string) {
+	a.dashboardLabels = labels
+}
+
+// Dashboards
+// - Whether dashboard templates are loaded
+func (a *PrometheusRuleTemplateManager) Dashboards() bool {
+	return len(a.dashboards) != 0
+}
+
+// DashboardSelector
+// - Selects the dashboard ConfigMaps Heimdall generated
+func DashboardSelector() string {
+	return DashboardManagedByLabel + "=" + dashboardManagedBy
+}
+
+// dashboardUID
+// - Grafana's identifier for the dashboard of an annotation, stable between syncs and at most 40 characters
+func dashboardUID(kind string, obj metav1.Object, templateName, instance string) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%s/%s/%s/%s/%s", kind, obj.GetNamespace(), obj.GetName(), templateName, instance)
+	return fmt.Sprintf("heimdall-%016x", hash.Sum64())
+}
+
+// dashboardURL
+// - The link to the dashboard of an annotation, empty when its template has no dashboard
+func (a *PrometheusRuleTemplateManager) dashboardURL(kind string, obj metav1.Object, templateName, instance string) string {
+	if _, ok := a.dashboards[templateName]; !ok {
+		return ""
+	}
+
+	return fmt.Sprintf("%s/d/%s", a.grafanaURL, dashboardUID(kind, obj, templateName, instance))
+}
+
+// dashboardName
+// - <name>-<template>[-<instance>]-dashboard, the name of the ConfigMap holding the dashboard of an annotation
+func dashboardName(obj metav1.Object, templateName, instance string) string {
+	name := obj.GetName() + "-" + templateName
+	if instance != "" {
+		name += "-" + instance
+	}
+
+	return name + "-dashboard"
+}
+
+// CreateDashboardsFromIngress
+// - Renders a dashboard ConfigMap for each annotation on the Ingress whose template has a dashboard
+// - Also returns the names of the ConfigMaps which failed to render, whose existing dashboards should be kept
+func (a *PrometheusRuleTemplateManager) CreateDashboardsFromIngress(ingress *networkingv1.Ingress) ([]*corev1.ConfigMap, map[string]bool) {
+	// The Ingress's Probe and owner were reported when its PrometheusRules were rendered
+	params, _ := a.ingressParameters(ingress)
+	resolved := false
+
+	gvk := networkingv1.SchemeGroupVersion.WithKind("Ingress")
+	return a.createDashboards(ingress, gvk, func(templateParams map[string]interface{}, instance, dashboard string) interface{} {
+		if !resolved {
+			a.resolveIngressMetadata(params)
+			resolved = true
+		}
+		params.Threshold = thresholdParameter(templateParams)
+		params.Params = templateParams
+		params.Instance = instance
+		params.Dashboard = dashboard
+		return params
+	})
+}
+
+// CreateDashboardsFromDeployment
+// - Renders a dashboard ConfigMap for each annotation on the Deployment whose template has a dashboard
+// - Also returns the names of the ConfigMaps which failed to render, whose existing dashboards should be kept
+func (a *PrometheusRuleTemplateManager) CreateDashboardsFromDeployment(deployment *apps.Deployment, depNamespacePrometheus string) ([]*corev1.ConfigMap, map[string]bool) {
+	params, _ := a.deploymentParameters(deployment, depNamespacePrometheus)
+
+	gvk := apps.SchemeGroupVersion.WithKind("Deployment")
+	return a.createDashboards(deployment, gvk, func(templateParams map[string]interface{}, instance, dashboard string) interface{} {
+		params.Threshold = thresholdParameter(templateParams)
+		params.Params = templateParams
+		params.Instance = instance
+		params.Dashboard = dashboard
+		return params
+	})
+}
+
+// createDashboards
+// - Annotations which can't be used with their template are skipped without a warning, rendering the PrometheusRules reports them
+func (a *PrometheusRuleTemplateManager) createDashboards(obj metav1.Object, gvk schema.GroupVersionKind, templateParameters func(map[string]interface{}, string, string) interface{}) ([]*corev1.ConfigMap, map[string]bool) {
+	identifier := fmt.Sprintf("%s.%s", obj.GetNamespace(), obj.GetName())
+	logger := log.Sugar.With("name", obj.GetName(), "namespace", obj.GetNamespace(), "kind", gvk.Kind)
+
+	dashboards := []*corev1.ConfigMap{}
+	invalid := map[string]bool{}
+	annotations := obj.GetAnnotations()
+
+	for _, k := range sortedKeys(annotations) {
+		templateName, instance, ok := a.templateAnnotation(k)
+		if !ok {
+			continue
+		}
+
+		tmpl, ok := a.dashboards[templateName]
+		if !ok {
+			continue
+		}
+
+		templateParams, err := parseAnnotationValue(annotations[k])
+		if err != nil {
+			continue
+		}
+		if err := a.metadata[templateName].apply(gvk.Kind, templateParams); err != nil {
+			continue
+		}
+
+		name := dashboardName(obj, templateName, instance)
+		uid := dashboardUID(gvk.Kind, obj, templateName, instance)
+
+		var result bytes.Buffer
+		if err := tmpl.Execute(&result, templateParameters(templateParams, instance, a.dashboardURL(gvk.Kind, obj, templateName, instance))); err != nil {
+			warnMessage := fmt.Sprintf("[%s][%s] error executing dashboard template \"%s\": %s", strings.ToLower(gvk.Kind), identifier, templateName, err)
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			invalid[name] = true
+			continue
+		}
+
+		content, err := dashboardJSON(result.Bytes(), uid, fmt.Sprintf("%s %s", identifier, templateName))
+		if err != nil {
+			warnMessage := fmt.Sprintf("[%s][%s] invalid dashboard from template \"%s\": %s", strings.ToLower(gvk.Kind), identifier, templateName, err)
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			invalid[name] = true
+			continue
+		}
+
+		labels := map[string]string{}
+		dashboardLabels := a.dashboardLabels
+		if dashboardLabels == nil {
+			dashboardLabels = DefaultDashboardLabels
+		}
+		for key, value := range dashboardLabels {
+			labels[key] = value
+		}
+		labels[DashboardManagedByLabel] = dashboardManagedBy
+
+		dashboard := &corev1.ConfigMap{
+			TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       obj.GetNamespace(),
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(obj, gvk)},
+			},
+			Data: map[string]string{name + ".json": content},
+		}
+		a.MarkGenerated(dashboard)
+		dashboards = append(dashboards, dashboard)
+	}
+
+	return dashboards, invalid
+}
+
+// dashboardJSON
+// - Checks a rendered dashboard is a JSON object and sets its uid, the title defaults to the object and template
+// - Any id is removed, Grafana assigns its own
+func dashboardJSON(rendered []byte, uid, title string) (string, error) {
+	dashboard := map[string]interface{}{}
+	if err := json.Unmarshal(rendered, &dashboard); err != nil {
+		return "", err
+	}
+
+	dashboard["uid"] = uid
+	delete(dashboard, "id")
+	if t, ok := dashboard["title"].(string); !ok || t == "" {
+		dashboard["title"] = title
+	}
+
+	out, err := json.MarshalIndent(dashboard, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
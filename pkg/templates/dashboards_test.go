@@ -0,0 +1,147 @@
+package templates
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIngressDashboards(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(testPod, testReplicaset, testDeployment, testService)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, !template.Dashboards())
+	assert.Assert(t, is.Nil(template.LoadDashboardTemplates("../../kube/config/dashboards")))
+	assert.Assert(t, template.Dashboards())
+	template.SetGrafanaURL("https://grafana.example.com/")
+
+	ingress := testIngressDefaultBackend.DeepCopy()
+	ingress.UID = "ingress-uid"
+
+	dashboards, invalid := template.CreateDashboardsFromIngress(ingress)
+	assert.Assert(t, is.Len(invalid, 0))
+	assert.Assert(t, is.Len(dashboards, 1))
+
+	configMap := dashboards[0]
+	assert.Equal(t, configMap.Name, "testDefaultBackend-5xx-rate-dashboard")
+	assert.Equal(t, configMap.Namespace, "testNamespace")
+	assert.DeepEqual(t, configMap.Labels, map[string]string{
+		"grafana_dashboard":            "1",
+		"app.kubernetes.io/managed-by": "heimdall",
+	})
+	assert.Equal(t, configMap.OwnerReferences[0].UID, ingress.UID)
+	assert.Equal(t, configMap.OwnerReferences[0].Kind, "Ingress")
+
+	dashboard := map[string]interface{}{}
+	assert.Assert(t, is.Nil(json.Unmarshal([]byte(configMap.Data["testDefaultBackend-5xx-rate-dashboard.json"]), &dashboard)))
+	uid := dashboardUID("Ingress", ingress, "5xx-rate", "")
+	assert.Equal(t, dashboard["uid"], uid)
+	assert.Equal(t, dashboard["title"], "testNamespace.testDefaultBackend 5xx rate")
+
+	// The panel uses the series the alert fires on, and the alert links to the dashboard
+	panel := dashboard["panels"].([]interface{})[0].(map[string]interface{})
+	target := panel["targets"].([]interface{})[0].(map[string]interface{})
+
+	promrules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	alert := promrules[0].Spec.Groups[0].Rules[1]
+	assert.Assert(t, is.Contains(alert.Expr.StrVal, target["expr"].(string)+" > 0.001"))
+	assert.Equal(t, alert.Annotations["dashboard"], "https://grafana.example.com/d/"+uid)
+}
+
+func TestDeploymentDashboards(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Nil(template.LoadDashboardTemplates("../../kube/config/dashboards")))
+	template.SetDashboardLabels(map[string]string{"dashboards": "heimdall"})
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Annotations["com.uswitch.heimdall/replicas-availability-deployment.page"] = "0.5"
+
+	dashboards, invalid := template.CreateDashboardsFromDeployment(deployment, "testNamespace")
+	assert.Assert(t, is.Len(invalid, 0))
+	assert.Assert(t, is.Len(dashboards, 2))
+	assert.Equal(t, dashboards[0].Name, "testApp-replicas-availability-deployment-dashboard")
+	assert.Equal(t, dashboards[1].Name, "testApp-replicas-availability-deployment-page-dashboard")
+	assert.Equal(t, dashboards[1].Labels["dashboards"], "heimdall")
+	assert.Assert(t, dashboardUID("Deployment", deployment, "replicas-availability-deployment", "") != dashboardUID("Deployment", deployment, "replicas-availability-deployment", "page"))
+
+	// Without a Grafana URL the link is relative to Grafana's root
+	promrules, err := template.CreateFromDeployment(deployment, "testNamespace")
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Annotations["dashboard"], "/d/"+dashboardUID("Deployment", deployment, "replicas-availability-deployment", ""))
+}
+
+func TestInvalidDashboard(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+
+	directory := t.TempDir()
+	assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, "replicas-availability-deployment.tmpl"), []byte(`{"title": {{.Threshold}}`), 0644)))
+	assert.Assert(t, is.Nil(template.LoadDashboardTemplates(directory)))
+
+	dashboards, invalid := template.CreateDashboardsFromDeployment(testDeployment, "testNamespace")
+	assert.Assert(t, is.Len(dashboards, 0))
+	assert.DeepEqual(t, invalid, map[string]bool{"testApp-replicas-availability-deployment-dashboard": true})
+
+	// Dashboard templates have to be named after a PrometheusRule template
+	assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, "unknown.tmpl"), []byte(`{}`), 0644)))
+	assert.ErrorContains(t, template.LoadDashboardTemplates(directory), "no PrometheusRule template \"unknown\"")
+}
+
+func TestDashboardInheritedMetadata(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "testNamespace",
+		Annotations: map[string]string{ownerAnnotation: "testNamespaceOwner"},
+	}}
+	deployment := testDeployment.DeepCopy()
+	delete(deployment.Annotations, ownerAnnotation)
+	deployment.Annotations[criticalityAnnotation] = "high"
+
+	client := fake.NewSimpleClientset(testService, deployment, testReplicaset, testPod, namespace)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	directory := t.TempDir()
+	for name, content := range map[string]string{
+		"5xx-rate.tmpl":                         `{"title": "{{.Owner}} {{.Meta.criticality}} {{(index .Backends 0).Deployment}}"}`,
+		"replicas-availability-deployment.tmpl": `{"title": "{{.Owner}} {{.Meta.criticality}}"}`,
+	} {
+		assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, name), []byte(content), 0644)))
+	}
+	assert.Assert(t, is.Nil(template.LoadDashboardTemplates(directory)))
+
+	title := func(dashboards []*corev1.ConfigMap) string {
+		assert.Assert(t, is.Len(dashboards, 1))
+		for _, content := range dashboards[0].Data {
+			dashboard := map[string]interface{}{}
+			assert.Assert(t, is.Nil(json.Unmarshal([]byte(content), &dashboard)))
+			return dashboard["title"].(string)
+		}
+		return ""
+	}
+
+	// Dashboards get the owner the alerts linking to them do, from the Ingress's backend and the Namespace
+	dashboards, _ := template.CreateDashboardsFromIngress(testIngressRuleBackend)
+	assert.Equal(t, title(dashboards), "testNamespaceOwner high testApp")
+
+	dashboards, _ = template.CreateDashboardsFromDeployment(deployment, "testNamespace")
+	assert.Equal(t, title(dashboards), "testNamespaceOwner high")
+}
@@ -11,7 +11,6 @@ import (
 	apps "k8s.io/api/apps/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 // templateParameterDeployment
@@ -30,51 +29,69 @@ type templateParameterDeployment struct {
 	Environment         string
 	Criticality         string
 	Sensitivity         string
-	Deployment          *apps.Deployment
+	// Meta holds every metadata field, including the owner, environment, criticality and sensitivity
+	Meta map[string]string
+	// Prometheus is the Prometheus the rules are for, nil when none is known
+	Prometheus *PrometheusParameters
+	// ThanosRuler is the ThanosRuler rules evaluated against global data are for, nil when none is known
+	ThanosRuler *PrometheusParameters
+	Deployment  *apps.Deployment
+	Params      map[string]interface{}
+	Instance    string
+	Dashboard   string
 }
 
-// CreateFromDeployment
-// - Creates all the promRules for a given Deployment
-func (a *PrometheusRuleTemplateManager) CreateFromDeployment(deployment *apps.Deployment, depNamespacePrometheus string) ([]*monitoringv1.PrometheusRule, error) {
-	logger := log.Sugar.With("name", deployment.Name, "namespace", deployment.Namespace, "kind", deployment.Kind)
-	deploymentIdentifier := fmt.Sprintf("%s.%s", deployment.Namespace, deployment.Name)
-
-	owner := deployment.GetAnnotations()[ownerAnnotation]
-	criticality := deployment.GetAnnotations()[criticalityAnnotation]
-	environment := deployment.GetAnnotations()[environmentAnnotation]
-	sensitivity := deployment.GetAnnotations()[sensitivityAnnotation]
-	selectorMap := deployment.Spec.Selector.MatchLabels
+// deploymentParameters
+// - The parameters templates rendered for the Deployment get, with the owner metadata it inherits from its Namespace and the cluster default
+func (a *PrometheusRuleTemplateManager) deploymentParameters(deployment *apps.Deployment, depNamespacePrometheus string) (*templateParameterDeployment, ownerMetadata) {
+	metadata := ownerMetadata{}
+	metadata.inherit(SourceDeployment, a.objectMetadata(deployment))
+	a.inheritMetadata(metadata, deployment.Namespace)
 
 	var builder strings.Builder
-	for key, value := range selectorMap {
+	for key, value := range deployment.Spec.Selector.MatchLabels {
 		fmt.Fprintf(&builder, ",%s=\"%s\"", key, value)
 	}
-	generatedLabels := builder.String()
-
-	logger.Debugw("generated labels", "labels", generatedLabels)
 
-	params := &templateParameterDeployment{
+	return &templateParameterDeployment{
 		Deployment:      deployment,
-		Identifier:      deploymentIdentifier,
+		Identifier:      fmt.Sprintf("%s.%s", deployment.Namespace, deployment.Name),
 		Namespace:       deployment.Namespace,
 		Name:            deployment.Name,
-		GeneratedLabels: generatedLabels,
-		Owner:           owner,
-		Criticality:     criticality,
-		Environment:     environment,
-		Sensitivity:     sensitivity,
+		GeneratedLabels: builder.String(),
+		Owner:           metadata["owner"].Value,
+		Criticality:     metadata["criticality"].Value,
+		Environment:     metadata["environment"].Value,
+		Sensitivity:     metadata["sensitivity"].Value,
+		Meta:            metadata.values(),
+		Prometheus:      a.prometheusParameters(deployment.Namespace),
+		ThanosRuler:     a.thanosRulerParameters(deployment.Namespace),
 		NSPrometheus:    depNamespacePrometheus,
-	}
+	}, metadata
+}
+
+// CreateFromDeployment
+// - Creates all the promRules for a given Deployment
+func (a *PrometheusRuleTemplateManager) CreateFromDeployment(deployment *apps.Deployment, depNamespacePrometheus string) ([]*monitoringv1.PrometheusRule, error) {
+	logger := log.Sugar.With("name", deployment.Name, "namespace", deployment.Namespace, "kind", deployment.Kind)
+	deploymentIdentifier := fmt.Sprintf("%s.%s", deployment.Namespace, deployment.Name)
+
+	params, metadata := a.deploymentParameters(deployment, depNamespacePrometheus)
+	logger.Debugw("generated labels", "labels", params.GeneratedLabels)
 
 	prometheusRules := map[string]*monitoringv1.PrometheusRule{}
+	// ruleTemplates are the templates which rendered prometheusRules, by key
+	ruleTemplates := map[string]string{}
+	invalidRules := []InvalidRule{}
 	annotations := params.Deployment.GetAnnotations()
 
-	for k, v := range annotations {
-		if !strings.HasPrefix(k, heimPrefix) {
+	for _, k := range sortedKeys(annotations) {
+		v := annotations[k]
+		templateName, instance, ok := a.templateAnnotation(k)
+		if !ok {
 			continue
 		}
 
-		templateName := strings.TrimLeft(k, fmt.Sprintf("%s/", heimPrefix))
 		logger.Infow("template selected", "template", templateName)
 		template, ok := a.templates[templateName]
 		if !ok {
@@ -84,34 +101,109 @@ func (a *PrometheusRuleTemplateManager) CreateFromDeployment(deployment *apps.De
 			continue
 		}
 
-		params.Threshold = v
+		templateParams, err := parseAnnotationValue(v)
+		if err != nil {
+			warnMessage := fmt.Sprintf("[deployment][%s] error parsing annotation \"%s\": %s", deploymentIdentifier, k, err)
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			continue
+		}
+
+		// Annotations for other kinds never rendered rules for the object, there are none to keep
+		if !a.metadata[templateName].forKind("Deployment") {
+			warnMessage := fmt.Sprintf("[deployment][%s] annotation \"%s\" can't be used with template \"%s\": template is for %s", deploymentIdentifier, k, templateName, strings.Join(a.metadata[templateName].Kinds, ", "))
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			continue
+		}
+
+		params.Params = templateParams
+		params.Instance = instance
+		params.Dashboard = a.dashboardURL("Deployment", deployment, templateName, instance)
+		err = a.metadata[templateName].apply("Deployment", templateParams)
+		params.Threshold = thresholdParameter(templateParams)
+		if err != nil {
+			warnMessage := fmt.Sprintf("[deployment][%s] annotation \"%s\" can't be used with template \"%s\": %s", deploymentIdentifier, k, templateName, err)
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			// The rules the instance rendered before are kept, they're found by rendering it with the parameters rejected
+			invalidRules = append(invalidRules, rejectedInstanceRules(templateName, template, params, err)...)
+			continue
+		}
+
 		var result bytes.Buffer
 		if err := template.Execute(&result, params); err != nil {
 			warnMessage := fmt.Sprintf("[deployment][%s] error executing template : %s", deploymentIdentifier, err)
 			logger.Warnf(warnMessage)
 			sentryclient.SentryMessage(warnMessage)
+			invalidRules = append(invalidRules, InvalidRule{Template: templateName, Err: fmt.Errorf("error executing template: %v", err)})
 			continue
 		}
 
-		promrule := &monitoringv1.PrometheusRule{}
-
-		if err := yaml.NewYAMLOrJSONDecoder(&result, 1024).Decode(promrule); err != nil {
+		rendered, err := decodePrometheusRules(&result)
+		if err != nil {
 			warnMessage := fmt.Sprintf("[deployment][%s] error parsing YAML: %s", deploymentIdentifier, err)
 			logger.Warnf(warnMessage)
 			sentryclient.SentryMessage(warnMessage)
+			invalidRules = append(invalidRules, InvalidRule{Template: templateName, Err: fmt.Errorf("error parsing YAML: %v", err)})
 			continue
 		}
 
-		promrule.SetOwnerReferences([]metav1.OwnerReference{
-			*metav1.NewControllerRef(deployment, schema.GroupVersionKind{
-				Group:   apps.SchemeGroupVersion.Group,
-				Version: apps.SchemeGroupVersion.Version,
-				Kind:    "Deployment",
-			}),
-		})
+		duplicates := duplicatePrometheusRules(rendered)
+		for _, promrule := range rendered {
+			key := prometheusRuleKey(promrule)
+			recordMetadataSources(promrule, metadata)
+
+			if reported, ok := duplicates[key]; ok {
+				if reported {
+					continue
+				}
+				invalidRule := InvalidRule{Template: templateName, PrometheusRule: promrule, Err: fmt.Errorf("PrometheusRule %s is rendered more than once", key)}
+				warnMessage := fmt.Sprintf("[deployment][%s] invalid PrometheusRule from template \"%s\": %s", deploymentIdentifier, templateName, invalidRule.Err)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				invalidRules = append(invalidRules, invalidRule)
+				duplicates[key] = true
+				continue
+			}
+
+			if errs := validatePrometheusRule(promrule); len(errs) != 0 {
+				invalidRule := InvalidRule{Template: templateName, PrometheusRule: promrule, Err: joinRuleErrors(errs)}
+				warnMessage := fmt.Sprintf("[deployment][%s] invalid PrometheusRule from template \"%s\": %s", deploymentIdentifier, templateName, invalidRule.Err)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				invalidRules = append(invalidRules, invalidRule)
+				continue
+			}
+
+			promrule.SetOwnerReferences([]metav1.OwnerReference{
+				*metav1.NewControllerRef(deployment, schema.GroupVersionKind{
+					Group:   apps.SchemeGroupVersion.Group,
+					Version: apps.SchemeGroupVersion.Version,
+					Kind:    "Deployment",
+				}),
+			})
+
+			if _, ok := prometheusRules[key]; ok {
+				warnMessage := fmt.Sprintf("[deployment][%s] annotation \"%s\" renders PrometheusRule \"%s\" which an earlier annotation already rendered, templates with instances should use .Instance in their names", deploymentIdentifier, k, key)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				continue
+			}
+
+			prometheusRules[key] = promrule
+			ruleTemplates[key] = templateName
+		}
+	}
 
-		prometheusRules[promrule.ObjectMeta.Name] = promrule
+	collected, conflicting := dedupeRecordingRules(collectPrometheusRules(prometheusRules), ruleTemplates)
+	invalidRules = append(invalidRules, conflicting...)
+	if a.consolidate {
+		collected, invalidRules = consolidatePrometheusRules(deployment, "Deployment", collected, invalidRules)
+	}
+	for _, promrule := range collected {
+		a.MarkGenerated(promrule)
 	}
 
-	return collectPrometheusRules(prometheusRules), nil
+	return collected, invalidRulesError(invalidRules)
 }
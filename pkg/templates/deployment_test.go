@@ -52,3 +52,70 @@ kube_deployment_spec_replicas{namespace="testNamespace", deployment="testApp"} <
 	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Expr.StrVal, expr)
 	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Labels["owner"], "testDeploymentOwner")
 }
+
+func TestDeploymentTemplateForOtherKind(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset()
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Annotations["com.uswitch.heimdall/5xx-rate"] = "0.01"
+
+	promrules, err := template.CreateFromDeployment(deployment, "testNamespace")
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(promrules, 1))
+	assert.Equal(t, promrules[0].Name, "testNamespace-testApp-replicas-availability-deployment")
+}
+
+func TestDeploymentDuplicateDocuments(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset()
+
+	template, err := NewPrometheusRuleTemplateManager("testdata/duplicate-documents", client)
+	assert.Assert(t, is.Nil(err))
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Annotations = map[string]string{"com.uswitch.heimdall/duplicate": "1"}
+
+	promrules, err := template.CreateFromDeployment(deployment, "testNamespace")
+	assert.Assert(t, is.Len(promrules, 1))
+	assert.Equal(t, promrules[0].Name, "testApp-unique")
+
+	invalidRulesErr, ok := err.(*InvalidRulesError)
+	assert.Assert(t, ok)
+	assert.Assert(t, is.Len(invalidRulesErr.Rules, 1))
+	assert.ErrorContains(t, invalidRulesErr.Rules[0].Err, "testNamespace/testApp-duplicate is rendered more than once")
+}
+
+func TestDeploymentTemplateErrors(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset()
+
+	template, err := NewPrometheusRuleTemplateManager("testdata/render-errors", client)
+	assert.Assert(t, is.Nil(err))
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Annotations = map[string]string{
+		"com.uswitch.heimdall/execute-error": "1",
+		"com.uswitch.heimdall/yaml-error":    "1",
+	}
+
+	// Neither template renders its rules, so which existing rules are theirs isn't known and all of them are kept
+	promrules, err := template.CreateFromDeployment(deployment, "testNamespace")
+	assert.Assert(t, is.Len(promrules, 0))
+
+	invalidRulesErr, ok := err.(*InvalidRulesError)
+	assert.Assert(t, ok)
+	assert.Assert(t, is.Len(invalidRulesErr.Rules, 2))
+	assert.Equal(t, invalidRulesErr.Rules[0].Template, "execute-error")
+	assert.Assert(t, is.Nil(invalidRulesErr.Rules[0].PrometheusRule))
+	assert.ErrorContains(t, invalidRulesErr.Rules[0].Err, "the job parameter is required")
+	assert.Equal(t, invalidRulesErr.Rules[1].Template, "yaml-error")
+	assert.Assert(t, is.Nil(invalidRulesErr.Rules[1].PrometheusRule))
+	assert.ErrorContains(t, invalidRulesErr.Rules[1].Err, "error parsing YAML")
+}
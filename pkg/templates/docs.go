@@ -0,0 +1,100 @@
+package templates
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Docs
+// - Writes Markdown documentation for every template in directory, generated from the templates' metadata
+func Docs(directory string, w io.Writer) error {
+	templateFiles, err := filepath.Glob(directory + "/*.tmpl")
+	if err != nil {
+		return err
+	}
+
+	if len(templateFiles) == 0 {
+		return fmt.Errorf("no templates defined")
+	}
+
+	fmt.Fprintf(w, "# Templates\n\n")
+	fmt.Fprintf(w, "<!-- Generated by `heimdall docs` from the templates' metadata, do not edit -->\n")
+
+	for _, file := range templateFiles {
+		metadata, _, err := parseTemplateMetadata(file)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		name := templateName(file)
+		fmt.Fprintf(w, "\n## %s\n\n", name)
+		fmt.Fprintf(w, "Annotation: `%s/%s`\n\n", DefaultAnnotationPrefix, name)
+
+		if metadata == nil {
+			fmt.Fprintf(w, "This template has no metadata, it can be used on any kind of object.\n")
+			continue
+		}
+
+		if metadata.Description != "" {
+			fmt.Fprintf(w, "%s\n\n", metadata.Description)
+		}
+
+		kinds := supportedKinds
+		if len(metadata.Kinds) != 0 {
+			kinds = metadata.Kinds
+		}
+		fmt.Fprintf(w, "Kinds: %s\n", strings.Join(kinds, ", "))
+
+		if len(metadata.Parameters) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "\n| Parameter | Type | Required | Default | Description |\n")
+		fmt.Fprintf(w, "|---|---|---|---|---|\n")
+		for _, name := range metadata.parameterNames() {
+			parameter := metadata.Parameters[name]
+
+			required := "no"
+			if parameter.Required {
+				required = "yes"
+			}
+
+			defaultValue := ""
+			if parameter.Default != nil {
+				defaultValue = fmt.Sprintf("`%v`", parameter.Default)
+			}
+
+			fmt.Fprintf(w, "| `%s` | %s | %s | %s | %s |\n", name, parameter.typeDescription(), required, defaultValue, parameter.Description)
+		}
+	}
+
+	return nil
+}
+
+// typeDescription
+// - The parameter's type with its range, such as "number, 0 to 1" or "number, above 0 and below 100"
+func (p Parameter) typeDescription() string {
+	bounds := []string{}
+	switch {
+	case p.Min != nil && p.Max != nil && !p.ExclusiveMin && !p.ExclusiveMax:
+		return fmt.Sprintf("%s, %v to %v", p.Type, *p.Min, *p.Max)
+	case p.Min != nil && p.ExclusiveMin:
+		bounds = append(bounds, fmt.Sprintf("above %v", *p.Min))
+	case p.Min != nil:
+		bounds = append(bounds, fmt.Sprintf("at least %v", *p.Min))
+	}
+	switch {
+	case p.Max != nil && p.ExclusiveMax:
+		bounds = append(bounds, fmt.Sprintf("below %v", *p.Max))
+	case p.Max != nil:
+		bounds = append(bounds, fmt.Sprintf("at most %v", *p.Max))
+	}
+
+	if len(bounds) == 0 {
+		return p.Type
+	}
+
+	return fmt.Sprintf("%s, %s", p.Type, strings.Join(bounds, " and "))
+}
@@ -0,0 +1,27 @@
+package templates_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/uswitch/heimdall/pkg/templates"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+// TestDocs
+// - docs/templates.md is generated from the shipped templates' metadata, run with -update or make docs after changing it
+func TestDocs(t *testing.T) {
+	var actual bytes.Buffer
+	assert.Assert(t, is.Nil(templates.Docs("../../kube/config/templates", &actual)))
+
+	docs := "../../docs/templates.md"
+	if *update {
+		assert.Assert(t, is.Nil(os.WriteFile(docs, actual.Bytes(), 0644)))
+	}
+
+	expected, err := os.ReadFile(docs)
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, actual.String(), string(expected))
+}
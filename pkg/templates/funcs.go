@@ -0,0 +1,244 @@
+package templates
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"sigs.k8s.io/yaml"
+)
+
+// funcMap
+// - Functions available to every template, on top of the text/template builtins
+var funcMap = template.FuncMap{
+	// lower "Web" => "web"
+	"lower": func(s interface{}) string { return strings.ToLower(toString(s)) },
+	// upper "web" => "WEB"
+	"upper": func(s interface{}) string { return strings.ToUpper(toString(s)) },
+	// title "payments api" => "Payments Api"
+	"title": title,
+	// trim " web " => "web"
+	"trim": func(s interface{}) string { return strings.TrimSpace(toString(s)) },
+	// trimPrefix "web-" "web-api" => "api"
+	"trimPrefix": func(prefix string, s interface{}) string { return strings.TrimPrefix(toString(s), prefix) },
+	// trimSuffix "-api" "web-api" => "web"
+	"trimSuffix": func(suffix string, s interface{}) string { return strings.TrimSuffix(toString(s), suffix) },
+	// replace "." "-" "shop.web" => "shop-web"
+	"replace": func(old, new string, s interface{}) string { return strings.ReplaceAll(toString(s), old, new) },
+	// contains "api" "web-api" => true
+	"contains": func(substr string, s interface{}) bool { return strings.Contains(toString(s), substr) },
+	// hasPrefix "web" "web-api" => true
+	"hasPrefix": func(prefix string, s interface{}) bool { return strings.HasPrefix(toString(s), prefix) },
+	// hasSuffix "api" "web-api" => true
+	"hasSuffix": func(suffix string, s interface{}) bool { return strings.HasSuffix(toString(s), suffix) },
+	// split "," "a,b" => [a b]
+	"split": func(sep string, s interface{}) []string { return strings.Split(toString(s), sep) },
+	// join "," (list "a" "b") => "a,b"
+	"join": join,
+	// list "a" "b" => [a b]
+	"list": func(items ...interface{}) []interface{} { return items },
+	// quote "web" => "\"web\""
+	"quote": func(s interface{}) string { return strconv.Quote(toString(s)) },
+
+	// default "1m" .Params.for => .Params.for, or "1m" when it is empty
+	"default": defaultValue,
+	// required "threshold must be set" .Threshold => .Threshold, rendering fails with the message when it is empty
+	"required": required,
+
+	// promqlEscape `a"b` => `a\"b`, for use inside a double quoted label matcher
+	"promqlEscape": promqlEscape,
+	// promqlQuote `a"b` => `"a\"b"`
+	"promqlQuote": func(s interface{}) string { return `"` + promqlEscape(s) + `"` },
+	// promqlRegexEscape "a.b" => `a\\.b`, for use inside a double quoted =~ matcher
+	"promqlRegexEscape": func(s interface{}) string { return promqlEscape(regexp.QuoteMeta(toString(s))) },
+	// matchers .Deployment.Spec.Selector.MatchLabels => `app="web",tier="api"`, sorted by label name
+	"matchers": matchers,
+
+	// percentToRatio "99.9" => 0.999
+	"percentToRatio": func(v interface{}) (float64, error) { f, err := toFloat(v); return roundFloat(f / 100), err },
+	// ratioToPercent 0.999 => 99.9
+	"ratioToPercent": func(v interface{}) (float64, error) { f, err := toFloat(v); return roundFloat(f * 100), err },
+
+	// errorBudget "99.9" => 0.001
+	"errorBudget": errorBudget,
+	// burnRateAlerts "99.9" "30d" => the page and ticket BurnRateAlerts for the objective, each with its long and short windows
+	"burnRateAlerts": burnRateAlerts,
+	// burnRateWindows "30d" => [5m 30m 1h 2h 6h 1d 3d], the windows burnRateAlerts needs error ratios for
+	"burnRateWindows": burnRateWindows,
+
+	// parseDuration "1h30m" => 1h30m0s as a time.Duration, Prometheus units such as "1d" are accepted
+	"parseDuration": parseDuration,
+	// formatDuration 5400 => "1h30m", from a time.Duration, a duration string or a number of seconds
+	"formatDuration": formatDuration,
+
+	// toYaml .Deployment.Spec.Selector.MatchLabels => the value as YAML, combine with nindent to place it in the output
+	"toYaml": toYaml,
+	// indent 4 "a: b" => "    a: b", every line is indented
+	"indent": indent,
+	// nindent 4 "a: b" => "\n    a: b", like indent but starts on a new line
+	"nindent": func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case fmt.Stringer:
+		return s.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch f := v.(type) {
+	case float64:
+		return f, nil
+	case float32:
+		return float64(f), nil
+	case int:
+		return float64(f), nil
+	case int64:
+		return float64(f), nil
+	default:
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(toString(v)), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", toString(v))
+		}
+		return parsed, nil
+	}
+}
+
+// roundFloat
+// - Drops the floating point noise of scaling, so 99.9 / 100 renders as 0.999
+func roundFloat(f float64) float64 {
+	rounded, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'g', 12, 64), 64)
+	return rounded
+}
+
+func title(s interface{}) string {
+	words := strings.Fields(toString(s))
+	for i, word := range words {
+		runes := []rune(word)
+		words[i] = strings.ToUpper(string(runes[0])) + string(runes[1:])
+	}
+
+	return strings.Join(words, " ")
+}
+
+func join(sep string, items interface{}) string {
+	value := reflect.ValueOf(items)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return toString(items)
+	}
+
+	parts := make([]string, value.Len())
+	for i := range parts {
+		parts[i] = toString(value.Index(i).Interface())
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// isEmpty
+// - Reports whether v is nil or the zero value of its type, empty strings, maps and slices are empty
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	value := reflect.ValueOf(v)
+	switch value.Kind() {
+	case reflect.String, reflect.Map, reflect.Slice, reflect.Array:
+		return value.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	default:
+		return value.IsZero()
+	}
+}
+
+func defaultValue(def interface{}, v ...interface{}) interface{} {
+	if len(v) == 0 || isEmpty(v[0]) {
+		return def
+	}
+
+	return v[0]
+}
+
+func required(message string, v interface{}) (interface{}, error) {
+	if isEmpty(v) {
+		return nil, fmt.Errorf("%s", message)
+	}
+
+	return v, nil
+}
+
+func promqlEscape(s interface{}) string {
+	escaped := strconv.Quote(toString(s))
+	return escaped[1 : len(escaped)-1]
+}
+
+func matchers(labels interface{}) (string, error) {
+	value := reflect.ValueOf(labels)
+	if labels == nil || value.Kind() != reflect.Map {
+		return "", fmt.Errorf("matchers expects a map of labels, got %T", labels)
+	}
+
+	parts := make([]string, 0, value.Len())
+	for _, key := range value.MapKeys() {
+		name := toString(key.Interface())
+		if !model.LabelName(name).IsValid() {
+			return "", fmt.Errorf("invalid label name %q", name)
+		}
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, name, promqlEscape(value.MapIndex(key).Interface())))
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, ","), nil
+}
+
+func parseDuration(s interface{}) (time.Duration, error) {
+	d, err := model.ParseDuration(toString(s))
+	return time.Duration(d), err
+}
+
+func formatDuration(v interface{}) (string, error) {
+	switch d := v.(type) {
+	case time.Duration:
+		return model.Duration(d).String(), nil
+	case model.Duration:
+		return d.String(), nil
+	case string:
+		parsed, err := model.ParseDuration(d)
+		return parsed.String(), err
+	default:
+		seconds, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		return model.Duration(time.Duration(seconds * float64(time.Second))).String(), nil
+	}
+}
+
+func toYaml(v interface{}) (string, error) {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func indent(spaces int, s string) string {
+	padding := strings.Repeat(" ", spaces)
+	return padding + strings.ReplaceAll(s, "\n", "\n"+padding)
+}
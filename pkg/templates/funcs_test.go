@@ -0,0 +1,86 @@
+package templates
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func executeFuncs(text string, data interface{}) (string, error) {
+	tmpl, err := template.New("test").Funcs(funcMap).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var result bytes.Buffer
+	err = tmpl.Execute(&result, data)
+	return result.String(), err
+}
+
+func TestFuncs(t *testing.T) {
+	data := map[string]interface{}{
+		"Name":      "Web-API",
+		"Empty":     "",
+		"Threshold": "99.9",
+		"Ratio":     0.01,
+		"Labels":    map[string]string{"tier": "api", "app": `we"b`},
+		"Params":    map[string]interface{}{"for": "5m"},
+	}
+
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{`{{ lower .Name }}`, "web-api"},
+		{`{{ .Name | upper }}`, "WEB-API"},
+		{`{{ title "payments api" }}`, "Payments Api"},
+		{`{{ trim "  web " }}`, "web"},
+		{`{{ .Name | trimPrefix "Web-" }}`, "API"},
+		{`{{ .Name | trimSuffix "-API" }}`, "Web"},
+		{`{{ replace "." "-" "shop.web" }}`, "shop-web"},
+		{`{{ contains "API" .Name }} {{ hasPrefix "Web" .Name }} {{ hasSuffix "Web" .Name }}`, "true true false"},
+		{`{{ split "," "a,b" | join "|" }}`, "a|b"},
+		{`{{ list "a" 1 | join "," }}`, "a,1"},
+		{`{{ quote .Name }}`, `"Web-API"`},
+		{`{{ .Empty | default "1m" }} {{ .Params.for | default "1m" }} {{ .Params.missing | default "1m" }}`, "1m 5m 1m"},
+		{`{{ required "threshold is required" .Threshold }}`, "99.9"},
+		{`{{ promqlEscape "a\"b\\c" }}`, `a\"b\\c`},
+		{`{{ promqlQuote "a\"b" }}`, `"a\"b"`},
+		{`{{ promqlRegexEscape "a.b" }}`, `a\\.b`},
+		{`{{ matchers .Labels }}`, `app="we\"b",tier="api"`},
+		{`{{ percentToRatio .Threshold }}`, "0.999"},
+		{`{{ ratioToPercent .Ratio }}`, "1"},
+		{`{{ parseDuration "1h30m" }}`, "1h30m0s"},
+		{`{{ formatDuration 5400 }} {{ formatDuration "90m" }} {{ parseDuration "2d" | formatDuration }}`, "1h30m 1h30m 2d"},
+		{`{{ toYaml .Labels }}`, "app: we\"b\ntier: api"},
+		{`labels:{{ toYaml .Labels | nindent 2 }}`, "labels:\n  app: we\"b\n  tier: api"},
+		{`{{ indent 2 "a\nb" }}`, "  a\n  b"},
+	}
+
+	for _, test := range tests {
+		result, err := executeFuncs(test.text, data)
+		assert.Assert(t, is.Nil(err), test.text)
+		assert.Equal(t, result, test.expected, test.text)
+	}
+}
+
+func TestFuncErrors(t *testing.T) {
+	tests := []struct {
+		text    string
+		message string
+	}{
+		{`{{ required "threshold is required" "" }}`, "threshold is required"},
+		{`{{ percentToRatio "abc" }}`, `"abc" is not a number`},
+		{`{{ parseDuration "5 minutes" }}`, "not a valid duration string"},
+		{`{{ matchers "app" }}`, "matchers expects a map of labels"},
+	}
+
+	for _, test := range tests {
+		_, err := executeFuncs(test.text, nil)
+		assert.Assert(t, err != nil, test.text)
+		assert.Assert(t, is.Contains(err.Error(), test.message), test.text)
+	}
+}
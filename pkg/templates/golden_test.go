@@ -0,0 +1,68 @@
+package templates_test
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/render"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	"sigs.k8s.io/yaml"
+)
+
+var update = flag.Bool("update", false, "regenerate the expected.yaml golden files and docs/templates.md")
+
+// TestGolden
+// - Every directory in testdata/golden is a test case: input.yaml holds the objects, expected.yaml the PrometheusRules
+// - Cases use the shipped templates unless they have a templates directory of their own
+// - An optional options.yaml sets render.Options fields, such as consolidateRules: true
+// - Run with -update to regenerate expected.yaml after changing a template
+func TestGolden(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	cases, err := filepath.Glob("testdata/golden/*")
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, len(cases) > 0)
+
+	for _, dir := range cases {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			input, err := os.Open(filepath.Join(dir, "input.yaml"))
+			assert.Assert(t, is.Nil(err))
+			defer input.Close()
+
+			objects, err := render.Decode(input)
+			assert.Assert(t, is.Nil(err))
+
+			templates := filepath.Join(dir, "templates")
+			if _, err := os.Stat(templates); err != nil {
+				templates = "../../kube/config/templates"
+			}
+
+			opts := render.Options{}
+			if content, err := os.ReadFile(filepath.Join(dir, "options.yaml")); err == nil {
+				assert.Assert(t, is.Nil(yaml.UnmarshalStrict(content, &opts)))
+			}
+			opts.Templates, opts.DefaultNamespace = templates, "default"
+
+			promrules, err := render.PrometheusRules(objects, opts)
+			assert.Assert(t, is.Nil(err))
+
+			var actual bytes.Buffer
+			assert.Assert(t, is.Nil(render.Write(&actual, promrules)))
+
+			golden := filepath.Join(dir, "expected.yaml")
+			if *update {
+				assert.Assert(t, is.Nil(os.WriteFile(golden, actual.Bytes(), 0644)))
+			}
+
+			expected, err := os.ReadFile(golden)
+			assert.Assert(t, is.Nil(err))
+			assert.Equal(t, actual.String(), string(expected))
+		})
+	}
+}
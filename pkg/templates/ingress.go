@@ -2,7 +2,6 @@ package templates
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"strings"
 
@@ -13,22 +12,33 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/apimachinery/pkg/util/yaml"
 )
 
 type templateParameterIngress struct {
-	Ingress        *networkingv1.Ingress
-	Identifier     string
-	Threshold      string
-	Namespace      string
-	Name           string
-	Host           string
-	Value          string
-	Owner          string
-	Environment    string
-	Criticality    string
-	Sensitivity    string
+	Ingress     *networkingv1.Ingress
+	Identifier  string
+	Threshold   string
+	Namespace   string
+	Name        string
+	Host        string
+	Hosts       []string
+	Value       string
+	Owner       string
+	Environment string
+	Criticality string
+	Sensitivity string
+	// Meta holds every metadata field, including the owner, environment, criticality and sensitivity
+	Meta           map[string]string
 	BackendService string
+	Backends       []*IngressBackend
+	Params         map[string]interface{}
+	Instance       string
+	Dashboard      string
+	Probe          *ProbeParameters
+	// Prometheus is the Prometheus the rules are for, nil when none is known
+	Prometheus *PrometheusParameters
+	// ThanosRuler is the ThanosRuler rules evaluated against global data are for, nil when none is known
+	ThanosRuler *PrometheusParameters
 }
 
 // CreateFromIngress
@@ -37,26 +47,27 @@ func (a *PrometheusRuleTemplateManager) CreateFromIngress(ingress *networkingv1.
 	logger := log.Sugar.With("name", ingress.Name, "namespace", ingress.Namespace, "kind", ingress.Kind)
 	ingressIdentifier := fmt.Sprintf("%s.%s", ingress.Namespace, ingress.Name)
 
-	params := &templateParameterIngress{
-		Ingress:     ingress,
-		Identifier:  ingressIdentifier,
-		Namespace:   ingress.Namespace,
-		Name:        ingress.Name,
-		Owner:       ingress.GetAnnotations()[ownerAnnotation],
-		Environment: ingress.GetAnnotations()[environmentAnnotation],
-		Criticality: ingress.GetAnnotations()[criticalityAnnotation],
-		Sensitivity: ingress.GetAnnotations()[sensitivityAnnotation],
+	params, err := a.ingressParameters(ingress)
+	if err != nil {
+		warnMessage := fmt.Sprintf("[ingress][%s] no Probe: %s", ingressIdentifier, err)
+		logger.Warnf(warnMessage)
+		sentryclient.SentryMessage(warnMessage)
 	}
 
 	prometheusRules := map[string]*monitoringv1.PrometheusRule{}
+	// ruleTemplates are the templates which rendered prometheusRules, by key
+	ruleTemplates := map[string]string{}
+	invalidRules := []InvalidRule{}
 	annotations := ingress.GetAnnotations()
+	var metadata ownerMetadata
 
-	for k, v := range annotations {
-		if !strings.HasPrefix(k, heimPrefix) {
+	for _, k := range sortedKeys(annotations) {
+		v := annotations[k]
+		templateName, instance, ok := a.templateAnnotation(k)
+		if !ok {
 			continue
 		}
 
-		templateName := strings.TrimLeft(k, fmt.Sprintf("%s/", heimPrefix))
 		template, ok := a.templates[templateName]
 		if !ok {
 			warnMessage := fmt.Sprintf("[ingress][%s] no template for \"%s\"", ingressIdentifier, templateName)
@@ -65,108 +76,314 @@ func (a *PrometheusRuleTemplateManager) CreateFromIngress(ingress *networkingv1.
 			continue
 		}
 
-		params, err := a.resolveIngressOwner(params)
+		// The owner is the same for every annotation, it's only resolved for the first
+		if metadata == nil {
+			metadata, err = a.resolveIngressMetadata(params)
+			if err != nil {
+				warnMessage := fmt.Sprintf("[ingress][%s] error finding owner: %s", ingressIdentifier, err)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+			}
+		}
+
+		templateParams, err := parseAnnotationValue(v)
 		if err != nil {
-			warnMessage := fmt.Sprintf("[ingress][%s] error finding owner: %s", ingressIdentifier, err)
+			warnMessage := fmt.Sprintf("[ingress][%s] error parsing annotation \"%s\": %s", ingressIdentifier, k, err)
 			logger.Warnf(warnMessage)
 			sentryclient.SentryMessage(warnMessage)
+			continue
+		}
+
+		// Annotations for other kinds never rendered rules for the object, there are none to keep
+		if !a.metadata[templateName].forKind("Ingress") {
+			warnMessage := fmt.Sprintf("[ingress][%s] annotation \"%s\" can't be used with template \"%s\": template is for %s", ingressIdentifier, k, templateName, strings.Join(a.metadata[templateName].Kinds, ", "))
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			continue
+		}
+
+		params.Params = templateParams
+		params.Instance = instance
+		params.Dashboard = a.dashboardURL("Ingress", ingress, templateName, instance)
+		err = a.metadata[templateName].apply("Ingress", templateParams)
+		params.Threshold = thresholdParameter(templateParams)
+		if err != nil {
+			warnMessage := fmt.Sprintf("[ingress][%s] annotation \"%s\" can't be used with template \"%s\": %s", ingressIdentifier, k, templateName, err)
+			logger.Warnf(warnMessage)
+			sentryclient.SentryMessage(warnMessage)
+			// The rules the instance rendered before are kept, they're found by rendering it with the parameters rejected
+			invalidRules = append(invalidRules, rejectedInstanceRules(templateName, template, params, err)...)
+			continue
 		}
 
-		params.Threshold = v
 		var result bytes.Buffer
 		if err := template.Execute(&result, params); err != nil {
 			warnMessage := fmt.Sprintf("[ingress][%s] error executing template: %s", ingressIdentifier, err)
 			logger.Warnf(warnMessage)
 			sentryclient.SentryMessage(warnMessage)
+			invalidRules = append(invalidRules, InvalidRule{Template: templateName, Err: fmt.Errorf("error executing template: %v", err)})
 			continue
 		}
 
-		promrule := &monitoringv1.PrometheusRule{}
-
-		if err := yaml.NewYAMLOrJSONDecoder(&result, 1024).Decode(promrule); err != nil {
+		rendered, err := decodePrometheusRules(&result)
+		if err != nil {
 			warnMessage := fmt.Sprintf("[ingress][%s] error parsing YAML: %s", ingressIdentifier, err)
 			logger.Warnf(warnMessage)
 			sentryclient.SentryMessage(warnMessage)
+			invalidRules = append(invalidRules, InvalidRule{Template: templateName, Err: fmt.Errorf("error parsing YAML: %v", err)})
 			continue
 		}
 
-		promrule.SetOwnerReferences([]metav1.OwnerReference{
-			*metav1.NewControllerRef(ingress, schema.GroupVersionKind{
-				Group:   networkingv1.SchemeGroupVersion.Group,
-				Version: networkingv1.SchemeGroupVersion.Version,
-				Kind:    "Ingress",
-			}),
-		})
+		duplicates := duplicatePrometheusRules(rendered)
+		for _, promrule := range rendered {
+			key := prometheusRuleKey(promrule)
+			recordMetadataSources(promrule, metadata)
+
+			if reported, ok := duplicates[key]; ok {
+				if reported {
+					continue
+				}
+				invalidRule := InvalidRule{Template: templateName, PrometheusRule: promrule, Err: fmt.Errorf("PrometheusRule %s is rendered more than once", key)}
+				warnMessage := fmt.Sprintf("[ingress][%s] invalid PrometheusRule from template \"%s\": %s", ingressIdentifier, templateName, invalidRule.Err)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				invalidRules = append(invalidRules, invalidRule)
+				duplicates[key] = true
+				continue
+			}
+
+			if errs := validatePrometheusRule(promrule); len(errs) != 0 {
+				invalidRule := InvalidRule{Template: templateName, PrometheusRule: promrule, Err: joinRuleErrors(errs)}
+				warnMessage := fmt.Sprintf("[ingress][%s] invalid PrometheusRule from template \"%s\": %s", ingressIdentifier, templateName, invalidRule.Err)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				invalidRules = append(invalidRules, invalidRule)
+				continue
+			}
+
+			promrule.SetOwnerReferences([]metav1.OwnerReference{
+				*metav1.NewControllerRef(ingress, schema.GroupVersionKind{
+					Group:   networkingv1.SchemeGroupVersion.Group,
+					Version: networkingv1.SchemeGroupVersion.Version,
+					Kind:    "Ingress",
+				}),
+			})
+
+			if _, ok := prometheusRules[key]; ok {
+				warnMessage := fmt.Sprintf("[ingress][%s] annotation \"%s\" renders PrometheusRule \"%s\" which an earlier annotation already rendered, templates with instances should use .Instance in their names", ingressIdentifier, k, key)
+				logger.Warnf(warnMessage)
+				sentryclient.SentryMessage(warnMessage)
+				continue
+			}
+
+			prometheusRules[key] = promrule
+			ruleTemplates[key] = templateName
+		}
+	}
 
-		prometheusRules[promrule.ObjectMeta.Name] = promrule
+	collected, conflicting := dedupeRecordingRules(collectPrometheusRules(prometheusRules), ruleTemplates)
+	invalidRules = append(invalidRules, conflicting...)
+	if a.consolidate {
+		collected, invalidRules = consolidatePrometheusRules(ingress, "Ingress", collected, invalidRules)
+	}
+	for _, promrule := range collected {
+		a.MarkGenerated(promrule)
 	}
 
-	return collectPrometheusRules(prometheusRules), nil
+	return collected, invalidRulesError(invalidRules)
 }
 
-func (a *PrometheusRuleTemplateManager) resolveIngressOwner(params *templateParameterIngress) (*templateParameterIngress, error) {
-	if len(params.Owner) != 0 {
-		return params, nil
+// ingressParameters
+// - The parameters templates rendered for the Ingress get, before its owner metadata is resolved with resolveIngressMetadata
+// - Also returns why the Ingress has no Probe when its probe annotations are invalid
+func (a *PrometheusRuleTemplateManager) ingressParameters(ingress *networkingv1.Ingress) (*templateParameterIngress, error) {
+	params := &templateParameterIngress{
+		Ingress:     ingress,
+		Identifier:  fmt.Sprintf("%s.%s", ingress.Namespace, ingress.Name),
+		Namespace:   ingress.Namespace,
+		Name:        ingress.Name,
+		Hosts:       ingressHosts(ingress),
+		Prometheus:  a.prometheusParameters(ingress.Namespace),
+		ThanosRuler: a.thanosRulerParameters(ingress.Namespace),
+	}
+	if len(params.Hosts) != 0 {
+		params.Host = params.Hosts[0]
 	}
 
-	if err := params.getIngressService(); err != nil {
-		return params, fmt.Errorf("error getting service for ingress: %v", err)
+	probe, err := a.probeParameters(ingress)
+	params.Probe = probe
+
+	return params, err
+}
+
+// resolveIngressMetadata
+// - Sets the owner metadata of params, from the Ingress, the Deployment of its backend, its Namespace and the cluster default in that order
+// - Returns the error finding the backend's owner only when no owner was found elsewhere
+func (a *PrometheusRuleTemplateManager) resolveIngressMetadata(params *templateParameterIngress) (ownerMetadata, error) {
+	metadata := ownerMetadata{}
+	metadata.inherit(SourceIngress, a.objectMetadata(params.Ingress))
+	backend, err := a.resolveIngressOwner(params)
+	if backend != nil {
+		metadata.inherit(SourceDeployment, backend.Meta)
 	}
+	a.inheritMetadata(metadata, params.Namespace)
 
-	deployment, err := a.findServiceDeployment(params.BackendService, params.Namespace)
-	if err != nil {
-		return params, fmt.Errorf("error getting deployment for service: %v", err)
+	params.Owner = metadata["owner"].Value
+	params.Environment = metadata["environment"].Value
+	params.Criticality = metadata["criticality"].Value
+	params.Sensitivity = metadata["sensitivity"].Value
+	params.Meta = metadata.values()
+
+	if _, ok := metadata["owner"]; ok {
+		return metadata, nil
 	}
 
-	params.Owner = deployment.GetAnnotations()[ownerAnnotation]
-	params.Environment = deployment.GetAnnotations()[environmentAnnotation]
-	params.Criticality = deployment.GetAnnotations()[criticalityAnnotation]
-	params.Sensitivity = deployment.GetAnnotations()[sensitivityAnnotation]
+	return metadata, err
+}
 
-	return params, nil
+// IngressBackend
+// - A Service the Ingress routes to, available to templates in .Backends, with the owner of the Deployment behind it
+type IngressBackend struct {
+	Service string
+	// Paths are the host and path of every rule routed to the Service, empty for the default backend
+	Paths []string
+	// Default is whether the Service is the Ingress's default backend
+	Default bool
+	// Deployment is the name of the Deployment behind the Service, empty when it couldn't be found
+	Deployment  string
+	Owner       string
+	Environment string
+	Criticality string
+	Sensitivity string
+	Meta        map[string]string
 }
 
-func (p *templateParameterIngress) getIngressService() error {
-	switch {
-	case len(p.Ingress.Spec.Rules) == 0:
-		if p.Ingress.Spec.DefaultBackend != nil {
-			p.BackendService = p.Ingress.Spec.DefaultBackend.Service.Name
+// ingressBackends
+// - The Services the Ingress's rules and default backend route to, in the order they first appear
+// - Backends which aren't Services can't be resolved and are left out
+func ingressBackends(ingress *networkingv1.Ingress) []*IngressBackend {
+	backends := []*IngressBackend{}
+	byService := map[string]*IngressBackend{}
+	backend := func(service string) *IngressBackend {
+		if b, ok := byService[service]; ok {
+			return b
 		}
+		b := &IngressBackend{Service: service, Paths: []string{}, Meta: map[string]string{}}
+		byService[service] = b
+		backends = append(backends, b)
+		return b
+	}
 
-	case len(p.Ingress.Spec.Rules) != 0:
-		var services []string
-		for _, r := range p.Ingress.Spec.Rules {
-			for _, s := range r.IngressRuleValue.HTTP.Paths {
-				services = append(services, s.Backend.Service.Name)
+	for _, rule := range ingress.Spec.Rules {
+		// A rule may only name a host
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service == nil {
+				continue
 			}
+			b := backend(path.Backend.Service.Name)
+			if path.Path == "" {
+				path.Path = "/"
+			}
+			b.Paths = append(b.Paths, rule.Host+path.Path)
 		}
+	}
 
-		if p.Ingress.Spec.DefaultBackend != nil {
-			services = append(services, p.Ingress.Spec.DefaultBackend.Service.Name)
+	if ingress.Spec.DefaultBackend != nil && ingress.Spec.DefaultBackend.Service != nil {
+		backend(ingress.Spec.DefaultBackend.Service.Name).Default = true
+	}
+
+	return backends
+}
+
+// resolveIngressOwner
+// - Resolves the owner of every backend from the Deployment behind its Service
+// - Returns the backend whose owner is the Ingress's, the one every backend shares, otherwise the default backend's
+// - Errors when there isn't one, the Ingress's owner then comes from its annotations, its Namespace or the cluster default
+func (a *PrometheusRuleTemplateManager) resolveIngressOwner(params *templateParameterIngress) (*IngressBackend, error) {
+	params.Backends = ingressBackends(params.Ingress)
+	if len(params.Backends) == 1 {
+		params.BackendService = params.Backends[0].Service
+	}
+
+	errs := []string{}
+	for _, backend := range params.Backends {
+		deployment, err := a.findServiceDeployment(backend.Service, params.Namespace)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("error getting deployment for service %s: %v", backend.Service, err))
+			continue
 		}
 
-		p.BackendService = checkNamesMatch(services)
+		backend.Deployment = deployment.GetName()
+		backend.Meta = a.objectMetadata(deployment)
+		backend.Owner = backend.Meta["owner"]
+		backend.Environment = backend.Meta["environment"]
+		backend.Criticality = backend.Meta["criticality"]
+		backend.Sensitivity = backend.Meta["sensitivity"]
+	}
+
+	if len(params.Backends) == 0 {
+		return nil, fmt.Errorf("no backend services")
+	}
+
+	owner := ingressOwner(params.Backends, len(errs) == 0)
+	if owner == nil {
+		if len(errs) != 0 {
+			return nil, fmt.Errorf("%s", strings.Join(errs, ", "))
+		}
+		return nil, fmt.Errorf("backends have different owners (%s) and there's no default backend to take the owner from", strings.Join(backendOwners(params.Backends), ", "))
+	}
+
+	return owner, nil
+}
+
+// ingressOwner
+// - The backend whose owner is the Ingress's, the first when every backend has the same owner and otherwise the default backend
+// - A backend is picked even when its Deployment has no owner, the Ingress still takes its environment, criticality and sensitivity
+// - Backends can only share an owner when all of them were resolved, the default backend only has one when it was
+func ingressOwner(backends []*IngressBackend, resolved bool) *IngressBackend {
+	shared := resolved
+	for _, backend := range backends {
+		if backend.Owner != backends[0].Owner {
+			shared = false
+		}
+	}
+	if shared {
+		return backends[0]
 	}
 
-	if p.BackendService == "" {
-		return fmt.Errorf("could not find single service for ingress")
+	for _, backend := range backends {
+		if backend.Default && backend.Deployment != "" {
+			return backend
+		}
 	}
 
 	return nil
 }
 
-func checkNamesMatch(services []string) string {
-	for i := 1; i < len(services); i++ {
-		if services[i] != services[0] {
-			return ""
+// backendOwners
+// - The distinct owners of the backends, for error messages
+func backendOwners(backends []*IngressBackend) []string {
+	owners := []string{}
+	seen := map[string]bool{}
+	for _, backend := range backends {
+		owner := backend.Owner
+		if owner == "" {
+			owner = "none"
+		}
+		if !seen[owner] {
+			seen[owner] = true
+			owners = append(owners, owner)
 		}
 	}
-	return services[0]
+
+	return owners
 }
 
-func (a *PrometheusRuleTemplateManager) findServiceDeployment(serviceName, namespace string) (*metav1.ObjectMeta, error) {
-	ctx := context.Background()
-	service, err := a.clientSet.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
+func (a *PrometheusRuleTemplateManager) findServiceDeployment(serviceName, namespace string) (metav1.Object, error) {
+	service, err := a.objects.Service(namespace, serviceName)
 	if err != nil {
 		return nil, fmt.Errorf("error getting service: %v", err)
 	}
@@ -194,25 +411,16 @@ func (a *PrometheusRuleTemplateManager) findServiceDeployment(serviceName, names
 }
 
 func (a *PrometheusRuleTemplateManager) listPodOwnerReferences(selector map[string]string, namespace string) (map[string]metav1.OwnerReference, error) {
-	var podsMeta []*metav1.ObjectMeta
-	ctx := context.Background()
-	set := labels.Set(selector)
-	listOptions := metav1.ListOptions{LabelSelector: set.AsSelector().String()}
-
-	pods, err := a.clientSet.CoreV1().Pods(namespace).List(ctx, listOptions)
+	pods, err := a.objects.Pods(namespace, labels.Set(selector).AsSelector())
 	if err != nil {
 		return nil, fmt.Errorf("error getting pods: %v", err)
 	}
 
-	for _, pod := range pods.Items {
-		podsMeta = append(podsMeta, &pod.ObjectMeta)
-	}
-
-	return uniqueOwnerReferences(podsMeta), nil
+	return uniqueOwnerReferences(pods), nil
 }
 
 func (a *PrometheusRuleTemplateManager) getReplicasetOwnerReferences(podOwners map[string]metav1.OwnerReference, namespace string) (map[string]metav1.OwnerReference, error) {
-	var replicasetsMeta []*metav1.ObjectMeta
+	var replicasetsMeta []metav1.Object
 
 	for _, owner := range podOwners {
 		replicasetMeta, err := a.getAppsObjectMeta(owner.Name, namespace, owner.Kind)
@@ -226,8 +434,8 @@ func (a *PrometheusRuleTemplateManager) getReplicasetOwnerReferences(podOwners m
 	return uniqueOwnerReferences(replicasetsMeta), nil
 }
 
-func (a *PrometheusRuleTemplateManager) getDeployments(replicasetOwners map[string]metav1.OwnerReference, namespace string) ([]*metav1.ObjectMeta, error) {
-	uniqDeployments := make(map[string]*metav1.ObjectMeta)
+func (a *PrometheusRuleTemplateManager) getDeployments(replicasetOwners map[string]metav1.OwnerReference, namespace string) ([]metav1.Object, error) {
+	uniqDeployments := make(map[string]metav1.Object)
 
 	for _, owner := range replicasetOwners {
 		deploymentMeta, err := a.getAppsObjectMeta(owner.Name, namespace, owner.Kind)
@@ -235,10 +443,10 @@ func (a *PrometheusRuleTemplateManager) getDeployments(replicasetOwners map[stri
 			return nil, fmt.Errorf("error getting object meta for deployment: %v", err)
 		}
 
-		uniqDeployments[fmt.Sprintf("%s/%s/%s", deploymentMeta.Name, deploymentMeta.Namespace, deploymentMeta.UID)] = deploymentMeta
+		uniqDeployments[fmt.Sprintf("%s/%s/%s", deploymentMeta.GetName(), deploymentMeta.GetNamespace(), deploymentMeta.GetUID())] = deploymentMeta
 	}
 
-	var deployments []*metav1.ObjectMeta
+	var deployments []metav1.Object
 	for _, deployment := range uniqDeployments {
 		deployments = append(deployments, deployment)
 	}
@@ -246,32 +454,31 @@ func (a *PrometheusRuleTemplateManager) getDeployments(replicasetOwners map[stri
 	return deployments, nil
 }
 
-func (a *PrometheusRuleTemplateManager) getAppsObjectMeta(name, namespace, kind string) (*metav1.ObjectMeta, error) {
-	ctx := context.Background()
+func (a *PrometheusRuleTemplateManager) getAppsObjectMeta(name, namespace, kind string) (metav1.Object, error) {
 	switch {
 	default:
 		return nil, fmt.Errorf("got unrecognised apps kind: %v", kind)
 	case kind == "Deployment":
-		deployment, err := a.clientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		deployment, err := a.objects.Deployment(namespace, name)
 		if err != nil {
 			return nil, fmt.Errorf("error getting deployment: %v", err)
 		}
-		return &deployment.ObjectMeta, nil
+		return deployment, nil
 
 	case kind == "ReplicaSet":
-		replicaset, err := a.clientSet.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		replicaset, err := a.objects.ReplicaSet(namespace, name)
 		if err != nil {
 			return nil, fmt.Errorf("error getting replicaset: %v", err)
 		}
-		return &replicaset.ObjectMeta, nil
+		return replicaset, nil
 	}
 }
 
-func uniqueOwnerReferences(objects []*metav1.ObjectMeta) map[string]metav1.OwnerReference {
+func uniqueOwnerReferences(objects []metav1.Object) map[string]metav1.OwnerReference {
 	uniqueOwnerReferences := make(map[string]metav1.OwnerReference)
 
 	for _, object := range objects {
-		for _, owner := range object.OwnerReferences {
+		for _, owner := range object.GetOwnerReferences() {
 			uniqueOwnerReferences[fmt.Sprintf("%s/%s/%s", owner.APIVersion, owner.Kind, owner.Name)] = owner
 		}
 	}
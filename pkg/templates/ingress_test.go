@@ -1,6 +1,8 @@
 package templates
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	log "github.com/uswitch/heimdall/pkg/log"
@@ -11,6 +13,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -128,25 +131,27 @@ func TestIngressAnnotationsDefaultBackend(t *testing.T) {
 
 	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
 
-	expr := `(
-  sum(
-    rate(
-      nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testDefaultBackend",status=~"5.."}[30s]
-    )
+	record := `sum by (exported_namespace, ingress) (
+  rate(
+    nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testDefaultBackend",status=~"5.."}[30s]
   )
-  /
-  sum(
-    rate(
-      nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testDefaultBackend"}[30s]
-    )
+)
+/
+sum by (exported_namespace, ingress) (
+  rate(
+    nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testDefaultBackend"}[30s]
   )
-) > 0.001
+)
+`
+	expr := `heimdall:ingress_5xx_ratio:rate30s{exported_namespace="testNamespace",ingress="testDefaultBackend"} > 0.001
 `
 	promrules, err := template.CreateFromIngress(testIngressDefaultBackend)
 	assert.Assert(t, is.Nil(err))
 	assert.Assert(t, is.Len(promrules, 1))
-	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Expr.StrVal, expr)
-	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Labels["owner"], "testIngressOwner")
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Record, "heimdall:ingress_5xx_ratio:rate30s")
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Expr.StrVal, record)
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[1].Expr.StrVal, expr)
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[1].Labels["owner"], "testIngressOwner")
 }
 
 func TestIngressAnnotationsRuleBackend(t *testing.T) {
@@ -156,33 +161,247 @@ func TestIngressAnnotationsRuleBackend(t *testing.T) {
 
 	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
 
-	expr := `(
-  sum(
-    rate(
-      nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testRuleBackend",status=~"5.."}[30s]
-    )
+	record := `sum by (exported_namespace, ingress) (
+  rate(
+    nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testRuleBackend",status=~"5.."}[30s]
   )
-  /
-  sum(
-    rate(
-      nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testRuleBackend"}[30s]
-    )
+)
+/
+sum by (exported_namespace, ingress) (
+  rate(
+    nginx_ingress_controller_requests{exported_namespace="testNamespace",ingress="testRuleBackend"}[30s]
   )
-) > 0.001
+)
+`
+	expr := `heimdall:ingress_5xx_ratio:rate30s{exported_namespace="testNamespace",ingress="testRuleBackend"} > 0.001
 `
 	promrules, err := template.CreateFromIngress(testIngressRuleBackend)
 	assert.Assert(t, is.Nil(err))
 	assert.Assert(t, is.Len(promrules, 1))
-	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Expr.StrVal, expr)
-	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Labels["owner"], "testDeploymentOwner")
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Record, "heimdall:ingress_5xx_ratio:rate30s")
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Expr.StrVal, record)
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[1].Expr.StrVal, expr)
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[1].Labels["owner"], "testDeploymentOwner")
+}
+
+func TestIngressBackends(t *testing.T) {
+	backends := ingressBackends(testIngressRuleBackend)
+	assert.Assert(t, is.Len(backends, 1))
+	assert.Equal(t, backends[0].Service, "testService")
+	assert.DeepEqual(t, backends[0].Paths, []string{"test/"})
+	assert.Assert(t, !backends[0].Default)
+
+	backends = ingressBackends(testIngressDefaultBackend)
+	assert.Assert(t, is.Len(backends, 1))
+	assert.Assert(t, is.Len(backends[0].Paths, 0))
+	assert.Assert(t, backends[0].Default)
+}
+
+// testFanOut
+// - An Ingress routing /basket to the testService's Deployment and /search to a Deployment owned by another team, with objects to resolve both
+func testFanOut() (*networkingv1.Ingress, []runtime.Object) {
+	ingress := testIngressRuleBackend.DeepCopy()
+	ingress.Name = "testFanOut"
+	ingress.Spec.Rules[0].HTTP.Paths = []networkingv1.HTTPIngressPath{
+		{Path: "/basket", Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "testService"}}},
+		{Path: "/search", Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "searchService"}}},
+	}
+
+	service := testService.DeepCopy()
+	service.Name = "searchService"
+	service.Spec.Selector = map[string]string{"app": "search"}
+
+	pod := testPod.DeepCopy()
+	pod.Name = "searchPod"
+	pod.Labels = map[string]string{"app": "search"}
+	pod.OwnerReferences[0].Name = "searchReplicaSet"
+
+	replicaset := testReplicaset.DeepCopy()
+	replicaset.Name = "searchReplicaSet"
+	replicaset.OwnerReferences[0].Name = "search"
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Name = "search"
+	deployment.Annotations = map[string]string{ownerAnnotation: "testSearchOwner", criticalityAnnotation: "high"}
+
+	return ingress, []runtime.Object{testService, testDeployment, testReplicaset, testPod, service, pod, replicaset, deployment}
+}
+
+func TestIngressBackendOwners(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	ingress, objects := testFanOut()
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset(objects...))
+	assert.Assert(t, is.Nil(err))
+
+	params := &templateParameterIngress{Ingress: ingress, Namespace: ingress.Namespace, Name: ingress.Name}
+	_, err = template.resolveIngressOwner(params)
+	assert.ErrorContains(t, err, "backends have different owners (testDeploymentOwner, testSearchOwner)")
+	assert.Equal(t, params.BackendService, "")
+	assert.Assert(t, is.Len(params.Backends, 2))
+	assert.DeepEqual(t, params.Backends[1], &IngressBackend{
+		Service:     "searchService",
+		Paths:       []string{"test/search"},
+		Deployment:  "search",
+		Owner:       "testSearchOwner",
+		Criticality: "high",
+		Meta:        map[string]string{"owner": "testSearchOwner", "criticality": "high"},
+	})
+
+	// The default backend's owner is the Ingress's
+	ingress.Spec.DefaultBackend = &networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "searchService"}}
+	params = &templateParameterIngress{Ingress: ingress, Namespace: ingress.Namespace, Name: ingress.Name}
+	owner, err := template.resolveIngressOwner(params)
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, owner, params.Backends[1])
+	assert.Equal(t, params.Backends[0].Owner, "testDeploymentOwner")
+
+	// The Ingress's annotation takes precedence, the rest of the metadata comes from the default backend
+	ingress.Annotations[ownerAnnotation] = "testIngressOwner"
+	promrules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	alert := promrules[0].Spec.Groups[0].Rules[1]
+	assert.Equal(t, alert.Labels["owner"], "testIngressOwner")
+	assert.Equal(t, alert.Annotations["owner_source"], SourceIngress)
+	assert.Equal(t, alert.Labels["criticality"], "high")
+	assert.Equal(t, alert.Annotations["criticality_source"], SourceDeployment)
+}
+
+func TestIngressBackendWithoutOwner(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Annotations = map[string]string{environmentAnnotation: "production", criticalityAnnotation: "high"}
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset(testService, deployment, testReplicaset, testPod))
+	assert.Assert(t, is.Nil(err))
+
+	// The single backend is the Ingress's even though its Deployment has no owner
+	params := &templateParameterIngress{Ingress: testIngressRuleBackend, Namespace: testIngressRuleBackend.Namespace, Name: testIngressRuleBackend.Name}
+	owner, err := template.resolveIngressOwner(params)
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, owner, params.Backends[0])
+
+	promrules, err := template.CreateFromIngress(testIngressRuleBackend)
+	assert.Assert(t, is.Nil(err))
+	alert := promrules[0].Spec.Groups[0].Rules[1]
+	assert.Equal(t, alert.Labels["owner"], "")
+	assert.Equal(t, alert.Labels["environment"], "production")
+	assert.Equal(t, alert.Labels["criticality"], "high")
 }
 
-func TestNamesMatch(t *testing.T) {
-	services := []string{"test", "test", "test"}
-	service := checkNamesMatch(services)
-	assert.Equal(t, services[0], service)
+func TestIngressBackendsTemplate(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
 
-	services = []string{"test1", "test2", "test3"}
-	service = checkNamesMatch(services)
-	assert.Equal(t, "", service)
+	directory := t.TempDir()
+	assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, "backend-5xx.tmpl"), []byte(`
+{{- range .Backends}}
+---
+apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: {{$.Name}}-{{.Service}}-5xx
+  namespace: ingress
+spec:
+  groups:
+  - name: {{$.Name}}-{{.Service}}-5xx.rules
+    rules:
+    - alert: {{$.Name}}-{{.Service}}-5xx
+      expr: sum(rate(nginx_ingress_controller_requests{ingress="{{$.Name}}",service="{{.Service}}",status=~"5.."}[1m])) > {{$.Threshold}}
+      labels:
+        owner: {{.Owner}}
+{{- end}}
+`), 0644)))
+
+	ingress, objects := testFanOut()
+	ingress.Annotations = map[string]string{"com.uswitch.heimdall/backend-5xx": "1"}
+	template, err := NewPrometheusRuleTemplateManager(directory, fake.NewSimpleClientset(objects...))
+	assert.Assert(t, is.Nil(err))
+
+	promrules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(promrules, 2))
+	assert.Equal(t, promrules[0].Name, "testFanOut-searchService-5xx")
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[0].Labels["owner"], "testSearchOwner")
+	assert.Equal(t, promrules[1].Spec.Groups[0].Rules[0].Labels["owner"], "testDeploymentOwner")
+}
+
+func TestIngressInvalidThreshold(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(testService, testDeployment, testReplicaset, testPod)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	ingress := testIngressDefaultBackend.DeepCopy()
+	ingress.Annotations["com.uswitch.heimdall/5xx-rate"] = "0.001)"
+
+	promrules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Len(promrules, 0))
+
+	invalidRulesErr, ok := err.(*InvalidRulesError)
+	assert.Assert(t, ok)
+	assert.Assert(t, is.Len(invalidRulesErr.Rules, 1))
+	assert.Equal(t, invalidRulesErr.Rules[0].Template, "5xx-rate")
+	assert.Equal(t, invalidRulesErr.Rules[0].PrometheusRule.Name, "testNamespace-testDefaultBackend-5xx-rate")
+}
+
+func TestIngressTemplateInstances(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(testService, testDeployment, testReplicaset, testPod)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	ingress := testIngressDefaultBackend.DeepCopy()
+	ingress.Annotations["com.uswitch.heimdall/5xx-rate.page"] = "0.05"
+
+	promrules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(promrules, 2))
+
+	names := map[string]string{}
+	records := 0
+	for _, promrule := range promrules {
+		for _, rule := range promrule.Spec.Groups[0].Rules {
+			if rule.Record != "" {
+				records++
+				continue
+			}
+			names[promrule.Name] = rule.Alert
+		}
+	}
+	assert.DeepEqual(t, names, map[string]string{
+		"testNamespace-testDefaultBackend-5xx-rate":      "testDefaultBackend-5xx-rate",
+		"testNamespace-testDefaultBackend-5xx-rate-page": "testDefaultBackend-5xx-rate-page",
+	})
+
+	// Both instances use the same recording rule, it is only rendered once
+	assert.Equal(t, records, 1)
+}
+
+func TestIngressInvalidParameters(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(testService, testDeployment, testReplicaset, testPod)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	for _, value := range []string{"0.001)", "2", `{"for": "5m"}`, `{"threshold": 0.1, "for": "soon"}`, `{"threshold": 0.1, "unknown": "x"}`} {
+		ingress := testIngressDefaultBackend.DeepCopy()
+		ingress.Annotations["com.uswitch.heimdall/5xx-rate"] = value
+
+		// The rule the annotation would render is reported, so the existing one is kept
+		promrules, err := template.CreateFromIngress(ingress)
+		assert.Check(t, is.Len(promrules, 0), value)
+
+		invalidRulesErr, ok := err.(*InvalidRulesError)
+		if !assert.Check(t, ok, value) {
+			continue
+		}
+		assert.Check(t, is.Len(invalidRulesErr.Rules, 1), value)
+		assert.Check(t, is.Equal(invalidRulesErr.Rules[0].PrometheusRule.Name, "testNamespace-testDefaultBackend-5xx-rate"), value)
+	}
 }
@@ -0,0 +1,189 @@
+package templates
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/common/model"
+	"github.com/uswitch/heimdall/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Sources of inherited owner metadata, recorded in the <label>_source annotation of alerts
+const (
+	SourceIngress    = "ingress"
+	SourceDeployment = "deployment"
+	SourceNamespace  = "namespace"
+	SourceDefault    = "default"
+)
+
+const (
+	ownerAnnotation       = "service.rvu.co.uk/owner"
+	environmentAnnotation = "service.rvu.co.uk/environment"
+	criticalityAnnotation = "service.rvu.co.uk/criticality"
+	sensitivityAnnotation = "service.rvu.co.uk/sensitivity"
+)
+
+// DefaultMetadataKeys maps the owner metadata fields to the annotations they're read from, unless SetMetadataKeys changes them
+// - Each field is also the label alerts carry it in
+var DefaultMetadataKeys = map[string]string{
+	"owner":       ownerAnnotation,
+	"environment": environmentAnnotation,
+	"criticality": criticalityAnnotation,
+	"sensitivity": sensitivityAnnotation,
+}
+
+// metadataValue
+// - A piece of owner metadata and where it came from
+type metadataValue struct {
+	Value  string
+	Source string
+}
+
+// ownerMetadata
+// - The owner, environment, criticality, sensitivity and any other metadata fields of an object, keyed by field
+// - Each is taken from the first source which sets it, the object, its Deployment, its Namespace and then the cluster default
+type ownerMetadata map[string]metadataValue
+
+// inherit
+// - Fills the fields still missing from values, recording source
+func (m ownerMetadata) inherit(source string, values map[string]string) {
+	for field, value := range values {
+		if _, ok := m[field]; ok || value == "" {
+			continue
+		}
+		m[field] = metadataValue{Value: value, Source: source}
+	}
+}
+
+// values
+// - The metadata without its sources, as templates get it in .Meta
+func (m ownerMetadata) values() map[string]string {
+	values := map[string]string{}
+	for field, value := range m {
+		values[field] = value.Value
+	}
+
+	return values
+}
+
+// SetMetadataKeys
+// - Maps metadata fields to the annotation or label keys they're read from, such as owner=team.example.com/owner, on top of DefaultMetadataKeys
+// - A field mapped to an empty key isn't read, fields other than the owner, environment, criticality and sensitivity are only in .Meta
+// - Call before SetDefaultMetadata, which only accepts known fields
+func (a *PrometheusRuleTemplateManager) SetMetadataKeys(keys map[string]string) error {
+	metadataKeys := map[string]string{}
+	for field, key := range DefaultMetadataKeys {
+		metadataKeys[field] = key
+	}
+
+	for field, key := range keys {
+		if !model.LabelName(field).IsValid() {
+			return fmt.Errorf("invalid metadata field \"%s\", fields are used as alert labels", field)
+		}
+		if key == "" {
+			delete(metadataKeys, field)
+			continue
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return fmt.Errorf("invalid key \"%s\" for metadata field \"%s\": %s", key, field, strings.Join(errs, ", "))
+		}
+		metadataKeys[field] = key
+	}
+
+	a.metadataKeys = metadataKeys
+	return nil
+}
+
+// objectMetadata
+// - The metadata fields an object sets, keyed by field, each from the object's annotation or failing that its label
+func (a *PrometheusRuleTemplateManager) objectMetadata(obj metav1.Object) map[string]string {
+	values := map[string]string{}
+	for field, key := range a.metadataKeys {
+		if value := obj.GetAnnotations()[key]; value != "" {
+			values[field] = value
+		} else if value := obj.GetLabels()[key]; value != "" {
+			values[field] = value
+		}
+	}
+
+	return values
+}
+
+// ObjectOwner
+// - The owner an object sets itself, which its alerts are labelled with
+func (a *PrometheusRuleTemplateManager) ObjectOwner(obj metav1.Object) string {
+	return a.objectMetadata(obj)["owner"]
+}
+
+// SetDefaultMetadata
+// - Metadata, keyed by field, for objects which get none from themselves, their Deployment or their Namespace
+func (a *PrometheusRuleTemplateManager) SetDefaultMetadata(defaults map[string]string) error {
+	for field := range defaults {
+		if _, ok := a.metadataKeys[field]; !ok {
+			return fmt.Errorf("unknown default metadata \"%s\", expected one of %s", field, strings.Join(sortedKeys(a.metadataKeys), ", "))
+		}
+	}
+
+	a.defaultMetadata = defaults
+	return nil
+}
+
+// namespaceMetadata
+// - The metadata a namespace sets, nil when it can't be found
+func (a *PrometheusRuleTemplateManager) namespaceMetadata(namespace string) map[string]string {
+	ns, err := a.objects.Namespace(namespace)
+	if err != nil {
+		log.Sugar.Debugw("error getting namespace for owner metadata", "namespace", namespace, "error", err)
+		return nil
+	}
+
+	return a.objectMetadata(ns)
+}
+
+// inheritMetadata
+// - Completes metadata from the namespace and the cluster default
+func (a *PrometheusRuleTemplateManager) inheritMetadata(metadata ownerMetadata, namespace string) ownerMetadata {
+	metadata.inherit(SourceNamespace, a.namespaceMetadata(namespace))
+	metadata.inherit(SourceDefault, a.defaultMetadata)
+
+	return metadata
+}
+
+// NamespaceOwner
+// - The owner objects in namespace without one of their own inherit, from the namespace or the cluster default
+func (a *PrometheusRuleTemplateManager) NamespaceOwner(namespace string) string {
+	return a.inheritMetadata(ownerMetadata{}, namespace)["owner"].Value
+}
+
+// recordMetadataSources
+// - Adds a <field>_source annotation to every alert whose label for a metadata field has the inherited value, naming where it came from
+func recordMetadataSources(promrule *monitoringv1.PrometheusRule, metadata ownerMetadata) {
+	labels := []string{}
+	for label := range metadata {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	for i := range promrule.Spec.Groups {
+		for j := range promrule.Spec.Groups[i].Rules {
+			rule := &promrule.Spec.Groups[i].Rules[j]
+			if rule.Alert == "" {
+				continue
+			}
+
+			for _, label := range labels {
+				if rule.Labels[label] != metadata[label].Value {
+					continue
+				}
+				if rule.Annotations == nil {
+					rule.Annotations = map[string]string{}
+				}
+				rule.Annotations[label+"_source"] = metadata[label].Source
+			}
+		}
+	}
+}
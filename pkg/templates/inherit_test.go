@@ -0,0 +1,92 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestInheritedIngressMetadata(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "testNamespace",
+		Annotations: map[string]string{ownerAnnotation: "testNamespaceOwner", environmentAnnotation: "staging"},
+	}}
+	deployment := testDeployment.DeepCopy()
+	deployment.Annotations = map[string]string{criticalityAnnotation: "high"}
+
+	client := fake.NewSimpleClientset(testService, deployment, testReplicaset, testPod, namespace)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Nil(template.SetDefaultMetadata(map[string]string{"sensitivity": "internal", "environment": "production"})))
+
+	// The Ingress has no metadata, its Deployment only the criticality
+	promrules, err := template.CreateFromIngress(testIngressRuleBackend)
+	assert.Assert(t, is.Nil(err))
+	alert := promrules[0].Spec.Groups[0].Rules[1]
+	assert.DeepEqual(t, alert.Labels, map[string]string{
+		"identifier":  "testNamespace.testRuleBackend",
+		"name":        "testRuleBackend-5xx-rate",
+		"namespace":   "testNamespace",
+		"owner":       "testNamespaceOwner",
+		"environment": "staging",
+		"criticality": "high",
+		"sensitivity": "internal",
+	})
+	assert.Equal(t, alert.Annotations["owner_source"], SourceNamespace)
+	assert.Equal(t, alert.Annotations["environment_source"], SourceNamespace)
+	assert.Equal(t, alert.Annotations["criticality_source"], SourceDeployment)
+	assert.Equal(t, alert.Annotations["sensitivity_source"], SourceDefault)
+
+	// Recording rules don't get sources
+	assert.Assert(t, is.Len(promrules[0].Spec.Groups[0].Rules[0].Annotations, 0))
+
+	assert.Equal(t, template.NamespaceOwner("testNamespace"), "testNamespaceOwner")
+	assert.Equal(t, template.NamespaceOwner("unknown"), "")
+}
+
+func TestSetDefaultMetadata(t *testing.T) {
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+
+	assert.ErrorContains(t, template.SetDefaultMetadata(map[string]string{"team": "platform"}), "unknown default metadata \"team\"")
+	assert.Assert(t, is.Nil(template.SetDefaultMetadata(map[string]string{"owner": "team-platform"})))
+	assert.Equal(t, template.NamespaceOwner("unknown"), "team-platform")
+}
+
+func TestSetMetadataKeys(t *testing.T) {
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+
+	assert.ErrorContains(t, template.SetMetadataKeys(map[string]string{"team-name": "team.example.com/name"}), "invalid metadata field \"team-name\"")
+	assert.ErrorContains(t, template.SetMetadataKeys(map[string]string{"team": "team.example.com/"}), "invalid key \"team.example.com/\"")
+
+	assert.Assert(t, is.Nil(template.SetMetadataKeys(map[string]string{
+		"owner":       "team.example.com/owner",
+		"tier":        "app.kubernetes.io/part-of",
+		"sensitivity": "",
+	})))
+	assert.ErrorContains(t, template.SetDefaultMetadata(map[string]string{"sensitivity": "internal"}), "unknown default metadata \"sensitivity\"")
+	assert.Assert(t, is.Nil(template.SetDefaultMetadata(map[string]string{"tier": "unknown"})))
+
+	// Annotations are read before labels, keys which are no longer configured aren't read
+	deployment := &metav1.ObjectMeta{
+		Annotations: map[string]string{"team.example.com/owner": "team-shop", ownerAnnotation: "team-ignored", sensitivityAnnotation: "internal"},
+		Labels:      map[string]string{"team.example.com/owner": "team-label", "app.kubernetes.io/part-of": "storefront", environmentAnnotation: "production"},
+	}
+	assert.DeepEqual(t, template.objectMetadata(deployment), map[string]string{
+		"owner":       "team-shop",
+		"tier":        "storefront",
+		"environment": "production",
+	})
+	assert.Equal(t, template.ObjectOwner(deployment), "team-shop")
+
+	// The defaults aren't changed by a manager's keys
+	assert.Equal(t, DefaultMetadataKeys["owner"], ownerAnnotation)
+}
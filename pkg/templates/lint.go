@@ -0,0 +1,426 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	yamlv3 "gopkg.in/yaml.v3"
+	appsv1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// templateErrorLine matches the line number text/template puts in parse and execution errors
+var templateErrorLine = regexp.MustCompile(`^template: [^:]+:(\d+):`)
+
+// Diagnostic
+// - A problem found in a template by Lint
+type Diagnostic struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// sampleMeta is the metadata the sample parameters have in .Meta
+var sampleMeta = map[string]string{
+	"owner":       "sample-owner",
+	"environment": "sample-environment",
+	"criticality": "sample-criticality",
+	"sensitivity": "sample-sensitivity",
+}
+
+// samplePrometheus is the Prometheus the sample parameters have in .Prometheus
+var samplePrometheus = &PrometheusParameters{
+	Name:          "sample-prometheus",
+	Namespace:     "sample-monitoring",
+	RuleLabels:    map[string]string{"role": "alert-rules"},
+	RuleNamespace: "sample-namespace",
+}
+
+// sampleThanosRuler is the ThanosRuler the sample parameters have in .ThanosRuler
+var sampleThanosRuler = &PrometheusParameters{
+	Name:          "sample-thanos-ruler",
+	Namespace:     "sample-monitoring",
+	RuleLabels:    map[string]string{"role": "global-alert-rules"},
+	RuleNamespace: "sample-namespace",
+}
+
+// sampleParameters
+// - Representative parameters for each kind of object a template can be rendered for.
+// - Every field is set so conditional blocks render and output lines line up with the template's.
+func sampleParameters(kind string, params map[string]interface{}) interface{} {
+	switch kind {
+	case "Ingress":
+		return &templateParameterIngress{
+			Ingress: &networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "sample-name", Namespace: "sample-namespace"},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "sample.example.com"}},
+				},
+			},
+			Identifier:     "sample-namespace.sample-name",
+			Threshold:      thresholdParameter(params),
+			Namespace:      "sample-namespace",
+			Name:           "sample-name",
+			Host:           "sample.example.com",
+			Hosts:          []string{"sample.example.com"},
+			Value:          thresholdParameter(params),
+			Owner:          "sample-owner",
+			Environment:    "sample-environment",
+			Criticality:    "sample-criticality",
+			Sensitivity:    "sample-sensitivity",
+			Meta:           sampleMeta,
+			BackendService: "sample-service",
+			Backends: []*IngressBackend{{
+				Service:     "sample-service",
+				Paths:       []string{"sample.example.com/"},
+				Deployment:  "sample-deployment",
+				Owner:       "sample-owner",
+				Environment: "sample-environment",
+				Criticality: "sample-criticality",
+				Sensitivity: "sample-sensitivity",
+				Meta:        sampleMeta,
+			}},
+			Params:    params,
+			Instance:  "sample-instance",
+			Dashboard: "https://grafana.example.com/d/sample",
+			Probe: &ProbeParameters{
+				Name:           "sample-name-probe",
+				Module:         "http_2xx",
+				Targets:        []string{"https://sample.example.com/"},
+				ExpectedStatus: "200",
+			},
+			Prometheus:  samplePrometheus,
+			ThanosRuler: sampleThanosRuler,
+		}
+	case "Deployment":
+		return &templateParameterDeployment{
+			Deployment: &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "sample-name", Namespace: "sample-namespace"},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "sample-name"}},
+				},
+			},
+			Identifier:          "sample-namespace.sample-name",
+			Threshold:           thresholdParameter(params),
+			Namespace:           "sample-namespace",
+			NamespacePrometheus: "sample-prometheus",
+			Name:                "sample-name",
+			Host:                "sample.example.com",
+			Value:               thresholdParameter(params),
+			GeneratedLabels:     `,app="sample-name"`,
+			NSPrometheus:        "sample-prometheus",
+			Owner:               "sample-owner",
+			Environment:         "sample-environment",
+			Criticality:         "sample-criticality",
+			Sensitivity:         "sample-sensitivity",
+			Meta:                sampleMeta,
+			Prometheus:          samplePrometheus,
+			ThanosRuler:         sampleThanosRuler,
+			Params:              params,
+			Instance:            "sample-instance",
+			Dashboard:           "https://grafana.example.com/d/sample",
+		}
+	}
+
+	return nil
+}
+
+// sampleParams
+// - Every parameter the template's metadata declares, set to its default or a value of the right type
+func sampleParams(metadata *TemplateMetadata) map[string]interface{} {
+	params := map[string]interface{}{"threshold": "0.5"}
+	if metadata == nil {
+		return params
+	}
+
+	for name, parameter := range metadata.Parameters {
+		if parameter.Default != nil {
+			params[name] = parameter.Default
+			continue
+		}
+
+		switch parameter.Type {
+		case ParameterNumber:
+			value := 0.5
+			if parameter.Min != nil && value < *parameter.Min {
+				value = *parameter.Min
+			}
+			if parameter.Max != nil && value > *parameter.Max {
+				value = *parameter.Max
+			}
+			params[name] = value
+		case ParameterDuration:
+			params[name] = "5m"
+		case ParameterBoolean:
+			params[name] = true
+		default:
+			params[name] = "sample-" + name
+		}
+	}
+
+	return params
+}
+
+// Lint
+// - Loads every template in directory, renders it with sample parameters and checks the PrometheusRule it produces
+// - A template only has to render for one kind of object, templates for Ingresses can't refer to Deployment fields
+func Lint(directory string) ([]Diagnostic, error) {
+	templateFiles, err := filepath.Glob(directory + "/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(templateFiles) == 0 {
+		return nil, fmt.Errorf("no templates defined")
+	}
+
+	diagnostics := []Diagnostic{}
+	for _, file := range templateFiles {
+		diagnostics = append(diagnostics, lintTemplate(file)...)
+	}
+
+	return diagnostics, nil
+}
+
+func lintTemplate(file string) []Diagnostic {
+	tmpl, err := parseTemplate(file)
+	if err != nil {
+		return []Diagnostic{templateDiagnostic(file, err)}
+	}
+
+	metadata, headerLines, err := parseTemplateMetadata(file)
+	if err != nil {
+		return []Diagnostic{{File: file, Message: err.Error()}}
+	}
+
+	kinds := supportedKinds
+	if metadata != nil && len(metadata.Kinds) != 0 {
+		kinds = metadata.Kinds
+	}
+
+	diagnostics := []Diagnostic{}
+	executeErrors := []Diagnostic{}
+	for _, kind := range kinds {
+		var result bytes.Buffer
+		if err := tmpl.Execute(&result, sampleParameters(kind, sampleParams(metadata))); err != nil {
+			diagnostic := templateDiagnostic(file, err)
+			diagnostic.Message = fmt.Sprintf("rendering for %s: %s", kind, diagnostic.Message)
+			executeErrors = append(executeErrors, diagnostic)
+			continue
+		}
+
+		for _, diagnostic := range lintRendered(file, result.Bytes()) {
+			// The metadata header doesn't render, so output lines are behind the template's by its length
+			if diagnostic.Line != 0 {
+				diagnostic.Line += headerLines
+			}
+			diagnostics = append(diagnostics, diagnostic)
+		}
+	}
+
+	if len(executeErrors) == len(kinds) {
+		return executeErrors
+	}
+
+	return uniqueDiagnostics(diagnostics)
+}
+
+// uniqueDiagnostics
+// - Most templates render the same output for every kind, so each problem is only reported once
+func uniqueDiagnostics(diagnostics []Diagnostic) []Diagnostic {
+	seen := map[string]bool{}
+	unique := []Diagnostic{}
+	for _, diagnostic := range diagnostics {
+		if !seen[diagnostic.String()] {
+			seen[diagnostic.String()] = true
+			unique = append(unique, diagnostic)
+		}
+	}
+
+	return unique
+}
+
+// lintRendered
+// - Checks every YAML document of a rendered template, each one is a PrometheusRule the template manager applies
+func lintRendered(file string, rendered []byte) []Diagnostic {
+	docs := documents(rendered)
+	if len(docs) == 0 {
+		return []Diagnostic{{File: file, Message: "no YAML document in output"}}
+	}
+
+	diagnostics := []Diagnostic{}
+	names := map[string]int{}
+	for _, doc := range docs {
+		docDiagnostics, promrule := lintDocument(file, doc.line, doc.content)
+		diagnostics = append(diagnostics, docDiagnostics...)
+		if promrule == nil {
+			continue
+		}
+
+		key := prometheusRuleKey(promrule)
+		if line, ok := names[key]; ok {
+			diagnostics = append(diagnostics, Diagnostic{
+				File:    file,
+				Line:    doc.line,
+				Message: fmt.Sprintf("PrometheusRule %s is rendered more than once, first on line %d", key, line),
+			})
+		}
+		names[key] = doc.line
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool { return diagnostics[i].Line < diagnostics[j].Line })
+
+	return diagnostics
+}
+
+// lintDocument
+// - Checks one YAML document starting on line offset, the PrometheusRule is nil when the document isn't one
+func lintDocument(file string, offset int, doc []byte) ([]Diagnostic, *monitoringv1.PrometheusRule) {
+	var root yamlv3.Node
+	if err := yamlv3.Unmarshal(doc, &root); err != nil {
+		return []Diagnostic{{File: file, Line: offset + yamlErrorLine(err), Message: err.Error()}}, nil
+	}
+
+	if len(root.Content) == 0 {
+		// Only comments, the template manager skips it too
+		return nil, nil
+	}
+
+	promrule := &monitoringv1.PrometheusRule{}
+	if err := yaml.UnmarshalStrict(doc, promrule); err != nil {
+		return []Diagnostic{{File: file, Line: offset, Message: fmt.Sprintf("not a valid PrometheusRule: %v", err)}}, nil
+	}
+
+	diagnostics := []Diagnostic{}
+	if promrule.APIVersion != monitoringv1.SchemeGroupVersion.String() || promrule.Kind != monitoringv1.PrometheusRuleKind {
+		diagnostics = append(diagnostics, Diagnostic{
+			File:    file,
+			Line:    offset,
+			Message: fmt.Sprintf("expected %s %s, got %s %s", monitoringv1.SchemeGroupVersion, monitoringv1.PrometheusRuleKind, promrule.APIVersion, promrule.Kind),
+		})
+	}
+
+	for _, ruleErr := range validatePrometheusRule(promrule) {
+		diagnostics = append(diagnostics, Diagnostic{
+			File:    file,
+			Line:    offset + yamlPathLine(&root, ruleErr.path) - 1,
+			Message: ruleErr.Error(),
+		})
+	}
+
+	return diagnostics, promrule
+}
+
+func templateDiagnostic(file string, err error) Diagnostic {
+	diagnostic := Diagnostic{File: file, Message: err.Error()}
+	if match := templateErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		diagnostic.Line, _ = strconv.Atoi(match[1])
+	}
+
+	return diagnostic
+}
+
+// document
+// - A YAML document of a template's output and the line it starts on
+type document struct {
+	line    int
+	content []byte
+}
+
+// documents
+// - Splits rendered into its non-empty YAML documents
+func documents(rendered []byte) []document {
+	lines := strings.SplitAfter(string(rendered), "\n")
+
+	docs := []document{}
+	start := 0
+	for i := 0; i <= len(lines); i++ {
+		if i < len(lines) && strings.TrimRight(lines[i], " \t\r\n") != "---" {
+			continue
+		}
+
+		content := strings.Join(lines[start:i], "")
+		if strings.TrimSpace(content) != "" {
+			docs = append(docs, document{line: start + 1, content: []byte(content)})
+		}
+		start = i + 1
+	}
+
+	return docs
+}
+
+// yamlPathLine
+// - Returns the line of the node at path, such as spec.groups[0].rules[1].expr, or of its closest existing parent
+func yamlPathLine(root *yamlv3.Node, path string) int {
+	node := root
+	if node.Kind == yamlv3.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	line := node.Line
+
+	for _, segment := range strings.Split(path, ".") {
+		key, index := segment, -1
+		if i := strings.Index(segment, "["); i >= 0 && strings.HasSuffix(segment, "]") {
+			key = segment[:i]
+			index, _ = strconv.Atoi(segment[i+1 : len(segment)-1])
+		}
+
+		next := mappingValue(node, key)
+		if next == nil {
+			return line
+		}
+		node, line = next, next.Line
+
+		if index >= 0 {
+			if node.Kind != yamlv3.SequenceNode || index >= len(node.Content) {
+				return line
+			}
+			node, line = node.Content[index], node.Content[index].Line
+		}
+	}
+
+	return line
+}
+
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	if node.Kind != yamlv3.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			// Point at the key, block scalars like expr start on the line after it
+			value := *node.Content[i+1]
+			value.Line = node.Content[i].Line
+			return &value
+		}
+	}
+
+	return nil
+}
+
+var yamlErrorLineRegexp = regexp.MustCompile(`line (\d+)`)
+
+func yamlErrorLine(err error) int {
+	if match := yamlErrorLineRegexp.FindStringSubmatch(err.Error()); match != nil {
+		line, _ := strconv.Atoi(match[1])
+		return line - 1
+	}
+
+	return 0
+}
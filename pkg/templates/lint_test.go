@@ -0,0 +1,36 @@
+package templates
+
+import (
+	"testing"
+	"text/template"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestLintShippedTemplates(t *testing.T) {
+	diagnostics, err := Lint("../../kube/config/templates")
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(diagnostics, 0))
+}
+
+func TestLintBrokenTemplate(t *testing.T) {
+	diagnostics, err := Lint("testdata/lint")
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(diagnostics, 3))
+
+	assert.Equal(t, diagnostics[0].Line, 12)
+	assert.Assert(t, is.Contains(diagnostics[0].Message, "spec.groups[0].rules[0].expr"))
+	assert.Equal(t, diagnostics[1].Line, 14)
+	assert.Assert(t, is.Contains(diagnostics[1].Message, "not a valid duration string"))
+	assert.Equal(t, diagnostics[2].Line, 16)
+	assert.Assert(t, is.Contains(diagnostics[2].Message, `invalid label name "bad-label"`))
+}
+
+func TestLintTemplateSyntax(t *testing.T) {
+	_, err := template.New("broken.tmpl").Parse("{{.Name}}\n{{.Nope}\n")
+	assert.Assert(t, err != nil)
+
+	diagnostic := templateDiagnostic("broken.tmpl", err)
+	assert.Equal(t, diagnostic.Line, 2)
+}
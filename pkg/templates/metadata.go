@@ -0,0 +1,236 @@
+package templates
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"sigs.k8s.io/yaml"
+)
+
+// metadataHeader matches a template's metadata, a comment at the very top of the file starting with "heimdall"
+var metadataHeader = regexp.MustCompile(`(?s)\A\s*\{\{-?\s*/\*[ \t]*heimdall[ \t]*\r?\n(.*?)\*/\s*(-?)\}\}`)
+
+// supportedKinds are the kinds of object templates are rendered for
+var supportedKinds = []string{"Deployment", "Ingress"}
+
+// Parameter types
+const (
+	ParameterString   = "string"
+	ParameterNumber   = "number"
+	ParameterDuration = "duration"
+	ParameterBoolean  = "boolean"
+)
+
+// TemplateMetadata
+// - Declared in a comment at the top of a template, describes what the template is for and the parameters it takes
+// - Templates without metadata can be used on any kind of object with any parameters
+type TemplateMetadata struct {
+	Description string               `json:"description,omitempty"`
+	Kinds       []string             `json:"kinds,omitempty"`
+	Parameters  map[string]Parameter `json:"parameters,omitempty"`
+}
+
+// Parameter
+// - A parameter of a template, set in the annotation value and available to the template in .Params
+type Parameter struct {
+	Type        string      `json:"type,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Min         *float64    `json:"min,omitempty"`
+	Max         *float64    `json:"max,omitempty"`
+	// ExclusiveMin and ExclusiveMax leave min and max themselves out of the range
+	ExclusiveMin bool `json:"exclusiveMin,omitempty"`
+	ExclusiveMax bool `json:"exclusiveMax,omitempty"`
+}
+
+// parseTemplateMetadata
+// - Reads the metadata header of the template file at path, metadata is nil when the template doesn't have one
+// - Also returns the number of lines the header takes out of the rendered output
+func parseTemplateMetadata(path string) (*TemplateMetadata, int, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return parseMetadata(content)
+}
+
+func parseMetadata(content []byte) (*TemplateMetadata, int, error) {
+	match := metadataHeader.FindSubmatchIndex(content)
+	if match == nil {
+		return nil, 0, nil
+	}
+
+	lines := strings.Count(string(content[:match[1]]), "\n")
+	if match[5] > match[4] {
+		// "-}}" trims the whitespace that follows the header too
+		rest := content[match[1]:]
+		lines += strings.Count(string(rest[:len(rest)-len(strings.TrimLeft(string(rest), " \t\r\n"))]), "\n")
+	}
+
+	metadata := &TemplateMetadata{}
+	if err := yaml.UnmarshalStrict(content[match[2]:match[3]], metadata); err != nil {
+		return nil, lines, fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	if err := metadata.validate(); err != nil {
+		return nil, lines, fmt.Errorf("invalid metadata: %v", err)
+	}
+
+	return metadata, lines, nil
+}
+
+func (m *TemplateMetadata) validate() error {
+	for _, kind := range m.Kinds {
+		if !containsString(supportedKinds, kind) {
+			return fmt.Errorf("unsupported kind %q, expected one of %s", kind, strings.Join(supportedKinds, ", "))
+		}
+	}
+
+	for _, name := range m.parameterNames() {
+		parameter := m.Parameters[name]
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid parameter name %q", name)
+		}
+
+		switch parameter.Type {
+		case "":
+			parameter.Type = ParameterString
+			m.Parameters[name] = parameter
+		case ParameterString, ParameterNumber, ParameterDuration, ParameterBoolean:
+		default:
+			return fmt.Errorf("parameter %q: unknown type %q", name, parameter.Type)
+		}
+
+		if (parameter.Min != nil || parameter.Max != nil) && parameter.Type != ParameterNumber {
+			return fmt.Errorf("parameter %q: min and max are only allowed for numbers", name)
+		}
+
+		if parameter.Min != nil && parameter.Max != nil && *parameter.Min > *parameter.Max {
+			return fmt.Errorf("parameter %q: min is greater than max", name)
+		}
+
+		if (parameter.ExclusiveMin && parameter.Min == nil) || (parameter.ExclusiveMax && parameter.Max == nil) {
+			return fmt.Errorf("parameter %q: exclusiveMin and exclusiveMax need min and max", name)
+		}
+
+		if parameter.Default != nil {
+			if err := parameter.check(parameter.Default); err != nil {
+				return fmt.Errorf("parameter %q: default: %v", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// forKind
+// - Whether the template is rendered for objects of kind, templates which don't list their kinds are rendered for all
+func (m *TemplateMetadata) forKind(kind string) bool {
+	return m == nil || len(m.Kinds) == 0 || containsString(m.Kinds, kind)
+}
+
+// apply
+// - Checks an annotation's parameters against the metadata before the template is rendered for an object of kind
+// - Missing parameters are set to their defaults in params
+func (m *TemplateMetadata) apply(kind string, params map[string]interface{}) error {
+	if m == nil {
+		return nil
+	}
+
+	if !m.forKind(kind) {
+		return fmt.Errorf("template is for %s, not %s", strings.Join(m.Kinds, ", "), kind)
+	}
+
+	if len(m.Parameters) == 0 {
+		return nil
+	}
+
+	for name := range params {
+		// A plain annotation value is always the threshold, even for templates which don't declare one
+		if _, ok := m.Parameters[name]; !ok && name != "threshold" {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+
+	for _, name := range m.parameterNames() {
+		parameter := m.Parameters[name]
+
+		value, ok := params[name]
+		if !ok || value == nil {
+			switch {
+			case parameter.Default != nil:
+				params[name] = parameter.Default
+			case parameter.Required:
+				return fmt.Errorf("parameter %q is required", name)
+			}
+			continue
+		}
+
+		if err := parameter.check(value); err != nil {
+			return fmt.Errorf("parameter %q: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// check
+// - Reports whether value is valid for the parameter's type and range
+func (p Parameter) check(value interface{}) error {
+	switch p.Type {
+	case ParameterNumber:
+		f, err := toFloat(value)
+		if err != nil {
+			return err
+		}
+		if p.Min != nil && f < *p.Min {
+			return fmt.Errorf("%v is less than the minimum %v", f, *p.Min)
+		}
+		if p.Min != nil && p.ExclusiveMin && f == *p.Min {
+			return fmt.Errorf("%v has to be greater than %v", f, *p.Min)
+		}
+		if p.Max != nil && f > *p.Max {
+			return fmt.Errorf("%v is greater than the maximum %v", f, *p.Max)
+		}
+		if p.Max != nil && p.ExclusiveMax && f == *p.Max {
+			return fmt.Errorf("%v has to be less than %v", f, *p.Max)
+		}
+	case ParameterDuration:
+		if _, err := model.ParseDuration(toString(value)); err != nil {
+			return err
+		}
+	case ParameterBoolean:
+		if _, err := strconv.ParseBool(toString(value)); err != nil {
+			return fmt.Errorf("%q is not a boolean", toString(value))
+		}
+	}
+
+	return nil
+}
+
+func (m *TemplateMetadata) parameterNames() []string {
+	names := make([]string, 0, len(m.Parameters))
+	for name := range m.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func containsString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package templates
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestParseMetadata(t *testing.T) {
+	content := `{{- /* heimdall
+description: Test template
+kinds: [Ingress]
+parameters:
+  threshold:
+    type: number
+    required: true
+    min: 0
+    max: 1
+  for:
+    type: duration
+    default: 5m
+  severity: {}
+*/ -}}
+---
+kind: PrometheusRule
+`
+	metadata, lines, err := parseMetadata([]byte(content))
+	assert.NilError(t, err)
+	assert.Equal(t, lines, 14)
+	assert.Equal(t, metadata.Description, "Test template")
+	assert.DeepEqual(t, metadata.Kinds, []string{"Ingress"})
+	assert.Equal(t, metadata.Parameters["threshold"].Type, ParameterNumber)
+	assert.Equal(t, *metadata.Parameters["threshold"].Max, 1.0)
+	assert.Equal(t, metadata.Parameters["for"].Default, "5m")
+	assert.Equal(t, metadata.Parameters["severity"].Type, ParameterString)
+
+	metadata, lines, err = parseMetadata([]byte("---\nkind: PrometheusRule\n"))
+	assert.NilError(t, err)
+	assert.Assert(t, is.Nil(metadata))
+	assert.Equal(t, lines, 0)
+}
+
+func TestParseMetadataInvalid(t *testing.T) {
+	tests := []struct {
+		header string
+		err    string
+	}{
+		{"kinds: [Service]", `unsupported kind "Service"`},
+		{"parameters:\n  threshold:\n    type: float", `unknown type "float"`},
+		{"parameters:\n  for:\n    type: duration\n    min: 1", "min and max are only allowed for numbers"},
+		{"parameters:\n  threshold:\n    type: number\n    min: 1\n    max: 0", "min is greater than max"},
+		{"parameters:\n  threshold:\n    type: number\n    max: 1\n    default: 2", "default: 2 is greater than the maximum 1"},
+		{"descripton: typo", "unknown field"},
+	}
+
+	for _, test := range tests {
+		_, _, err := parseMetadata([]byte("{{/* heimdall\n" + test.header + "\n*/}}\n"))
+		assert.Check(t, is.ErrorContains(err, test.err), test.header)
+	}
+}
+
+func TestMetadataApply(t *testing.T) {
+	min, max := 0.0, 1.0
+	metadata := &TemplateMetadata{
+		Kinds: []string{"Ingress"},
+		Parameters: map[string]Parameter{
+			"threshold": {Type: ParameterNumber, Required: true, Min: &min, Max: &max},
+			"for":       {Type: ParameterDuration, Default: "5m"},
+			"page":      {Type: ParameterBoolean},
+		},
+	}
+
+	params := map[string]interface{}{"threshold": "0.1"}
+	assert.NilError(t, metadata.apply("Ingress", params))
+	assert.DeepEqual(t, params, map[string]interface{}{"threshold": "0.1", "for": "5m"})
+
+	tests := []struct {
+		kind   string
+		params map[string]interface{}
+		err    string
+	}{
+		{"Deployment", map[string]interface{}{"threshold": "0.1"}, "template is for Ingress, not Deployment"},
+		{"Ingress", map[string]interface{}{}, `parameter "threshold" is required`},
+		{"Ingress", map[string]interface{}{"threshold": "web"}, `"web" is not a number`},
+		{"Ingress", map[string]interface{}{"threshold": 1.5}, "1.5 is greater than the maximum 1"},
+		{"Ingress", map[string]interface{}{"threshold": -1}, "-1 is less than the minimum 0"},
+		{"Ingress", map[string]interface{}{"threshold": 0.1, "for": "soon"}, `parameter "for"`},
+		{"Ingress", map[string]interface{}{"threshold": 0.1, "page": "maybe"}, `"maybe" is not a boolean`},
+		{"Ingress", map[string]interface{}{"threshold": 0.1, "severity": "page"}, `unknown parameter "severity"`},
+	}
+
+	for _, test := range tests {
+		assert.Check(t, is.ErrorContains(metadata.apply(test.kind, test.params), test.err), test.params)
+	}
+
+	// An objective of 100% leaves no error budget
+	objective := &TemplateMetadata{Parameters: map[string]Parameter{
+		"threshold": {Type: ParameterNumber, Min: &min, Max: &max, ExclusiveMin: true, ExclusiveMax: true},
+	}}
+	assert.NilError(t, objective.apply("Ingress", map[string]interface{}{"threshold": 0.5}))
+	assert.Check(t, is.ErrorContains(objective.apply("Ingress", map[string]interface{}{"threshold": 1}), "1 has to be less than 1"))
+	assert.Check(t, is.ErrorContains(objective.apply("Ingress", map[string]interface{}{"threshold": 0}), "0 has to be greater than 0"))
+
+	var none *TemplateMetadata
+	assert.NilError(t, none.apply("Deployment", map[string]interface{}{"anything": "goes"}))
+}
@@ -0,0 +1,320 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/uswitch/heimdall/pkg/log"
+	"github.com/uswitch/heimdall/pkg/sentryclient"
+	apps "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubeyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultMetricsPath = "/metrics"
+
+// templateParameterMonitor
+// - struct passed to each monitor template
+type templateParameterMonitor struct {
+	Identifier          string
+	Namespace           string
+	Name                string
+	NamespacePrometheus string
+	Value               string
+	// Port is the port from the annotation, a number or a name
+	Port string
+	// PortName is the name of the pod or Service port Port refers to, empty when it has no name
+	PortName string
+	Path     string
+	// Selector selects the Deployment's pods, Labels are the object's labels which a ServiceMonitor selects a Service by
+	Selector    map[string]string
+	Labels      map[string]string
+	Owner       string
+	Environment string
+	Criticality string
+	Sensitivity string
+	Meta        map[string]string
+	Deployment  *apps.Deployment
+	Service     *corev1.Service
+}
+
+// Monitors
+// - The ServiceMonitors and PodMonitors rendered for an object
+type Monitors struct {
+	ServiceMonitors []*monitoringv1.ServiceMonitor
+	PodMonitors     []*monitoringv1.PodMonitor
+	// Invalid holds the keys, Kind/namespace/name, of monitors which failed to render, whose existing objects should be kept
+	Invalid map[string]bool
+}
+
+// LoadMonitorTemplates
+// - Loads the ServiceMonitor and PodMonitor templates in directory, selected by <prefix>-monitor/<template> annotations, com.uswitch.heimdall-monitor unless the prefix is set
+func (a *PrometheusRuleTemplateManager) LoadMonitorTemplates(directory string) error {
+	files, err := filepath.Glob(directory + "/*.tmpl")
+	if err != nil {
+		sentryclient.SentryErr(err)
+		return err
+	}
+
+	monitors := map[string]*template.Template{}
+	for _, file := range files {
+		tmpl, err := parseTemplate(file)
+		if err != nil {
+			sentryclient.SentryErr(err)
+			return err
+		}
+		monitors[templateName(file)] = tmpl
+	}
+
+	if len(monitors) == 0 {
+		return fmt.Errorf("no monitor templates defined")
+	}
+
+	a.monitors = monitors
+	return nil
+}
+
+// MonitorTemplates
+// - Whether monitor templates are loaded
+func (a *PrometheusRuleTemplateManager) MonitorTemplates() bool {
+	return len(a.monitors) != 0
+}
+
+// MonitorKey
+// - Identifies a monitor of either kind, Kind/namespace/name
+func MonitorKey(kind string, meta metav1.Object) string {
+	return kind + "/" + meta.GetNamespace() + "/" + meta.GetName()
+}
+
+// parseMonitorValue
+// - Splits a monitor annotation value into the port and path, "8080/metrics" => 8080, /metrics
+// - The path defaults to /metrics, the port can be a number or a port name
+func parseMonitorValue(value string) (port, path string, err error) {
+	value = strings.TrimSpace(value)
+	port, path = value, defaultMetricsPath
+	if i := strings.Index(value, "/"); i >= 0 {
+		port, path = value[:i], value[i:]
+	}
+
+	if port == "" {
+		return "", "", fmt.Errorf("no port in \"%s\", expected <port>[/<path>]", value)
+	}
+
+	return port, path, nil
+}
+
+// containerPortName
+// - The name of the container port in the pod template port refers to, by number or name
+func containerPortName(spec corev1.PodSpec, port string) string {
+	for _, container := range spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name != "" && (containerPort.Name == port || strconv.Itoa(int(containerPort.ContainerPort)) == port) {
+				return containerPort.Name
+			}
+		}
+	}
+
+	return ""
+}
+
+// servicePortName
+// - The name of the Service port port refers to, by name, port or target port
+func servicePortName(service *corev1.Service, port string) string {
+	for _, servicePort := range service.Spec.Ports {
+		if servicePort.Name != "" && (servicePort.Name == port || strconv.Itoa(int(servicePort.Port)) == port || servicePort.TargetPort.String() == port) {
+			return servicePort.Name
+		}
+	}
+
+	return ""
+}
+
+// CreateMonitorsFromDeployment
+// - Renders the monitors for every <prefix>-monitor annotation on a Deployment, usually PodMonitors
+func (a *PrometheusRuleTemplateManager) CreateMonitorsFromDeployment(deployment *apps.Deployment, depNamespacePrometheus string) *Monitors {
+	meta := a.objectMetadata(deployment)
+	params := &templateParameterMonitor{
+		Identifier:          fmt.Sprintf("%s.%s", deployment.Namespace, deployment.Name),
+		Namespace:           deployment.Namespace,
+		Name:                deployment.Name,
+		NamespacePrometheus: depNamespacePrometheus,
+		Labels:              deployment.Labels,
+		Owner:               meta["owner"],
+		Environment:         meta["environment"],
+		Criticality:         meta["criticality"],
+		Sensitivity:         meta["sensitivity"],
+		Meta:                meta,
+		Deployment:          deployment,
+	}
+	if deployment.Spec.Selector != nil {
+		params.Selector = deployment.Spec.Selector.MatchLabels
+	}
+
+	return a.createMonitors(deployment, apps.SchemeGroupVersion.WithKind("Deployment"), params, func(port string) string {
+		return containerPortName(deployment.Spec.Template.Spec, port)
+	})
+}
+
+// CreateMonitorsFromService
+// - Renders the monitors for every <prefix>-monitor annotation on a Service, usually ServiceMonitors
+func (a *PrometheusRuleTemplateManager) CreateMonitorsFromService(service *corev1.Service, namespacePrometheus string) *Monitors {
+	meta := a.objectMetadata(service)
+	params := &templateParameterMonitor{
+		Identifier:          fmt.Sprintf("%s.%s", service.Namespace, service.Name),
+		Namespace:           service.Namespace,
+		Name:                service.Name,
+		NamespacePrometheus: namespacePrometheus,
+		Selector:            service.Spec.Selector,
+		Labels:              service.Labels,
+		Owner:               meta["owner"],
+		Environment:         meta["environment"],
+		Criticality:         meta["criticality"],
+		Sensitivity:         meta["sensitivity"],
+		Meta:                meta,
+		Service:             service,
+	}
+
+	return a.createMonitors(service, corev1.SchemeGroupVersion.WithKind("Service"), params, func(port string) string {
+		return servicePortName(service, port)
+	})
+}
+
+func (a *PrometheusRuleTemplateManager) createMonitors(obj metav1.Object, gvk schema.GroupVersionKind, params *templateParameterMonitor, portName func(string) string) *Monitors {
+	kind := strings.ToLower(gvk.Kind)
+	logger := log.Sugar.With("name", obj.GetName(), "namespace", obj.GetNamespace(), "kind", gvk.Kind)
+	warn := func(format string, args ...interface{}) {
+		warnMessage := fmt.Sprintf("[%s][%s] ", kind, params.Identifier) + fmt.Sprintf(format, args...)
+		logger.Warnf(warnMessage)
+		sentryclient.SentryMessage(warnMessage)
+	}
+
+	monitors := &Monitors{
+		ServiceMonitors: []*monitoringv1.ServiceMonitor{},
+		PodMonitors:     []*monitoringv1.PodMonitor{},
+		Invalid:         map[string]bool{},
+	}
+	rendered := map[string]bool{}
+	annotations := obj.GetAnnotations()
+	// Monitor templates are selected by <prefix>-monitor annotations, com.uswitch.heimdall-monitor/http-metrics: "8080/metrics"
+	monitorPrefix := a.prefix + "-monitor"
+
+	for _, k := range sortedKeys(annotations) {
+		if !strings.HasPrefix(k, monitorPrefix+"/") {
+			continue
+		}
+		templateName := strings.TrimPrefix(k, monitorPrefix+"/")
+
+		tmpl, ok := a.monitors[templateName]
+		if !ok {
+			warn("no monitor template for \"%s\"", templateName)
+			continue
+		}
+
+		port, path, err := parseMonitorValue(annotations[k])
+		if err != nil {
+			warn("error parsing annotation \"%s\": %s", k, err)
+			continue
+		}
+
+		params.Value = annotations[k]
+		params.Port = port
+		params.PortName = portName(port)
+		params.Path = path
+		var result bytes.Buffer
+		if err := tmpl.Execute(&result, params); err != nil {
+			warn("error executing monitor template \"%s\": %s", templateName, err)
+			continue
+		}
+
+		decoded, err := decodeMonitors(&result)
+		if err != nil {
+			warn("error parsing YAML from monitor template \"%s\": %s", templateName, err)
+			continue
+		}
+
+		ownerRef := *metav1.NewControllerRef(obj, gvk)
+		for _, monitor := range decoded {
+			meta := monitor.(metav1.Object)
+			if meta.GetNamespace() == "" {
+				meta.SetNamespace(obj.GetNamespace())
+			}
+			monitorKind := monitor.GetObjectKind().GroupVersionKind().Kind
+			key := MonitorKey(monitorKind, meta)
+
+			// Owner references only work within a namespace
+			if meta.GetNamespace() != obj.GetNamespace() {
+				warn("%s from monitor template \"%s\" isn't in the %s's namespace %s", key, templateName, kind, obj.GetNamespace())
+				monitors.Invalid[key] = true
+				continue
+			}
+
+			if rendered[key] {
+				warn("annotation \"%s\" renders %s which an earlier annotation already rendered", k, key)
+				continue
+			}
+			rendered[key] = true
+
+			meta.SetOwnerReferences([]metav1.OwnerReference{ownerRef})
+			a.MarkGenerated(meta)
+			switch m := monitor.(type) {
+			case *monitoringv1.ServiceMonitor:
+				monitors.ServiceMonitors = append(monitors.ServiceMonitors, m)
+			case *monitoringv1.PodMonitor:
+				monitors.PodMonitors = append(monitors.PodMonitors, m)
+			}
+		}
+	}
+
+	return monitors
+}
+
+// decodeMonitors
+// - Decodes every YAML document in a monitor template's output into a ServiceMonitor or PodMonitor, by its kind
+// - Empty documents are skipped
+func decodeMonitors(rendered io.Reader) ([]runtime.Object, error) {
+	decoder := kubeyaml.NewYAMLOrJSONDecoder(rendered, 1024)
+
+	monitors := []runtime.Object{}
+	for {
+		var document map[string]interface{}
+		if err := decoder.Decode(&document); err != nil {
+			if err == io.EOF {
+				return monitors, nil
+			}
+			return nil, err
+		}
+		if len(document) == 0 {
+			continue
+		}
+
+		content, err := yaml.Marshal(document)
+		if err != nil {
+			return nil, err
+		}
+
+		var monitor runtime.Object
+		switch kind := document["kind"]; kind {
+		case monitoringv1.ServiceMonitorsKind:
+			monitor = &monitoringv1.ServiceMonitor{}
+		case monitoringv1.PodMonitorsKind:
+			monitor = &monitoringv1.PodMonitor{}
+		default:
+			return nil, fmt.Errorf("unsupported kind %v, monitor templates render ServiceMonitors and PodMonitors", kind)
+		}
+
+		if err := yaml.UnmarshalStrict(content, monitor); err != nil {
+			return nil, err
+		}
+		monitors = append(monitors, monitor)
+	}
+}
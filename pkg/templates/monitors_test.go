@@ -0,0 +1,165 @@
+package templates
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testMonitorManager(t *testing.T, directory string) *PrometheusRuleTemplateManager {
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, !template.MonitorTemplates())
+	assert.Assert(t, is.Nil(template.LoadMonitorTemplates(directory)))
+	assert.Assert(t, template.MonitorTemplates())
+
+	return template
+}
+
+func TestParseMonitorValue(t *testing.T) {
+	tests := []struct {
+		value, port, path string
+	}{
+		{"8080/metrics", "8080", "/metrics"},
+		{"8080", "8080", "/metrics"},
+		{"http-metrics/internal/metrics", "http-metrics", "/internal/metrics"},
+	}
+
+	for _, test := range tests {
+		port, path, err := parseMonitorValue(test.value)
+		assert.Assert(t, is.Nil(err))
+		assert.Equal(t, port, test.port)
+		assert.Equal(t, path, test.path)
+	}
+
+	_, _, err := parseMonitorValue("/metrics")
+	assert.ErrorContains(t, err, "no port")
+}
+
+func TestDeploymentMonitors(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template := testMonitorManager(t, "../../kube/config/monitors")
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "shop",
+			UID:         "deployment-uid",
+			Annotations: map[string]string{"com.uswitch.heimdall-monitor/http-metrics": "8080/metrics"},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: 8080}}}},
+				},
+			},
+		},
+	}
+
+	monitors := template.CreateMonitorsFromDeployment(deployment, "kube-system")
+	assert.Assert(t, is.Len(monitors.Invalid, 0))
+	assert.Assert(t, is.Len(monitors.ServiceMonitors, 0))
+	assert.Assert(t, is.Len(monitors.PodMonitors, 1))
+
+	podMonitor := monitors.PodMonitors[0]
+	assert.Equal(t, podMonitor.Name, "web-http-metrics")
+	assert.Equal(t, podMonitor.Namespace, "shop")
+	assert.Equal(t, podMonitor.Labels["prometheus"], "kube-system")
+	assert.Equal(t, podMonitor.OwnerReferences[0].UID, deployment.UID)
+	assert.DeepEqual(t, podMonitor.Spec.Selector.MatchLabels, map[string]string{"app": "web"})
+	assert.Equal(t, podMonitor.Spec.PodMetricsEndpoints[0].Port, "metrics")
+	assert.Equal(t, podMonitor.Spec.PodMetricsEndpoints[0].Path, "/metrics")
+
+	// A port without a name is scraped by number
+	deployment.Spec.Template.Spec.Containers[0].Ports[0].Name = ""
+	monitors = template.CreateMonitorsFromDeployment(deployment, "")
+	endpoint := monitors.PodMonitors[0].Spec.PodMetricsEndpoints[0]
+	assert.Equal(t, endpoint.Port, "")
+	assert.DeepEqual(t, endpoint.TargetPort, &intstr.IntOrString{Type: intstr.Int, IntVal: 8080})
+	assert.Assert(t, is.Len(monitors.PodMonitors[0].Labels, 0))
+}
+
+func TestServiceMonitors(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template := testMonitorManager(t, "../../kube/config/monitors")
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "shop",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"com.uswitch.heimdall-monitor/http-metrics": "9102"},
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080)},
+				{Name: "metrics", Port: 9102, TargetPort: intstr.FromInt(9102)},
+			},
+		},
+	}
+
+	monitors := template.CreateMonitorsFromService(service, "")
+	assert.Assert(t, is.Len(monitors.PodMonitors, 0))
+	assert.Assert(t, is.Len(monitors.ServiceMonitors, 1))
+
+	serviceMonitor := monitors.ServiceMonitors[0]
+	assert.Equal(t, serviceMonitor.Name, "web-http-metrics")
+	assert.DeepEqual(t, serviceMonitor.Spec.Selector.MatchLabels, map[string]string{"app": "web"})
+	assert.Equal(t, serviceMonitor.Spec.Endpoints[0].Port, "metrics")
+	assert.Equal(t, serviceMonitor.Spec.Endpoints[0].Path, "/metrics")
+
+	// A ServiceMonitor can't select a Service without labels
+	service.Labels = nil
+	monitors = template.CreateMonitorsFromService(service, "")
+	assert.Assert(t, is.Len(monitors.ServiceMonitors, 0))
+}
+
+func TestInvalidMonitors(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	directory := t.TempDir()
+	assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, "other-namespace.tmpl"), []byte(`
+apiVersion: monitoring.coreos.com/v1
+kind: ServiceMonitor
+metadata:
+  name: {{.Name}}
+  namespace: monitoring
+`), 0644)))
+	assert.Assert(t, is.Nil(os.WriteFile(filepath.Join(directory, "other-kind.tmpl"), []byte(`
+apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: {{.Name}}
+`), 0644)))
+
+	template := testMonitorManager(t, directory)
+
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "web",
+			Namespace: "shop",
+			Annotations: map[string]string{
+				"com.uswitch.heimdall-monitor/other-namespace": "8080",
+				"com.uswitch.heimdall-monitor/other-kind":      "8080",
+				"com.uswitch.heimdall-monitor/unknown":         "8080",
+			},
+		},
+	}
+
+	monitors := template.CreateMonitorsFromService(service, "")
+	assert.Assert(t, is.Len(monitors.ServiceMonitors, 0))
+	assert.Assert(t, is.Len(monitors.PodMonitors, 0))
+	assert.DeepEqual(t, monitors.Invalid, map[string]bool{"ServiceMonitor/monitoring/web": true})
+}
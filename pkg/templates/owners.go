@@ -0,0 +1,147 @@
+package templates
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kubeinformers "k8s.io/client-go/informers"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ObjectLister
+// - Looks up the objects owner resolution follows from an Ingress's Service through its Pods and ReplicaSets to the Deployment, and the Namespace owner metadata is inherited from
+type ObjectLister interface {
+	Namespace(name string) (*corev1.Namespace, error)
+	Service(namespace, name string) (*corev1.Service, error)
+	Pods(namespace string, selector labels.Selector) ([]metav1.Object, error)
+	ReplicaSet(namespace, name string) (metav1.Object, error)
+	Deployment(namespace, name string) (metav1.Object, error)
+}
+
+// SetObjectLister
+// - Resolves owners with lister instead of requests to the API server
+func (a *PrometheusRuleTemplateManager) SetObjectLister(lister ObjectLister) {
+	a.objects = lister
+}
+
+// clientObjectLister
+// - Looks objects up with requests to the API server, used when there are no informers such as by `heimdall render`
+type clientObjectLister struct {
+	clientSet ClientSetI
+}
+
+func (l *clientObjectLister) Namespace(name string) (*corev1.Namespace, error) {
+	return l.clientSet.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func (l *clientObjectLister) Service(namespace, name string) (*corev1.Service, error) {
+	return l.clientSet.CoreV1().Services(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func (l *clientObjectLister) Pods(namespace string, selector labels.Selector) ([]metav1.Object, error) {
+	pods, err := l.clientSet.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []metav1.Object{}
+	for i := range pods.Items {
+		objects = append(objects, &pods.Items[i])
+	}
+
+	return objects, nil
+}
+
+func (l *clientObjectLister) ReplicaSet(namespace, name string) (metav1.Object, error) {
+	return l.clientSet.AppsV1().ReplicaSets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+func (l *clientObjectLister) Deployment(namespace, name string) (metav1.Object, error) {
+	return l.clientSet.AppsV1().Deployments(namespace).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+// InformerObjectLister
+// - Looks objects up in shared informer caches, Pods only by their metadata
+type InformerObjectLister struct {
+	namespaces  corelisters.NamespaceLister
+	services    corelisters.ServiceLister
+	pods        cache.GenericLister
+	replicaSets appslisters.ReplicaSetLister
+	deployments appslisters.DeploymentLister
+
+	synced []cache.InformerSynced
+}
+
+// NewInformerObjectLister
+// - Registers the Namespace, Service, ReplicaSet and Deployment informers with kubeInformerFactory and the Pod informer with metadataInformerFactory
+// - The factories have to be started after it's created
+func NewInformerObjectLister(kubeInformerFactory kubeinformers.SharedInformerFactory, metadataInformerFactory metadatainformer.SharedInformerFactory) *InformerObjectLister {
+	namespaceInformer := kubeInformerFactory.Core().V1().Namespaces()
+	serviceInformer := kubeInformerFactory.Core().V1().Services()
+	podInformer := metadataInformerFactory.ForResource(corev1.SchemeGroupVersion.WithResource("pods"))
+	replicaSetInformer := kubeInformerFactory.Apps().V1().ReplicaSets()
+	deploymentInformer := kubeInformerFactory.Apps().V1().Deployments()
+
+	return &InformerObjectLister{
+		namespaces:  namespaceInformer.Lister(),
+		services:    serviceInformer.Lister(),
+		pods:        podInformer.Lister(),
+		replicaSets: replicaSetInformer.Lister(),
+		deployments: deploymentInformer.Lister(),
+
+		synced: []cache.InformerSynced{
+			namespaceInformer.Informer().HasSynced,
+			serviceInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+			replicaSetInformer.Informer().HasSynced,
+			deploymentInformer.Informer().HasSynced,
+		},
+	}
+}
+
+// HasSynced
+// - Whether every informer has synced, owners resolved before then may be missing
+func (l *InformerObjectLister) HasSynced() bool {
+	for _, synced := range l.synced {
+		if !synced() {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *InformerObjectLister) Namespace(name string) (*corev1.Namespace, error) {
+	return l.namespaces.Get(name)
+}
+
+func (l *InformerObjectLister) Service(namespace, name string) (*corev1.Service, error) {
+	return l.services.Services(namespace).Get(name)
+}
+
+func (l *InformerObjectLister) Pods(namespace string, selector labels.Selector) ([]metav1.Object, error) {
+	pods, err := l.pods.ByNamespace(namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []metav1.Object{}
+	for _, pod := range pods {
+		objects = append(objects, pod.(metav1.Object))
+	}
+
+	return objects, nil
+}
+
+func (l *InformerObjectLister) ReplicaSet(namespace, name string) (metav1.Object, error) {
+	return l.replicaSets.ReplicaSets(namespace).Get(name)
+}
+
+func (l *InformerObjectLister) Deployment(namespace, name string) (metav1.Object, error) {
+	return l.deployments.Deployments(namespace).Get(name)
+}
@@ -0,0 +1,116 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// testInformerObjectLister
+// - An InformerObjectLister over fake clients holding the test Service, Pod, ReplicaSet and Deployment, synced
+func testInformerObjectLister(t testing.TB, client *fake.Clientset) *InformerObjectLister {
+	scheme := runtime.NewScheme()
+	assert.Assert(t, is.Nil(metav1.AddMetaToScheme(scheme)))
+	podMetadata := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: testPod.ObjectMeta,
+	}
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme, podMetadata)
+
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(client, 0)
+	metadataInformerFactory := metadatainformer.NewSharedInformerFactory(metadataClient, 0)
+	lister := NewInformerObjectLister(kubeInformerFactory, metadataInformerFactory)
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	kubeInformerFactory.Start(stopCh)
+	metadataInformerFactory.Start(stopCh)
+	assert.Assert(t, cache.WaitForCacheSync(stopCh, lister.HasSynced))
+
+	return lister
+}
+
+func TestInformerObjectLister(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(testService, testDeployment, testReplicaset, testPod)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	template.SetObjectLister(testInformerObjectLister(t, client))
+	client.ClearActions()
+
+	promrules, err := template.CreateFromIngress(testIngressRuleBackend)
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, promrules[0].Spec.Groups[0].Rules[1].Labels["owner"], "testDeploymentOwner")
+
+	// The owner came from the caches
+	assert.Assert(t, is.Len(client.Actions(), 0))
+
+	// A Service which isn't in the cache is reported like one which doesn't exist
+	_, err = template.findServiceDeployment("missing", "testNamespace")
+	assert.ErrorContains(t, err, "not found")
+}
+
+func TestOwnerResolvedOnce(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(testService, testDeployment, testReplicaset, testPod)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	ingress := testIngressRuleBackend.DeepCopy()
+	ingress.Annotations["com.uswitch.heimdall/5xx-rate.page"] = "0.01"
+	ingress.Annotations["com.uswitch.heimdall/slo-availability"] = "99.9"
+
+	_, err = template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+
+	// A Service GET, a Pod LIST, a ReplicaSet GET, a Deployment GET and a Namespace GET for all three annotations
+	assert.Assert(t, is.Len(client.Actions(), 5))
+}
+
+// BenchmarkIngressOwnerResolution
+// - Renders an Ingress with three annotations resolving its owner with requests and from informer caches, reporting the requests made per render
+func BenchmarkIngressOwnerResolution(b *testing.B) {
+	log.Setup(log.INFO_LEVEL)
+
+	ingress := testIngressRuleBackend.DeepCopy()
+	ingress.Annotations["com.uswitch.heimdall/5xx-rate.page"] = "0.01"
+	ingress.Annotations["com.uswitch.heimdall/slo-availability"] = "99.9"
+
+	for _, informers := range []bool{false, true} {
+		name := "client"
+		if informers {
+			name = "informer"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			client := fake.NewSimpleClientset(testService, testDeployment, testReplicaset, testPod)
+			template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+			assert.Assert(b, is.Nil(err))
+			if informers {
+				template.SetObjectLister(testInformerObjectLister(b, client))
+			}
+
+			requests := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				client.ClearActions()
+				if _, err := template.CreateFromIngress(ingress); err != nil {
+					b.Fatal(err)
+				}
+				requests += len(client.Actions())
+			}
+			b.ReportMetric(float64(requests)/float64(b.N), "requests/op")
+		})
+	}
+}
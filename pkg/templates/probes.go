@@ -0,0 +1,207 @@
+package templates
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	probeEnabledAnnotation        = "enabled"
+	probeTLSAnnotation            = "tls"
+	probeExpectedStatusAnnotation = "expected-status"
+	probeModuleAnnotation         = "module"
+)
+
+// ProbeParameters
+// - The Probe Heimdall generates for an Ingress, available to templates in .Probe and nil when there isn't one
+type ProbeParameters struct {
+	Name    string
+	Module  string
+	Targets []string
+	// ExpectedStatus is the HTTP status the hosts should answer with, empty when any status the module accepts will do
+	ExpectedStatus string
+}
+
+// SetProber
+// - The blackbox exporter Probes use, host:port, and the module they use unless an Ingress sets one
+// - Probes are only generated when the prober is set
+func (a *PrometheusRuleTemplateManager) SetProber(proberURL, module string, labels map[string]string) {
+	a.proberURL = proberURL
+	a.proberModule = module
+	a.probeLabels = labels
+}
+
+// Probes
+// - Whether Probes are generated
+func (a *PrometheusRuleTemplateManager) Probes() bool {
+	return a.proberURL != ""
+}
+
+// ingressHosts
+// - The hosts of the Ingress's rules in order, without duplicates, wildcard hosts can't be probed and are left out
+func ingressHosts(ingress *networkingv1.Ingress) []string {
+	hosts := []string{}
+	seen := map[string]bool{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" || strings.HasPrefix(rule.Host, "*") || seen[rule.Host] {
+			continue
+		}
+		seen[rule.Host] = true
+		hosts = append(hosts, rule.Host)
+	}
+
+	return hosts
+}
+
+// probeParameters
+// - Reads the <prefix>-probe annotations, nil when Probes aren't generated or the Ingress doesn't enable one
+func (a *PrometheusRuleTemplateManager) probeParameters(ingress *networkingv1.Ingress) (*ProbeParameters, error) {
+	annotations := ingress.GetAnnotations()
+	probePrefix := a.prefix + "-probe"
+	if !a.Probes() || annotations[probePrefix+"/"+probeEnabledAnnotation] != "true" {
+		return nil, nil
+	}
+
+	var tls *bool
+	if value, ok := annotations[probePrefix+"/"+probeTLSAnnotation]; ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s/%s annotation \"%s\": %v", probePrefix, probeTLSAnnotation, value, err)
+		}
+		tls = &parsed
+	}
+
+	expectedStatus := annotations[probePrefix+"/"+probeExpectedStatusAnnotation]
+	if expectedStatus != "" {
+		if status, err := strconv.Atoi(expectedStatus); err != nil || status < 100 || status > 599 {
+			return nil, fmt.Errorf("invalid %s/%s annotation \"%s\": not an HTTP status", probePrefix, probeExpectedStatusAnnotation, expectedStatus)
+		}
+	}
+
+	module := a.proberModule
+	if value := annotations[probePrefix+"/"+probeModuleAnnotation]; value != "" {
+		module = value
+	}
+
+	targets := probeTargets(ingress, tls)
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no hosts to probe in the Ingress's rules")
+	}
+
+	return &ProbeParameters{
+		Name:           ingress.Name + "-probe",
+		Module:         module,
+		Targets:        targets,
+		ExpectedStatus: expectedStatus,
+	}, nil
+}
+
+// probeTargets
+// - A URL for every host and path in the Ingress's rules, https when the host is in the Ingress's TLS section unless tls overrides it
+// - Paths which look like regular expressions are probed at the nearest plain prefix
+func probeTargets(ingress *networkingv1.Ingress, tls *bool) []string {
+	tlsHosts := map[string]bool{}
+	for _, ingressTLS := range ingress.Spec.TLS {
+		for _, host := range ingressTLS.Hosts {
+			tlsHosts[host] = true
+		}
+	}
+
+	targets := []string{}
+	seen := map[string]bool{}
+	for _, host := range ingressHosts(ingress) {
+		scheme := "http"
+		if (tls == nil && tlsHosts[host]) || (tls != nil && *tls) {
+			scheme = "https"
+		}
+
+		paths := []string{}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != host || rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				paths = append(paths, probePath(path.Path))
+			}
+		}
+		if len(paths) == 0 {
+			paths = append(paths, "/")
+		}
+
+		for _, path := range paths {
+			target := (&url.URL{Scheme: scheme, Host: host, Path: path}).String()
+			if !seen[target] {
+				seen[target] = true
+				targets = append(targets, target)
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	return targets
+}
+
+func probePath(path string) string {
+	if i := strings.IndexAny(path, "()[]{}*+?^$|\\"); i >= 0 {
+		path = path[:i]
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return path
+}
+
+// CreateProbeFromIngress
+// - The Probe for an Ingress which enables one, nil when it doesn't
+// - Its series are labelled with the Ingress's namespace and name, which the probe-failure template alerts on
+func (a *PrometheusRuleTemplateManager) CreateProbeFromIngress(ingress *networkingv1.Ingress) (*monitoringv1.Probe, error) {
+	params, err := a.probeParameters(ingress)
+	if err != nil || params == nil {
+		return nil, err
+	}
+
+	labels := map[string]string{}
+	for k, v := range a.probeLabels {
+		labels[k] = v
+	}
+
+	probe := &monitoringv1.Probe{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1.SchemeGroupVersion.String(),
+			Kind:       monitoringv1.ProbesKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      params.Name,
+			Namespace: ingress.Namespace,
+			Labels:    labels,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(ingress, networkingv1.SchemeGroupVersion.WithKind("Ingress")),
+			},
+		},
+		Spec: monitoringv1.ProbeSpec{
+			JobName:    "heimdall-probe",
+			ProberSpec: monitoringv1.ProberSpec{URL: a.proberURL},
+			Module:     params.Module,
+			Targets: monitoringv1.ProbeTargets{
+				StaticConfig: &monitoringv1.ProbeTargetStaticConfig{
+					Targets: params.Targets,
+					Labels: map[string]string{
+						"namespace": ingress.Namespace,
+						"ingress":   ingress.Name,
+					},
+				},
+			},
+		},
+	}
+	a.MarkGenerated(probe)
+
+	return probe, nil
+}
@@ -0,0 +1,145 @@
+package templates
+
+import (
+	"testing"
+
+	"github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testProbeIngress(annotations map[string]string) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   "shop",
+			UID:         "ingress-uid",
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"shop.example.com"}}},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "shop.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: []networkingv1.HTTPIngressPath{{Path: "/basket"}, {Path: "/api/(v1|v2)"}},
+					}},
+				},
+				{Host: "status.example.com"},
+				{Host: "*.example.com"},
+				{Host: "shop.example.com"},
+			},
+		},
+	}
+}
+
+func TestIngressHosts(t *testing.T) {
+	assert.DeepEqual(t, ingressHosts(testProbeIngress(nil)), []string{"shop.example.com", "status.example.com"})
+	assert.DeepEqual(t, ingressHosts(testIngressDefaultBackend), []string{})
+}
+
+func TestCreateProbeFromIngress(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+
+	ingress := testProbeIngress(map[string]string{"com.uswitch.heimdall-probe/enabled": "true"})
+
+	// Probes aren't generated without a prober
+	probe, err := template.CreateProbeFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Nil(probe))
+
+	template.SetProber("blackbox-exporter:9115", "http_2xx", map[string]string{"release": "prometheus"})
+	assert.Assert(t, template.Probes())
+
+	probe, err = template.CreateProbeFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, probe.Name, "web-probe")
+	assert.Equal(t, probe.Namespace, "shop")
+	assert.DeepEqual(t, probe.Labels, map[string]string{"release": "prometheus"})
+	assert.Equal(t, probe.OwnerReferences[0].UID, ingress.UID)
+	assert.Equal(t, probe.Spec.ProberSpec.URL, "blackbox-exporter:9115")
+	assert.Equal(t, probe.Spec.Module, "http_2xx")
+	assert.DeepEqual(t, probe.Spec.Targets.StaticConfig.Targets, []string{
+		"http://status.example.com/",
+		"https://shop.example.com/api/",
+		"https://shop.example.com/basket",
+	})
+	assert.DeepEqual(t, probe.Spec.Targets.StaticConfig.Labels, map[string]string{"namespace": "shop", "ingress": "web"})
+
+	// The tls annotation overrides the Ingress's TLS section, the module annotation the default module
+	ingress.Annotations["com.uswitch.heimdall-probe/tls"] = "false"
+	ingress.Annotations["com.uswitch.heimdall-probe/module"] = "http_post_2xx"
+	probe, err = template.CreateProbeFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Equal(t, probe.Spec.Module, "http_post_2xx")
+	assert.Equal(t, probe.Spec.Targets.StaticConfig.Targets[0], "http://shop.example.com/api/")
+
+	// Without the enabled annotation there's no Probe
+	probe, err = template.CreateProbeFromIngress(testProbeIngress(nil))
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Nil(probe))
+}
+
+func TestInvalidProbeAnnotations(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+	template.SetProber("blackbox-exporter:9115", "http_2xx", nil)
+
+	tests := []struct {
+		annotation, value, err string
+	}{
+		{"tls", "maybe", "invalid com.uswitch.heimdall-probe/tls annotation"},
+		{"expected-status", "ok", "not an HTTP status"},
+		{"expected-status", "600", "not an HTTP status"},
+	}
+
+	for _, test := range tests {
+		ingress := testProbeIngress(map[string]string{
+			"com.uswitch.heimdall-probe/enabled":            "true",
+			"com.uswitch.heimdall-probe/" + test.annotation: test.value,
+		})
+		_, err := template.CreateProbeFromIngress(ingress)
+		assert.ErrorContains(t, err, test.err)
+	}
+
+	// An Ingress without hosts has nothing to probe
+	ingress := testIngressDefaultBackend.DeepCopy()
+	ingress.Annotations = map[string]string{"com.uswitch.heimdall-probe/enabled": "true"}
+	_, err = template.CreateProbeFromIngress(ingress)
+	assert.ErrorContains(t, err, "no hosts to probe")
+}
+
+func TestIngressProbeParameters(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", fake.NewSimpleClientset())
+	assert.Assert(t, is.Nil(err))
+	template.SetProber("blackbox-exporter:9115", "http_2xx", nil)
+
+	ingress := testProbeIngress(map[string]string{
+		"com.uswitch.heimdall/probe-failure":         "",
+		"com.uswitch.heimdall-probe/enabled":         "true",
+		"com.uswitch.heimdall-probe/expected-status": "401",
+		"service.rvu.co.uk/owner":                    "team-shop",
+	})
+
+	promrules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(promrules, 1))
+	alert := promrules[0].Spec.Groups[0].Rules[0]
+	assert.Equal(t, alert.Expr.StrVal, "probe_http_status_code{namespace=\"shop\",ingress=\"web\"} != 401\n")
+
+	// probe-failure renders nothing for an Ingress without a Probe
+	delete(ingress.Annotations, "com.uswitch.heimdall-probe/enabled")
+	promrules, err = template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Len(promrules, 0))
+}
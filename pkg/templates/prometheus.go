@@ -0,0 +1,319 @@
+package templates
+
+import (
+	"sort"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/uswitch/heimdall/pkg/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// namespacePrometheusLabel names the Prometheus a namespace's rules are for
+const namespacePrometheusLabel = "prometheus"
+
+// PrometheusLister
+// - Lists the Prometheus instances whose ruleSelector and ruleNamespaceSelector rules are matched against, the prometheus-operator's PrometheusLister is one
+type PrometheusLister interface {
+	List(selector labels.Selector) ([]*monitoringv1.Prometheus, error)
+}
+
+// ThanosRulerLister
+// - Lists the ThanosRuler instances rules are matched against, the prometheus-operator's ThanosRulerLister is one
+type ThanosRulerLister interface {
+	List(selector labels.Selector) ([]*monitoringv1.ThanosRuler, error)
+}
+
+// PrometheusParameters
+// - The Prometheus or ThanosRuler an object's rules are for, available to templates in .Prometheus and .ThanosRuler, nil when none is known
+type PrometheusParameters struct {
+	Name      string
+	Namespace string
+	// RuleLabels are labels which satisfy the instance's ruleSelector
+	RuleLabels map[string]string
+	// RuleNamespace is a namespace the instance's ruleNamespaceSelector selects, the object's when it's one of them
+	RuleNamespace string
+}
+
+// DuplicatePrometheusRule
+// - A PrometheusRule both a ThanosRuler and a Prometheus load, whose alerts fire twice
+type DuplicatePrometheusRule struct {
+	PrometheusRule *monitoringv1.PrometheusRule
+	// ThanosRuler and Prometheus are namespace/name
+	ThanosRuler string
+	Prometheus  string
+}
+
+// ruleEvaluator
+// - The rule selectors of a Prometheus or ThanosRuler, both load PrometheusRules the same way
+type ruleEvaluator struct {
+	name                  string
+	namespace             string
+	ruleSelector          *metav1.LabelSelector
+	ruleNamespaceSelector *metav1.LabelSelector
+}
+
+func (e *ruleEvaluator) key() string {
+	return e.namespace + "/" + e.name
+}
+
+// SetPrometheusLister
+// - Prometheus instances are only discovered when it's set
+func (a *PrometheusRuleTemplateManager) SetPrometheusLister(lister PrometheusLister) {
+	a.prometheuses = lister
+}
+
+// SetThanosRulerLister
+// - ThanosRuler instances are only discovered when it's set, clusters without the ThanosRuler CRD shouldn't set it
+func (a *PrometheusRuleTemplateManager) SetThanosRulerLister(lister ThanosRulerLister) {
+	a.thanosRulers = lister
+}
+
+// ThanosRulers
+// - Whether ThanosRuler instances are discovered
+func (a *PrometheusRuleTemplateManager) ThanosRulers() bool {
+	return a.thanosRulers != nil
+}
+
+// listPrometheuses
+// - The known Prometheus instances, sorted by namespace and name
+func (a *PrometheusRuleTemplateManager) listPrometheuses() []*ruleEvaluator {
+	if a.prometheuses == nil {
+		return nil
+	}
+
+	prometheuses, err := a.prometheuses.List(labels.Everything())
+	if err != nil {
+		log.Sugar.Warnw("error listing Prometheus instances", "error", err)
+		return nil
+	}
+
+	evaluators := []*ruleEvaluator{}
+	for _, prometheus := range prometheuses {
+		evaluators = append(evaluators, &ruleEvaluator{
+			name:                  prometheus.Name,
+			namespace:             prometheus.Namespace,
+			ruleSelector:          prometheus.Spec.RuleSelector,
+			ruleNamespaceSelector: prometheus.Spec.RuleNamespaceSelector,
+		})
+	}
+
+	return sortedEvaluators(evaluators)
+}
+
+// listThanosRulers
+// - The known ThanosRuler instances, sorted by namespace and name
+func (a *PrometheusRuleTemplateManager) listThanosRulers() []*ruleEvaluator {
+	if a.thanosRulers == nil {
+		return nil
+	}
+
+	thanosRulers, err := a.thanosRulers.List(labels.Everything())
+	if err != nil {
+		log.Sugar.Warnw("error listing ThanosRuler instances", "error", err)
+		return nil
+	}
+
+	evaluators := []*ruleEvaluator{}
+	for _, thanosRuler := range thanosRulers {
+		evaluators = append(evaluators, &ruleEvaluator{
+			name:                  thanosRuler.Name,
+			namespace:             thanosRuler.Namespace,
+			ruleSelector:          thanosRuler.Spec.RuleSelector,
+			ruleNamespaceSelector: thanosRuler.Spec.RuleNamespaceSelector,
+		})
+	}
+
+	return sortedEvaluators(evaluators)
+}
+
+func sortedEvaluators(evaluators []*ruleEvaluator) []*ruleEvaluator {
+	sort.Slice(evaluators, func(i, j int) bool {
+		if evaluators[i].namespace != evaluators[j].namespace {
+			return evaluators[i].namespace < evaluators[j].namespace
+		}
+		return evaluators[i].name < evaluators[j].name
+	})
+
+	return evaluators
+}
+
+// namespaceLabels
+// - The labels of a namespace, nil when it can't be found
+func (a *PrometheusRuleTemplateManager) namespaceLabels(namespace string) map[string]string {
+	ns, err := a.objects.Namespace(namespace)
+	if err != nil {
+		log.Sugar.Debugw("error getting namespace for Prometheus discovery", "namespace", namespace, "error", err)
+		return nil
+	}
+
+	return ns.GetLabels()
+}
+
+// selectsNamespace
+// - Whether the instance loads rules from namespace, with namespaceLabels, only its own when it has no ruleNamespaceSelector
+func (e *ruleEvaluator) selectsNamespace(namespace string, namespaceLabels map[string]string) bool {
+	if e.ruleNamespaceSelector == nil {
+		return namespace == e.namespace
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(e.ruleNamespaceSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(namespaceLabels))
+}
+
+// selectsRule
+// - Whether the instance loads a PrometheusRule with ruleLabels, one without a ruleSelector loads none
+func (e *ruleEvaluator) selectsRule(ruleLabels map[string]string) bool {
+	selector, err := metav1.LabelSelectorAsSelector(e.ruleSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(ruleLabels))
+}
+
+// prometheusParameters
+// - The Prometheus for objects in namespace, the one the namespace's prometheus label names or otherwise any
+func (a *PrometheusRuleTemplateManager) prometheusParameters(namespace string) *PrometheusParameters {
+	return a.evaluatorParameters(a.listPrometheuses(), namespace, namespacePrometheusLabel)
+}
+
+// thanosRulerParameters
+// - The ThanosRuler for objects in namespace
+func (a *PrometheusRuleTemplateManager) thanosRulerParameters(namespace string) *PrometheusParameters {
+	return a.evaluatorParameters(a.listThanosRulers(), namespace, "")
+}
+
+// evaluatorParameters
+// - The instance the namespace's nameLabel names when there is one, one which loads rules from the namespace is preferred, nil when none is known
+func (a *PrometheusRuleTemplateManager) evaluatorParameters(evaluators []*ruleEvaluator, namespace string, nameLabel string) *PrometheusParameters {
+	if len(evaluators) == 0 {
+		return nil
+	}
+
+	namespaceLabels := a.namespaceLabels(namespace)
+	candidates := evaluators
+	if name := namespaceLabels[nameLabel]; nameLabel != "" && name != "" {
+		named := []*ruleEvaluator{}
+		for _, evaluator := range evaluators {
+			if evaluator.name == name {
+				named = append(named, evaluator)
+			}
+		}
+		if len(named) != 0 {
+			candidates = named
+		}
+	}
+
+	evaluator := candidates[0]
+	for _, candidate := range candidates {
+		if candidate.selectsNamespace(namespace, namespaceLabels) {
+			evaluator = candidate
+			break
+		}
+	}
+
+	ruleNamespace := namespace
+	if !evaluator.selectsNamespace(namespace, namespaceLabels) && evaluator.selectsNamespace(evaluator.namespace, a.namespaceLabels(evaluator.namespace)) {
+		ruleNamespace = evaluator.namespace
+	}
+
+	return &PrometheusParameters{
+		Name:          evaluator.name,
+		Namespace:     evaluator.namespace,
+		RuleLabels:    selectorLabels(evaluator.ruleSelector),
+		RuleNamespace: ruleNamespace,
+	}
+}
+
+// selectorLabels
+// - Labels which satisfy selector, the first value of In expressions and true for Exists
+// - NotIn and DoesNotExist expressions are satisfied by leaving the label out
+func selectorLabels(selector *metav1.LabelSelector) map[string]string {
+	selected := map[string]string{}
+	if selector == nil {
+		return selected
+	}
+
+	for key, value := range selector.MatchLabels {
+		selected[key] = value
+	}
+	for _, requirement := range selector.MatchExpressions {
+		switch requirement.Operator {
+		case metav1.LabelSelectorOpIn:
+			if len(requirement.Values) != 0 {
+				selected[requirement.Key] = requirement.Values[0]
+			}
+		case metav1.LabelSelectorOpExists:
+			selected[requirement.Key] = "true"
+		}
+	}
+
+	return selected
+}
+
+// selectingEvaluator
+// - The first of evaluators which loads promrule, nil when none does
+// - namespaceLabels caches the labels of the namespaces looked up
+func (a *PrometheusRuleTemplateManager) selectingEvaluator(evaluators []*ruleEvaluator, promrule *monitoringv1.PrometheusRule, namespaceLabels map[string]map[string]string) *ruleEvaluator {
+	if _, ok := namespaceLabels[promrule.Namespace]; !ok {
+		namespaceLabels[promrule.Namespace] = a.namespaceLabels(promrule.Namespace)
+	}
+
+	for _, evaluator := range evaluators {
+		if evaluator.selectsRule(promrule.Labels) && evaluator.selectsNamespace(promrule.Namespace, namespaceLabels[promrule.Namespace]) {
+			return evaluator
+		}
+	}
+
+	return nil
+}
+
+// UnselectedPrometheusRules
+// - The PrometheusRules no known Prometheus or ThanosRuler loads, by its ruleSelector and ruleNamespaceSelector
+// - None when no instance is known, Heimdall may not be able to see the ones which load its rules
+func (a *PrometheusRuleTemplateManager) UnselectedPrometheusRules(prometheusRules []*monitoringv1.PrometheusRule) []*monitoringv1.PrometheusRule {
+	evaluators := append(a.listPrometheuses(), a.listThanosRulers()...)
+	if len(evaluators) == 0 {
+		return nil
+	}
+
+	namespaceLabels := map[string]map[string]string{}
+	unselected := []*monitoringv1.PrometheusRule{}
+	for _, promrule := range prometheusRules {
+		if a.selectingEvaluator(evaluators, promrule, namespaceLabels) == nil {
+			unselected = append(unselected, promrule)
+		}
+	}
+
+	return unselected
+}
+
+// DuplicatePrometheusRules
+// - The PrometheusRules a ThanosRuler loads, so are targeted at it, which a Prometheus loads too
+func (a *PrometheusRuleTemplateManager) DuplicatePrometheusRules(prometheusRules []*monitoringv1.PrometheusRule) []DuplicatePrometheusRule {
+	thanosRulers := a.listThanosRulers()
+	if len(thanosRulers) == 0 {
+		return nil
+	}
+	prometheuses := a.listPrometheuses()
+
+	namespaceLabels := map[string]map[string]string{}
+	duplicates := []DuplicatePrometheusRule{}
+	for _, promrule := range prometheusRules {
+		thanosRuler := a.selectingEvaluator(thanosRulers, promrule, namespaceLabels)
+		if thanosRuler == nil {
+			continue
+		}
+
+		if prometheus := a.selectingEvaluator(prometheuses, promrule, namespaceLabels); prometheus != nil {
+			duplicates = append(duplicates, DuplicatePrometheusRule{PrometheusRule: promrule, ThanosRuler: thanosRuler.key(), Prometheus: prometheus.key()})
+		}
+	}
+
+	return duplicates
+}
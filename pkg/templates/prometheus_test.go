@@ -0,0 +1,230 @@
+package templates
+
+import (
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/uswitch/heimdall/pkg/log"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testPrometheusLister []*monitoringv1.Prometheus
+
+func (l testPrometheusLister) List(selector labels.Selector) ([]*monitoringv1.Prometheus, error) {
+	return l, nil
+}
+
+// testPrometheuses
+// - apps loads rules labelled role=alert-rules from namespaces labelled monitoring=enabled, system only its own namespace's
+func testPrometheuses() testPrometheusLister {
+	return testPrometheusLister{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "system", Namespace: "kube-system"},
+			Spec: monitoringv1.PrometheusSpec{
+				RuleSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"prometheus": "kube-system"}},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "apps", Namespace: "monitoring"},
+			Spec: monitoringv1.PrometheusSpec{
+				RuleSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"role": "alert-rules"},
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "team", Operator: metav1.LabelSelectorOpIn, Values: []string{"apps", "platform"}},
+						{Key: "disabled", Operator: metav1.LabelSelectorOpDoesNotExist},
+					},
+				},
+				RuleNamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"monitoring": "enabled"}},
+			},
+		},
+	}
+}
+
+func TestPrometheusParameters(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"monitoring": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch", Labels: map[string]string{"prometheus": "system"}}},
+	)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, is.Nil(template.prometheusParameters("shop")))
+
+	template.SetPrometheusLister(testPrometheuses())
+
+	// shop is selected by apps' ruleNamespaceSelector
+	assert.DeepEqual(t, template.prometheusParameters("shop"), &PrometheusParameters{
+		Name:          "apps",
+		Namespace:     "monitoring",
+		RuleLabels:    map[string]string{"role": "alert-rules", "team": "apps"},
+		RuleNamespace: "shop",
+	})
+
+	// batch names system, which only loads rules from its own namespace
+	assert.DeepEqual(t, template.prometheusParameters("batch"), &PrometheusParameters{
+		Name:          "system",
+		Namespace:     "kube-system",
+		RuleLabels:    map[string]string{"prometheus": "kube-system"},
+		RuleNamespace: "kube-system",
+	})
+}
+
+func TestPrometheusRuleNamespace(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch", Labels: map[string]string{"prometheus": "system"}}},
+	)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	template.SetPrometheusLister(testPrometheuses())
+
+	deployment := testDeployment.DeepCopy()
+	deployment.Namespace = "batch"
+	ingress := testIngressDefaultBackend.DeepCopy()
+	ingress.Namespace = "batch"
+
+	deploymentRules, err := template.CreateFromDeployment(deployment, "system")
+	assert.Assert(t, is.Nil(err))
+	ingressRules, err := template.CreateFromIngress(ingress)
+	assert.Assert(t, is.Nil(err))
+
+	// system doesn't load rules from batch, so they're rendered into its own namespace where it does
+	promrules := append(deploymentRules, ingressRules...)
+	assert.Assert(t, is.Len(promrules, 2))
+	for _, promrule := range promrules {
+		assert.Equal(t, promrule.Namespace, "kube-system")
+		assert.DeepEqual(t, promrule.Labels, map[string]string{"prometheus": "kube-system"})
+	}
+	assert.Assert(t, is.Len(template.UnselectedPrometheusRules(promrules), 0))
+}
+
+func TestUnselectedPrometheusRules(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"monitoring": "enabled"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "batch"}},
+	)
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+
+	promrule := func(namespace, name string, ruleLabels map[string]string) *monitoringv1.PrometheusRule {
+		return &monitoringv1.PrometheusRule{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: ruleLabels}}
+	}
+	promrules := []*monitoringv1.PrometheusRule{
+		promrule("shop", "selected", map[string]string{"role": "alert-rules", "team": "platform"}),
+		promrule("kube-system", "system", map[string]string{"prometheus": "kube-system"}),
+		promrule("shop", "wrong-team", map[string]string{"role": "alert-rules", "team": "data"}),
+		promrule("shop", "disabled", map[string]string{"role": "alert-rules", "team": "apps", "disabled": "true"}),
+		promrule("batch", "unselected-namespace", map[string]string{"role": "alert-rules", "team": "apps"}),
+	}
+
+	// Nothing is reported when no Prometheus is known
+	assert.Assert(t, is.Len(template.UnselectedPrometheusRules(promrules), 0))
+
+	template.SetPrometheusLister(testPrometheuses())
+	unselected := []string{}
+	for _, promrule := range template.UnselectedPrometheusRules(promrules) {
+		unselected = append(unselected, promrule.Name)
+	}
+	assert.DeepEqual(t, unselected, []string{"wrong-team", "disabled", "unselected-namespace"})
+}
+
+func TestSelectorLabels(t *testing.T) {
+	selector := &metav1.LabelSelector{
+		MatchLabels: map[string]string{"role": "alert-rules"},
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "team", Operator: metav1.LabelSelectorOpIn, Values: []string{"apps"}},
+			{Key: "managed", Operator: metav1.LabelSelectorOpExists},
+			{Key: "tier", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"batch"}},
+		},
+	}
+
+	assert.DeepEqual(t, selectorLabels(selector), map[string]string{"role": "alert-rules", "team": "apps", "managed": "true"})
+	assert.DeepEqual(t, selectorLabels(nil), map[string]string{})
+}
+
+type testThanosRulerLister []*monitoringv1.ThanosRuler
+
+func (l testThanosRulerLister) List(selector labels.Selector) ([]*monitoringv1.ThanosRuler, error) {
+	return l, nil
+}
+
+// testThanosRulers
+// - global loads rules labelled role=global-alert-rules from every namespace
+func testThanosRulers() testThanosRulerLister {
+	return testThanosRulerLister{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "global", Namespace: "monitoring"},
+			Spec: monitoringv1.ThanosRulerSpec{
+				RuleSelector:          &metav1.LabelSelector{MatchLabels: map[string]string{"role": "global-alert-rules"}},
+				RuleNamespaceSelector: &metav1.LabelSelector{},
+			},
+		},
+	}
+}
+
+func TestThanosRulerParameters(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}})
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	assert.Assert(t, !template.ThanosRulers())
+	assert.Assert(t, is.Nil(template.thanosRulerParameters("shop")))
+
+	template.SetThanosRulerLister(testThanosRulers())
+	assert.Assert(t, template.ThanosRulers())
+	assert.DeepEqual(t, template.thanosRulerParameters("shop"), &PrometheusParameters{
+		Name:          "global",
+		Namespace:     "monitoring",
+		RuleLabels:    map[string]string{"role": "global-alert-rules"},
+		RuleNamespace: "shop",
+	})
+}
+
+func TestDuplicatePrometheusRules(t *testing.T) {
+	log.Setup(log.DEBUG_LEVEL)
+
+	client := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"monitoring": "enabled"}}})
+	template, err := NewPrometheusRuleTemplateManager("../../kube/config/templates", client)
+	assert.Assert(t, is.Nil(err))
+	template.SetPrometheusLister(testPrometheuses())
+
+	promrule := func(name string, ruleLabels map[string]string) *monitoringv1.PrometheusRule {
+		return &monitoringv1.PrometheusRule{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Labels: ruleLabels}}
+	}
+	promrules := []*monitoringv1.PrometheusRule{
+		promrule("global", map[string]string{"role": "global-alert-rules"}),
+		promrule("local", map[string]string{"role": "alert-rules", "team": "apps"}),
+		// global loads it, apps doesn't as its role is global-alert-rules
+		promrule("both", map[string]string{"role": "global-alert-rules", "team": "apps"}),
+	}
+
+	// Nothing is reported when no ThanosRuler is known
+	assert.Assert(t, is.Len(template.DuplicatePrometheusRules(promrules), 0))
+
+	template.SetThanosRulerLister(testThanosRulers())
+	assert.Assert(t, is.Len(template.UnselectedPrometheusRules(promrules), 0))
+	assert.Assert(t, is.Len(template.DuplicatePrometheusRules(promrules), 0))
+
+	template.SetPrometheusLister(append(testPrometheuses(), &monitoringv1.Prometheus{
+		ObjectMeta: metav1.ObjectMeta{Name: "everything", Namespace: "monitoring"},
+		Spec: monitoringv1.PrometheusSpec{
+			RuleSelector:          &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: metav1.LabelSelectorOpExists}}},
+			RuleNamespaceSelector: &metav1.LabelSelector{},
+		},
+	}))
+	duplicates := template.DuplicatePrometheusRules(promrules)
+	assert.Assert(t, is.Len(duplicates, 1))
+	assert.Equal(t, duplicates[0].PrometheusRule.Name, "both")
+	assert.Equal(t, duplicates[0].ThanosRuler, "monitoring/global")
+	assert.Equal(t, duplicates[0].Prometheus, "monitoring/everything")
+}
@@ -2,25 +2,31 @@ package templates
 
 import (
 	"fmt"
+	"io"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"text/template"
 
+	"k8s.io/apimachinery/pkg/util/validation"
+	kubeyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
 	log "github.com/uswitch/heimdall/pkg/log"
 	"github.com/uswitch/heimdall/pkg/sentryclient"
 
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	appsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
-var heimPrefix = "com.uswitch.heimdall"
-
 const (
-	ownerAnnotation       = "service.rvu.co.uk/owner"
-	environmentAnnotation = "service.rvu.co.uk/environment"
-	criticalityAnnotation = "service.rvu.co.uk/criticality"
-	sensitivityAnnotation = "service.rvu.co.uk/sensitivity"
+	// DefaultAnnotationPrefix is the prefix of the annotations which select templates, unless SetAnnotationPrefix changes it
+	DefaultAnnotationPrefix = "com.uswitch.heimdall"
+	// GeneratedPrefixAnnotation records the prefix of the install which generated an object, objects without it are the default prefix's
+	GeneratedPrefixAnnotation = "com.uswitch.heimdall-generated/prefix"
 )
 
 // ClientSetI
@@ -33,15 +39,43 @@ type ClientSetI interface {
 // PrometheusRuleTemplateManager
 // - Contains a map of all the templates in the given templates folder
 type PrometheusRuleTemplateManager struct {
-	clientSet ClientSetI
+	// objects resolves owners, with requests through the ClientSetI unless SetObjectLister is called
+	objects ObjectLister
 
 	templates map[string]*template.Template
+	metadata  map[string]*TemplateMetadata
+
+	// prefix is the prefix of the annotations which select templates, monitors use prefix-monitor and Probes prefix-probe
+	prefix string
+
+	consolidate bool
+
+	dashboards      map[string]*template.Template
+	grafanaURL      string
+	dashboardLabels map[string]string
+
+	monitors map[string]*template.Template
+
+	proberURL    string
+	proberModule string
+	probeLabels  map[string]string
+
+	// metadataKeys maps each metadata field to the annotation or label key it's read from
+	metadataKeys map[string]string
+	// defaultMetadata holds the cluster default owner metadata, keyed by field
+	defaultMetadata map[string]string
+
+	// prometheuses are the Prometheus instances rules are matched against, none are discovered when it's nil
+	prometheuses PrometheusLister
+	// thanosRulers are the ThanosRuler instances rules are matched against, none are discovered when it's nil
+	thanosRulers ThanosRulerLister
 }
 
 // NewPrometheusRuleTemplateManager
 // - Creates a new PrometheusRuleTemplateManager taking a directory as a string
 func NewPrometheusRuleTemplateManager(directory string, clientSet ClientSetI) (*PrometheusRuleTemplateManager, error) {
 	templates := map[string]*template.Template{}
+	metadata := map[string]*TemplateMetadata{}
 	templateFiles, err := filepath.Glob(directory + "/*.tmpl")
 	if err != nil {
 		sentryclient.SentryErr(err)
@@ -49,13 +83,21 @@ func NewPrometheusRuleTemplateManager(directory string, clientSet ClientSetI) (*
 	}
 
 	for _, t := range templateFiles {
-		tmpl, err := template.ParseFiles(t)
+		tmpl, err := parseTemplate(t)
 		if err != nil {
 			sentryclient.SentryErr(err)
 			return nil, err
 		}
 
-		templates[strings.TrimSuffix(filepath.Base(t), ".tmpl")] = tmpl
+		templateMetadata, _, err := parseTemplateMetadata(t)
+		if err != nil {
+			err = fmt.Errorf("%s: %v", t, err)
+			sentryclient.SentryErr(err)
+			return nil, err
+		}
+
+		templates[templateName(t)] = tmpl
+		metadata[templateName(t)] = templateMetadata
 	}
 
 	log.Sugar.Debugf("%+v", templates)
@@ -63,7 +105,171 @@ func NewPrometheusRuleTemplateManager(directory string, clientSet ClientSetI) (*
 		return nil, fmt.Errorf("no templates defined")
 	}
 
-	return &PrometheusRuleTemplateManager{clientSet: clientSet, templates: templates}, nil
+	return &PrometheusRuleTemplateManager{
+		objects:      &clientObjectLister{clientSet: clientSet},
+		templates:    templates,
+		metadata:     metadata,
+		prefix:       DefaultAnnotationPrefix,
+		metadataKeys: DefaultMetadataKeys,
+	}, nil
+}
+
+// SetAnnotationPrefix
+// - The prefix of the annotations which select templates, DefaultAnnotationPrefix unless set
+// - Installs with different prefixes read different annotations and leave the objects the others generate alone
+func (a *PrometheusRuleTemplateManager) SetAnnotationPrefix(prefix string) error {
+	if errs := validation.IsDNS1123Subdomain(prefix); len(errs) != 0 {
+		return fmt.Errorf("invalid annotation prefix \"%s\": %s", prefix, strings.Join(errs, ", "))
+	}
+
+	a.prefix = prefix
+	return nil
+}
+
+// Generated
+// - Whether obj was generated by an install with this prefix, the controller only updates and deletes its own objects
+func (a *PrometheusRuleTemplateManager) Generated(obj metav1.Object) bool {
+	prefix, ok := obj.GetAnnotations()[GeneratedPrefixAnnotation]
+	if !ok {
+		prefix = DefaultAnnotationPrefix
+	}
+
+	return prefix == a.prefix
+}
+
+// MarkGenerated
+// - Records the prefix on an object generated with one other than the default, objects the default prefix generates are left as they always were
+// - The controller marks the objects it generates outside the template manager, such as AlertmanagerConfigs, with it too
+func (a *PrometheusRuleTemplateManager) MarkGenerated(obj metav1.Object) {
+	if a.prefix == DefaultAnnotationPrefix {
+		return
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[GeneratedPrefixAnnotation] = a.prefix
+	obj.SetAnnotations(annotations)
+}
+
+// parseTemplate
+// - Parses a single template file the way all templates are loaded, with the functions in funcMap
+func parseTemplate(path string) (*template.Template, error) {
+	return template.New(filepath.Base(path)).Funcs(funcMap).ParseFiles(path)
+}
+
+// templateName
+// - Returns the name annotations refer to a template file by
+func templateName(path string) string {
+	return strings.TrimSuffix(filepath.Base(path), ".tmpl")
+}
+
+// templateAnnotation
+// - Splits a heimdall annotation key into the template name and the instance, com.uswitch.heimdall/5xx-rate.warning => 5xx-rate, warning
+// - A template whose name contains a dot is matched whole before the key is split, ok is false for keys without the heimdall prefix
+func (a *PrometheusRuleTemplateManager) templateAnnotation(key string) (name, instance string, ok bool) {
+	if !strings.HasPrefix(key, a.prefix+"/") {
+		return "", "", false
+	}
+
+	name = strings.TrimPrefix(key, a.prefix+"/")
+	if _, ok := a.templates[name]; ok {
+		return name, "", true
+	}
+
+	if i := strings.LastIndex(name, "."); i > 0 && i < len(name)-1 {
+		return name[:i], name[i+1:], true
+	}
+
+	return name, "", true
+}
+
+// sortedKeys
+// - Annotations are rendered in key order, so which of two clashing annotations wins doesn't change between syncs
+func sortedKeys(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// parseAnnotationValue
+// - Annotation values are either a plain threshold or a JSON/YAML object of parameters, such as {"threshold":0.01,"for":"5m"}
+// - A plain value is returned as the threshold parameter
+func parseAnnotationValue(value string) (map[string]interface{}, error) {
+	var parsed interface{}
+	err := yaml.Unmarshal([]byte(value), &parsed)
+
+	params, ok := parsed.(map[string]interface{})
+	if err != nil || !ok {
+		if strings.HasPrefix(strings.TrimSpace(value), "{") {
+			return nil, fmt.Errorf("invalid parameters object: %v", err)
+		}
+		return map[string]interface{}{"threshold": value}, nil
+	}
+
+	return params, nil
+}
+
+// thresholdParameter
+// - Returns the threshold parameter as the string templates get in .Threshold, so templates using it work with both forms of annotation value
+func thresholdParameter(params map[string]interface{}) string {
+	if v, ok := params["threshold"]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+
+	return ""
+}
+
+// decodePrometheusRules
+// - Decodes every YAML document in a template's output, templates can render several PrometheusRules
+// - Empty documents are skipped, so a template may render nothing for some objects
+func decodePrometheusRules(rendered io.Reader) ([]*monitoringv1.PrometheusRule, error) {
+	decoder := kubeyaml.NewYAMLOrJSONDecoder(rendered, 1024)
+
+	prometheusRules := []*monitoringv1.PrometheusRule{}
+	for {
+		promrule := &monitoringv1.PrometheusRule{}
+		if err := decoder.Decode(promrule); err != nil {
+			if err == io.EOF {
+				return prometheusRules, nil
+			}
+			return nil, err
+		}
+
+		if reflect.DeepEqual(promrule, &monitoringv1.PrometheusRule{}) {
+			continue
+		}
+
+		prometheusRules = append(prometheusRules, promrule)
+	}
+}
+
+// prometheusRuleKey
+// - Identifies a rendered PrometheusRule, templates can render rules with the same name in different namespaces
+func prometheusRuleKey(promrule *monitoringv1.PrometheusRule) string {
+	return promrule.Namespace + "/" + promrule.Name
+}
+
+// duplicatePrometheusRules
+// - Returns the keys rendered more than once by a single template, none of those rules can be trusted
+// - Every value is false, callers set it once the duplicate has been reported
+func duplicatePrometheusRules(prometheusRules []*monitoringv1.PrometheusRule) map[string]bool {
+	seen := map[string]bool{}
+	duplicates := map[string]bool{}
+	for _, promrule := range prometheusRules {
+		key := prometheusRuleKey(promrule)
+		if seen[key] {
+			duplicates[key] = false
+		}
+		seen[key] = true
+	}
+
+	return duplicates
 }
 
 // collectPrometheusRules
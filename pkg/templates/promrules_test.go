@@ -0,0 +1,94 @@
+package templates
+
+import (
+	"testing"
+	"text/template"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseAnnotationValue(t *testing.T) {
+	tests := []struct {
+		value     string
+		threshold string
+		params    map[string]interface{}
+	}{
+		{"0.001", "0.001", map[string]interface{}{"threshold": "0.001"}},
+		{"web", "web", map[string]interface{}{"threshold": "web"}},
+		{
+			`{"threshold": 0.01, "for": "5m", "severity": "page"}`,
+			"0.01",
+			map[string]interface{}{"threshold": 0.01, "for": "5m", "severity": "page"},
+		},
+		{`{"for": "5m"}`, "", map[string]interface{}{"for": "5m"}},
+		{"threshold: 0.5\nfor: 10m", "0.5", map[string]interface{}{"threshold": 0.5, "for": "10m"}},
+	}
+
+	for _, test := range tests {
+		params, err := parseAnnotationValue(test.value)
+		assert.NilError(t, err, test.value)
+		assert.Check(t, is.Equal(thresholdParameter(params), test.threshold), test.value)
+		assert.Check(t, is.DeepEqual(params, test.params), test.value)
+	}
+}
+
+func TestParseAnnotationValueInvalidObject(t *testing.T) {
+	_, err := parseAnnotationValue(`{"threshold": 0.01,`)
+	assert.ErrorContains(t, err, "invalid parameters object")
+}
+
+func TestTemplateAnnotation(t *testing.T) {
+	manager := &PrometheusRuleTemplateManager{prefix: DefaultAnnotationPrefix, templates: map[string]*template.Template{
+		"5xx-rate":         template.New("5xx-rate"),
+		"slo.availability": template.New("slo.availability"),
+	}}
+
+	tests := []struct {
+		key      string
+		name     string
+		instance string
+		ok       bool
+	}{
+		{"com.uswitch.heimdall/5xx-rate", "5xx-rate", "", true},
+		{"com.uswitch.heimdall/5xx-rate.warning", "5xx-rate", "warning", true},
+		{"com.uswitch.heimdall/slo.availability", "slo.availability", "", true},
+		{"com.uswitch.heimdall/slo.availability.page", "slo.availability", "page", true},
+		{"com.uswitch.heimdall/5xx-rate.", "5xx-rate.", "", true},
+		{"com.uswitch.heimdall-other/5xx-rate", "", "", false},
+		{"service.rvu.co.uk/owner", "", "", false},
+	}
+
+	for _, test := range tests {
+		name, instance, ok := manager.templateAnnotation(test.key)
+		assert.Check(t, is.Equal(name, test.name), test.key)
+		assert.Check(t, is.Equal(instance, test.instance), test.key)
+		assert.Check(t, is.Equal(ok, test.ok), test.key)
+	}
+}
+
+func TestAnnotationPrefix(t *testing.T) {
+	manager := &PrometheusRuleTemplateManager{prefix: DefaultAnnotationPrefix, templates: map[string]*template.Template{
+		"5xx-rate": template.New("5xx-rate"),
+	}}
+	assert.ErrorContains(t, manager.SetAnnotationPrefix("Team_Example"), "invalid annotation prefix")
+	assert.Assert(t, is.Nil(manager.SetAnnotationPrefix("team.example.com")))
+
+	_, _, ok := manager.templateAnnotation("com.uswitch.heimdall/5xx-rate")
+	assert.Assert(t, !ok)
+	name, _, ok := manager.templateAnnotation("team.example.com/5xx-rate")
+	assert.Assert(t, ok)
+	assert.Equal(t, name, "5xx-rate")
+
+	// Objects generated with the default prefix aren't marked, so those generated before prefixes were configurable are still the default's
+	unmarked := &metav1.ObjectMeta{}
+	assert.Assert(t, !manager.Generated(unmarked))
+	assert.Assert(t, (&PrometheusRuleTemplateManager{prefix: DefaultAnnotationPrefix}).Generated(unmarked))
+
+	marked := &metav1.ObjectMeta{}
+	manager.MarkGenerated(marked)
+	assert.Equal(t, marked.Annotations[GeneratedPrefixAnnotation], "team.example.com")
+	assert.Assert(t, manager.Generated(marked))
+	assert.Assert(t, !(&PrometheusRuleTemplateManager{prefix: DefaultAnnotationPrefix}).Generated(marked))
+}
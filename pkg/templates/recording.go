@@ -0,0 +1,190 @@
+package templates
+
+import (
+	"fmt"
+	"sort"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/uswitch/heimdall/pkg/log"
+	kubelabels "k8s.io/apimachinery/pkg/labels"
+)
+
+// dedupeRecordingRules
+// - Recording rules rendered for one object are shared between its templates, so several alerts can use the same series
+// - An identical recording rule is only kept the first time it is rendered for a namespace and set of labels, in key order
+// - A PrometheusRule recording a series another one already records with a different expression is invalid, Prometheus would report duplicate series
+// - Recording rules no alert uses, directly or through other recording rules, are dropped
+// - Groups and PrometheusRules left empty are dropped too, so their existing objects are deleted
+// - templates maps the key of each PrometheusRule to the template which rendered it, for the invalid rules
+func dedupeRecordingRules(prometheusRules []*monitoringv1.PrometheusRule, templates map[string]string) ([]*monitoringv1.PrometheusRule, []InvalidRule) {
+	sorted := append([]*monitoringv1.PrometheusRule{}, prometheusRules...)
+	sort.Slice(sorted, func(i, j int) bool { return prometheusRuleKey(sorted[i]) < prometheusRuleKey(sorted[j]) })
+
+	// Leaving out a conflicting rule can resolve the conflicts of later ones, so they're found one at a time
+	invalidRules := []InvalidRule{}
+	for {
+		i, err := conflictingRecordingRule(sorted)
+		if i < 0 {
+			break
+		}
+
+		promrule := sorted[i]
+		log.Sugar.Warnf("[%s] invalid PrometheusRule from template \"%s\": %s", prometheusRuleKey(promrule), templates[prometheusRuleKey(promrule)], err)
+		invalidRules = append(invalidRules, InvalidRule{Template: templates[prometheusRuleKey(promrule)], PrometheusRule: promrule, Err: err})
+		sorted = append(sorted[:i], sorted[i+1:]...)
+	}
+
+	used := usedRecordingRules(sorted)
+
+	seen := map[recordingRule]bool{}
+
+	deduped := []*monitoringv1.PrometheusRule{}
+	for _, promrule := range sorted {
+		target := targetOf(promrule)
+
+		groups := []monitoringv1.RuleGroup{}
+		for _, group := range promrule.Spec.Groups {
+			rules := []monitoringv1.Rule{}
+			for _, rule := range group.Rules {
+				if rule.Record == "" {
+					rules = append(rules, rule)
+					continue
+				}
+
+				if !used[rule.Record] {
+					log.Sugar.Debugf("[%s] dropping recording rule \"%s\" which no alert uses", prometheusRuleKey(promrule), rule.Record)
+					continue
+				}
+
+				key := recordingRuleOf(target, rule)
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				rules = append(rules, rule)
+			}
+
+			if len(rules) != 0 {
+				group.Rules = rules
+				groups = append(groups, group)
+			}
+		}
+
+		if len(groups) != 0 {
+			promrule.Spec.Groups = groups
+			deduped = append(deduped, promrule)
+		}
+	}
+
+	return deduped, invalidRules
+}
+
+// recordingRule
+// - Identifies the series a recording rule records, rules with the same key have to have the same expression
+type recordingRule struct {
+	target consolidationTarget
+	record string
+	labels string
+}
+
+func recordingRuleOf(target consolidationTarget, rule monitoringv1.Rule) recordingRule {
+	return recordingRule{target: target, record: rule.Record, labels: kubelabels.Set(rule.Labels).String()}
+}
+
+// conflictingRecordingRule
+// - The index of the first PrometheusRule, in order, recording a series it or an earlier one already records with a different expression, -1 when there's none
+func conflictingRecordingRule(prometheusRules []*monitoringv1.PrometheusRule) (int, error) {
+	type recorded struct {
+		expr string
+		key  string
+	}
+	seen := map[recordingRule]recorded{}
+
+	for i, promrule := range prometheusRules {
+		target := targetOf(promrule)
+		for _, group := range promrule.Spec.Groups {
+			for _, rule := range group.Rules {
+				if rule.Record == "" {
+					continue
+				}
+
+				key := recordingRuleOf(target, rule)
+				first, ok := seen[key]
+				if !ok {
+					seen[key] = recorded{expr: rule.Expr.String(), key: prometheusRuleKey(promrule)}
+					continue
+				}
+				if first.expr != rule.Expr.String() {
+					return i, fmt.Errorf("recording rule \"%s\" is recorded by %s with a different expression", rule.Record, first.key)
+				}
+			}
+		}
+	}
+
+	return -1, nil
+}
+
+// usedRecordingRules
+// - Returns the names of the recording rules the alerts in prometheusRules use, directly or through other recording rules
+func usedRecordingRules(prometheusRules []*monitoringv1.PrometheusRule) map[string]bool {
+	recordingExprs := map[string][]string{}
+	pending := []string{}
+	for _, promrule := range prometheusRules {
+		for _, group := range promrule.Spec.Groups {
+			for _, rule := range group.Rules {
+				if rule.Record != "" {
+					recordingExprs[rule.Record] = append(recordingExprs[rule.Record], rule.Expr.String())
+				} else {
+					pending = append(pending, rule.Expr.String())
+				}
+			}
+		}
+	}
+
+	used := map[string]bool{}
+	for len(pending) != 0 {
+		expr := pending[0]
+		pending = pending[1:]
+
+		for _, name := range metricNames(expr) {
+			if exprs, ok := recordingExprs[name]; ok && !used[name] {
+				used[name] = true
+				pending = append(pending, exprs...)
+			}
+		}
+	}
+
+	return used
+}
+
+// metricNames
+// - The metric names an expression selects, expressions which don't parse have been reported by validation already
+func metricNames(expr string) []string {
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return nil
+	}
+
+	names := []string{}
+	parser.Inspect(parsed, func(node parser.Node, _ []parser.Node) error {
+		selector, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+
+		if selector.Name != "" {
+			names = append(names, selector.Name)
+		}
+		for _, matcher := range selector.LabelMatchers {
+			if matcher.Name == labels.MetricName && matcher.Type == labels.MatchEqual {
+				names = append(names, matcher.Value)
+			}
+		}
+		return nil
+	})
+
+	return names
+}
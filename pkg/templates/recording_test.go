@@ -0,0 +1,110 @@
+package templates
+
+import (
+	"testing"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func testRecordingRule(namespace, name string, rules ...monitoringv1.Rule) *monitoringv1.PrometheusRule {
+	return &monitoringv1.PrometheusRule{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: monitoringv1.PrometheusRuleSpec{
+			Groups: []monitoringv1.RuleGroup{{Name: name + ".rules", Rules: rules}},
+		},
+	}
+}
+
+func testRecord(name, expr string) monitoringv1.Rule {
+	return monitoringv1.Rule{Record: name, Expr: intstr.FromString(expr)}
+}
+
+func testAlert(name, expr string) monitoringv1.Rule {
+	return monitoringv1.Rule{Alert: name, Expr: intstr.FromString(expr)}
+}
+
+func TestDedupeRecordingRules(t *testing.T) {
+	deduped, invalid := dedupeRecordingRules([]*monitoringv1.PrometheusRule{
+		testRecordingRule("shop", "web-b",
+			testRecord("web:errors:ratio", `sum(rate(errors[5m])) / sum(rate(requests[5m]))`),
+			testAlert("web-b", `web:errors:ratio > 0.1`),
+		),
+		testRecordingRule("shop", "web-a",
+			testRecord("web:errors:ratio", `sum(rate(errors[5m])) / sum(rate(requests[5m]))`),
+			testAlert("web-a", `web:errors:ratio > 0.01`),
+		),
+		testRecordingRule("monitoring", "web-c",
+			testRecord("web:errors:ratio", `sum(rate(errors[5m])) / sum(rate(requests[5m]))`),
+			testAlert("web-c", `web:errors:ratio > 0.5`),
+		),
+	}, nil)
+	assert.Assert(t, is.Len(invalid, 0))
+	assert.Assert(t, is.Len(deduped, 3))
+
+	// The first rule in key order keeps the recording rule, namespaces don't share it
+	assert.Equal(t, deduped[0].Name, "web-c")
+	assert.Assert(t, is.Len(deduped[0].Spec.Groups[0].Rules, 2))
+	assert.Equal(t, deduped[1].Name, "web-a")
+	assert.Assert(t, is.Len(deduped[1].Spec.Groups[0].Rules, 2))
+	assert.Equal(t, deduped[2].Name, "web-b")
+	assert.Assert(t, is.Len(deduped[2].Spec.Groups[0].Rules, 1))
+	assert.Equal(t, deduped[2].Spec.Groups[0].Rules[0].Alert, "web-b")
+}
+
+func TestDedupeRecordingRulesDifferentExpressions(t *testing.T) {
+	deduped, invalid := dedupeRecordingRules([]*monitoringv1.PrometheusRule{
+		testRecordingRule("shop", "web-a",
+			testRecord("web:errors:ratio", `sum(rate(errors[5m]))`),
+			testAlert("web-a", `web:errors:ratio > 0.1`),
+		),
+		testRecordingRule("shop", "web-b",
+			testRecord("web:errors:ratio", `sum(rate(errors[1m]))`),
+			testAlert("web-b", `web:errors:ratio > 0.1`),
+		),
+		// web-c only conflicts with web-b, which is left out
+		testRecordingRule("shop", "web-c",
+			testRecord("web:errors:ratio", `sum(rate(errors[5m]))`),
+			testRecord("web:requests:rate", `sum(rate(requests[5m]))`),
+			testAlert("web-c", `web:errors:ratio / web:requests:rate > 0.1`),
+		),
+	}, map[string]string{"shop/web-b": "errors"})
+
+	// Both would record the same series, the later rule is invalid so its existing one is kept
+	assert.Assert(t, is.Len(deduped, 2))
+	assert.Equal(t, deduped[0].Name, "web-a")
+	assert.Equal(t, deduped[1].Name, "web-c")
+	assert.Assert(t, is.Len(deduped[1].Spec.Groups[0].Rules, 2))
+	assert.Assert(t, is.Len(invalid, 1))
+	assert.Equal(t, invalid[0].Template, "errors")
+	assert.Equal(t, invalid[0].PrometheusRule.Name, "web-b")
+	assert.ErrorContains(t, invalid[0].Err, `recording rule "web:errors:ratio" is recorded by shop/web-a with a different expression`)
+}
+
+func TestDedupeRecordingRulesUnused(t *testing.T) {
+	deduped, invalid := dedupeRecordingRules([]*monitoringv1.PrometheusRule{
+		testRecordingRule("shop", "web-a",
+			testRecord("web:requests:rate5m", `sum(rate(requests[5m]))`),
+			testRecord("web:errors:ratio", `sum(rate(errors[5m])) / web:requests:rate5m`),
+			testRecord("web:unused", `sum(rate(unused[5m]))`),
+			testAlert("web-a", `{__name__="web:errors:ratio"} > 0.1`),
+		),
+		// Only recording rules, nothing uses them so the PrometheusRule is dropped
+		testRecordingRule("shop", "web-b",
+			testRecord("web:other", `sum(rate(other[5m]))`),
+		),
+	}, nil)
+	assert.Assert(t, is.Len(invalid, 0))
+	assert.Assert(t, is.Len(deduped, 1))
+
+	records := []string{}
+	for _, rule := range deduped[0].Spec.Groups[0].Rules {
+		if rule.Record != "" {
+			records = append(records, rule.Record)
+		}
+	}
+	assert.DeepEqual(t, records, []string{"web:requests:rate5m", "web:errors:ratio"})
+}
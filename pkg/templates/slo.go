@@ -0,0 +1,126 @@
+package templates
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// BurnRateWindow
+// - A pair of windows an error ratio is checked over, the short window makes the alert resolve soon after the problem stops
+type BurnRateWindow struct {
+	Long  string
+	Short string
+	// BurnRate is how many times faster than the objective allows the error budget is being spent
+	BurnRate float64
+	// ErrorRatio is the error ratio the burn rate corresponds to, the alert threshold for both windows
+	ErrorRatio float64
+}
+
+// BurnRateAlert
+// - The windows which raise an alert of one severity, any of them is enough
+type BurnRateAlert struct {
+	Severity string
+	Windows  []BurnRateWindow
+}
+
+// burnRatePolicy
+// - The multi-window, multi-burn-rate alerts recommended by the Google SRE workbook, as the share of the budget spent in the long window
+var burnRatePolicy = []struct {
+	severity    string
+	long, short time.Duration
+	consumed    float64
+}{
+	{"page", time.Hour, 5 * time.Minute, 0.02},
+	{"page", 6 * time.Hour, 30 * time.Minute, 0.05},
+	{"ticket", 24 * time.Hour, 2 * time.Hour, 0.1},
+	{"ticket", 3 * 24 * time.Hour, 6 * time.Hour, 0.1},
+}
+
+// errorBudget
+// - The share of requests allowed to fail for an objective given as a percentage, 99.9 => 0.001
+func errorBudget(objective interface{}) (float64, error) {
+	percent, err := toFloat(objective)
+	if err != nil {
+		return 0, err
+	}
+
+	if percent <= 0 || percent >= 100 {
+		return 0, fmt.Errorf("objective %v must be between 0 and 100", percent)
+	}
+
+	return roundFloat(1 - percent/100), nil
+}
+
+// burnRateAlerts
+// - The page and ticket alerts for an objective over window, burn rates are scaled to the window so a 2% share of a 30d budget in 1h is a 14.4x burn
+func burnRateAlerts(objective, window interface{}) ([]BurnRateAlert, error) {
+	budget, err := errorBudget(objective)
+	if err != nil {
+		return nil, err
+	}
+
+	period, err := parseDuration(window)
+	if err != nil {
+		return nil, err
+	}
+
+	alerts := []BurnRateAlert{}
+	for _, policy := range burnRatePolicy {
+		if policy.long > period {
+			continue
+		}
+
+		burnRate := roundFloat(policy.consumed * float64(period) / float64(policy.long))
+		burnRateWindow := BurnRateWindow{
+			Long:       model.Duration(policy.long).String(),
+			Short:      model.Duration(policy.short).String(),
+			BurnRate:   burnRate,
+			ErrorRatio: roundFloat(burnRate * budget),
+		}
+
+		if n := len(alerts); n > 0 && alerts[n-1].Severity == policy.severity {
+			alerts[n-1].Windows = append(alerts[n-1].Windows, burnRateWindow)
+			continue
+		}
+		alerts = append(alerts, BurnRateAlert{Severity: policy.severity, Windows: []BurnRateWindow{burnRateWindow}})
+	}
+
+	if len(alerts) == 0 {
+		return nil, fmt.Errorf("window %v is shorter than every burn rate window", window)
+	}
+
+	return alerts, nil
+}
+
+// burnRateWindows
+// - Every window burnRateAlerts uses for window, shortest first, which the error ratios have to be recorded over
+func burnRateWindows(window interface{}) ([]string, error) {
+	period, err := parseDuration(window)
+	if err != nil {
+		return nil, err
+	}
+
+	durations := map[time.Duration]bool{}
+	for _, policy := range burnRatePolicy {
+		if policy.long <= period {
+			durations[policy.long] = true
+			durations[policy.short] = true
+		}
+	}
+
+	sorted := make([]time.Duration, 0, len(durations))
+	for d := range durations {
+		sorted = append(sorted, d)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	windows := make([]string, len(sorted))
+	for i, d := range sorted {
+		windows[i] = model.Duration(d).String()
+	}
+
+	return windows, nil
+}
@@ -0,0 +1,55 @@
+package templates
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+	is "gotest.tools/assert/cmp"
+)
+
+func TestErrorBudget(t *testing.T) {
+	budget, err := errorBudget("99.9")
+	assert.NilError(t, err)
+	assert.Equal(t, budget, 0.001)
+
+	_, err = errorBudget(100)
+	assert.ErrorContains(t, err, "must be between 0 and 100")
+}
+
+func TestBurnRateAlerts(t *testing.T) {
+	alerts, err := burnRateAlerts("99.9", "30d")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, alerts, []BurnRateAlert{
+		{Severity: "page", Windows: []BurnRateWindow{
+			{Long: "1h", Short: "5m", BurnRate: 14.4, ErrorRatio: 0.0144},
+			{Long: "6h", Short: "30m", BurnRate: 6, ErrorRatio: 0.006},
+		}},
+		{Severity: "ticket", Windows: []BurnRateWindow{
+			{Long: "1d", Short: "2h", BurnRate: 3, ErrorRatio: 0.003},
+			{Long: "3d", Short: "6h", BurnRate: 1, ErrorRatio: 0.001},
+		}},
+	})
+
+	// Burn rates scale with the window, windows longer than it are dropped
+	alerts, err = burnRateAlerts(99, "2d")
+	assert.NilError(t, err)
+	assert.Assert(t, is.Len(alerts, 2))
+	assert.DeepEqual(t, alerts[0].Windows[0], BurnRateWindow{Long: "1h", Short: "5m", BurnRate: 0.96, ErrorRatio: 0.0096})
+	assert.Assert(t, is.Len(alerts[1].Windows, 1))
+
+	_, err = burnRateAlerts("99.9", "30m")
+	assert.ErrorContains(t, err, "shorter than every burn rate window")
+
+	_, err = burnRateAlerts("99.9", "a month")
+	assert.Assert(t, err != nil)
+}
+
+func TestBurnRateWindows(t *testing.T) {
+	windows, err := burnRateWindows("30d")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, windows, []string{"5m", "30m", "1h", "2h", "6h", "1d", "3d"})
+
+	windows, err = burnRateWindows("12h")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, windows, []string{"5m", "30m", "1h", "6h"})
+}
@@ -0,0 +1,184 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// ruleError
+// - A problem with one field of a rendered PrometheusRule, path is the field's location in the YAML
+type ruleError struct {
+	path string
+	err  error
+}
+
+func (e ruleError) Error() string {
+	return fmt.Sprintf("%s: %v", e.path, e.err)
+}
+
+// validatePrometheusRule
+// - Checks a PrometheusRule the way Prometheus checks a rule file before loading it
+func validatePrometheusRule(promrule *monitoringv1.PrometheusRule) []ruleError {
+	errs := []ruleError{}
+
+	if promrule.Name == "" {
+		errs = append(errs, ruleError{"metadata.name", fmt.Errorf("name is required")})
+	}
+
+	if len(promrule.Spec.Groups) == 0 {
+		errs = append(errs, ruleError{"spec.groups", fmt.Errorf("no rule groups")})
+	}
+
+	groupNames := map[string]bool{}
+	for i, group := range promrule.Spec.Groups {
+		groupPath := fmt.Sprintf("spec.groups[%d]", i)
+
+		if group.Name == "" {
+			errs = append(errs, ruleError{groupPath + ".name", fmt.Errorf("group name is required")})
+		} else if groupNames[group.Name] {
+			errs = append(errs, ruleError{groupPath + ".name", fmt.Errorf("repeated group name %q", group.Name)})
+		}
+		groupNames[group.Name] = true
+
+		if group.Interval != "" {
+			if _, err := model.ParseDuration(group.Interval); err != nil {
+				errs = append(errs, ruleError{groupPath + ".interval", err})
+			}
+		}
+
+		for j, rule := range group.Rules {
+			errs = append(errs, validateRule(fmt.Sprintf("%s.rules[%d]", groupPath, j), rule)...)
+		}
+	}
+
+	return errs
+}
+
+func validateRule(path string, rule monitoringv1.Rule) []ruleError {
+	errs := []ruleError{}
+
+	switch {
+	case rule.Alert != "" && rule.Record != "":
+		errs = append(errs, ruleError{path, fmt.Errorf("only one of 'record' and 'alert' must be set")})
+	case rule.Alert == "" && rule.Record == "":
+		errs = append(errs, ruleError{path, fmt.Errorf("one of 'record' or 'alert' must be set")})
+	case rule.Record != "":
+		if !model.IsValidMetricName(model.LabelValue(rule.Record)) {
+			errs = append(errs, ruleError{path + ".record", fmt.Errorf("invalid recording rule name %q", rule.Record)})
+		}
+		if rule.For != "" {
+			errs = append(errs, ruleError{path + ".for", fmt.Errorf("invalid field 'for' in recording rule")})
+		}
+		if len(rule.Annotations) > 0 {
+			errs = append(errs, ruleError{path + ".annotations", fmt.Errorf("invalid field 'annotations' in recording rule")})
+		}
+	}
+
+	if expr := rule.Expr.String(); expr == "" {
+		errs = append(errs, ruleError{path + ".expr", fmt.Errorf("expression is required")})
+	} else if _, err := parser.ParseExpr(expr); err != nil {
+		errs = append(errs, ruleError{path + ".expr", err})
+	}
+
+	if rule.For != "" {
+		if _, err := model.ParseDuration(rule.For); err != nil {
+			errs = append(errs, ruleError{path + ".for", err})
+		}
+	}
+
+	for name := range rule.Labels {
+		if !model.LabelName(name).IsValid() {
+			errs = append(errs, ruleError{path + ".labels." + name, fmt.Errorf("invalid label name %q", name)})
+		}
+	}
+
+	for name := range rule.Annotations {
+		if !model.LabelName(name).IsValid() {
+			errs = append(errs, ruleError{path + ".annotations." + name, fmt.Errorf("invalid annotation name %q", name)})
+		}
+	}
+
+	return errs
+}
+
+// InvalidRule
+// - A rendered PrometheusRule which failed validation and must not be applied
+// - PrometheusRule is nil when the template failed before rendering it, which of the object's existing rules are the template's isn't known so all of them are kept
+type InvalidRule struct {
+	Template       string
+	PrometheusRule *monitoringv1.PrometheusRule
+	Err            error
+}
+
+// InvalidRulesError
+// - Returned alongside the valid PrometheusRules when some of the rendered rules failed validation
+type InvalidRulesError struct {
+	Rules []InvalidRule
+}
+
+func (e *InvalidRulesError) Error() string {
+	messages := make([]string, len(e.Rules))
+	for i, rule := range e.Rules {
+		if rule.PrometheusRule == nil {
+			messages[i] = fmt.Sprintf("template \"%s\": %v", rule.Template, rule.Err)
+			continue
+		}
+		messages[i] = fmt.Sprintf("%s/%s from template \"%s\": %v", rule.PrometheusRule.Namespace, rule.PrometheusRule.Name, rule.Template, rule.Err)
+	}
+
+	return fmt.Sprintf("invalid PrometheusRules: %s", strings.Join(messages, "; "))
+}
+
+// invalidRulesError
+// - Returns an *InvalidRulesError for rules, or nil when there are none
+func invalidRulesError(rules []InvalidRule) error {
+	if len(rules) == 0 {
+		return nil
+	}
+
+	return &InvalidRulesError{Rules: rules}
+}
+
+// rejectedInstanceRules
+// - The InvalidRules of a template instance whose parameters were rejected, err is why
+// - Names come from rendering the template with the rejected parameters, so the instance's existing PrometheusRules are kept as they are
+// - When that fails too, a single InvalidRule without a PrometheusRule keeps all of the object's existing rules
+func rejectedInstanceRules(templateName string, tmpl *template.Template, params interface{}, err error) []InvalidRule {
+	unrendered := []InvalidRule{{Template: templateName, Err: err}}
+
+	var result bytes.Buffer
+	if tmpl.Execute(&result, params) != nil {
+		return unrendered
+	}
+
+	rendered, decodeErr := decodePrometheusRules(&result)
+	if decodeErr != nil || len(rendered) == 0 {
+		return unrendered
+	}
+
+	invalidRules := []InvalidRule{}
+	for _, promrule := range rendered {
+		invalidRules = append(invalidRules, InvalidRule{Template: templateName, PrometheusRule: promrule, Err: err})
+	}
+
+	return invalidRules
+}
+
+// joinRuleErrors
+// - Combines the problems found by validatePrometheusRule into a single error
+func joinRuleErrors(errs []ruleError) error {
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	sort.Strings(messages)
+
+	return fmt.Errorf("%s", strings.Join(messages, ", "))
+}